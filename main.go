@@ -80,11 +80,23 @@ func doMain() (err error) {
 			sprintcore.LumberjackFactory(),
 			sprintcore.AutoupdateService(),
 			service.UserService(),
+			service.UserPurger(),
 			service.SecurityLogService(),
 			service.PageService(),
+			service.PageScheduler(),
+			service.PageRenderer(),
+			service.PageCache(),
+			service.RoleService(),
+			service.LockoutService(),
+			service.PasswordPolicy(),
+			service.SessionService(),
+			service.ApiTokenService(),
+			service.OidcService(),
+			service.WebauthnService(),
+			service.ExportService(),
 
 			glue.Child(sprint.ServerRole,
-				sprintserver.GrpcServerScanner("control-grpc-server"),
+				server.GrpcServerScanner("control-grpc-server"),
 				sprintserver.ControlServer(),
 				server.UIGrpcServer(),
 				sprintserver.HttpServerFactory("control-gateway-server"),
@@ -10,7 +10,9 @@ import (
 	"github.com/keyvalstore/store"
 	"github.com/codeallergy/glue"
 	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/utils"
 	"reflect"
+	"time"
 )
 
 
@@ -27,8 +29,18 @@ type UserService interface {
 
 	ResetPassword(ctx context.Context, userId string, newPassword string) (email string, err error)
 
+	// ErrUserDeleted, ErrUserSuspended or ErrPasswordResetRequired are returned with the user only after the valid password
 	AuthenticateUser(ctx context.Context, login, password string) (*pb.UserEntity, error)
 
+	// requires the current password, ErrUserInvalidPassword on error
+	ChangePassword(ctx context.Context, userId, currentPassword, newPassword string) (email string, err error)
+
+	// replaces email and marks it verified if the current email is still oldEmail, ErrEmailAlreadyUsed if taken
+	ChangeEmail(ctx context.Context, userId, oldEmail, newEmail string) error
+
+	// updates username and names, ErrUsernameNotAvailable if username is taken
+	UpdateProfile(ctx context.Context, userId string, req *pb.UpdateProfileRequest) (*pb.UserEntity, error)
+
 	GetUser(ctx context.Context, userId string) (*pb.UserEntity, error)
 
 	GetUserIdByLogin(ctx context.Context, login string) (string, error)
@@ -41,6 +53,15 @@ type UserService interface {
 
 	RemoveUser(ctx context.Context, userId string) error
 
+	// marks the user deleted for the grace period, ErrUserDeleted if already deleted
+	SoftDeleteUser(ctx context.Context, userId string) error
+
+	// reverts the soft delete, ErrUserNotDeleted if the user is not deleted
+	RestoreUser(ctx context.Context, userId string) error
+
+	// users waiting for the purge
+	EnumDeletedUsers(ctx context.Context, cb func(user *pb.UserEntity) bool) error
+
 	DropUserContent(ctx context.Context, userId string) error
 
 	DoWithUser(ctx context.Context, userId string, cb func(user *pb.UserEntity) error) error
@@ -49,9 +70,131 @@ type UserService interface {
 
 	EnumUsers(ctx context.Context, cb func(user *pb.UserEntity) bool) error
 
+	// pages through the users from the cursor or the offset, returns the cursor of the next page or ErrInvalidCursor
+	ScanUsers(ctx context.Context, cursor string, reverse bool, offset, limit int, cb func(user *pb.UserEntity, position int)) (nextCursor string, err error)
+
+	// number of users capped at the count limit, estimated is true if the limit was reached and the total is the lower bound
+	EstimateUsers(ctx context.Context) (total int, estimated bool, err error)
+
+	// walks the index selected by the prefix or sort, returns the cursor of the next page, ErrInvalidCursor or ErrUnknownSort on error
+	SearchUsers(ctx context.Context, req *pb.AdminUserSearchRequest) (items []*pb.UserEntity, nextCursor string, err error)
+
 	SaveRecoverCode(ctx context.Context, login string, rc *pb.RecoverCodeEntity, ttlSeconds int) error
 
-	ValidateRecoverCode(ctx context.Context, login string, code string) error
+	// single use code bound to the requester IP, removed after the success or too many attempts
+	ValidateRecoverCode(ctx context.Context, login, code, remoteIP string) error
+
+	// marks the user verified if the email is still the current one, ErrInvalidVerifyToken on error
+	VerifyEmail(ctx context.Context, userId, email string) error
+
+	// records the status change with the reason, ErrUserDeleted if the user is deleted, use SoftDeleteUser to delete
+	SetStatus(ctx context.Context, userId string, status pb.UserStatus, reason string) error
+
+	// password login returns ErrPasswordResetRequired until the password is reset
+	SetPasswordResetRequired(ctx context.Context, userId string, required bool) error
+
+	// generates new pending secret, returns secret and otpauth:// provisioning URI
+	EnrollTotp(ctx context.Context, userId, issuer string) (secret string, uri string, err error)
+
+	// enables TOTP after the first valid code, returns plain backup codes, ErrTotpInvalidCode on error
+	ConfirmTotp(ctx context.Context, userId, code string) ([]string, error)
+
+	// accepts TOTP code or backup code, ErrTotpInvalidCode on error
+	DisableTotp(ctx context.Context, userId, code string) error
+
+	// accepts TOTP code or backup code, the backup code is removed after use
+	VerifyTotp(ctx context.Context, userId, code string) (backupCode bool, err error)
+
+	// lookup by identity:provider:subject index, ErrUserNotFound if not linked
+	GetUserIdByIdentity(ctx context.Context, provider, subject string) (string, error)
+
+	// ErrIdentityAlreadyLinked if the identity belongs to another user
+	LinkIdentity(ctx context.Context, userId, provider, subject string) error
+
+	// creates user without password linked to the external identity, ErrEmailNotVerified if the provider did not verify the email,
+	// ErrEmailAlreadyUsed if email is taken
+	CreateExternalUser(ctx context.Context, identity *pb.ExternalIdentity) (*pb.UserEntity, error)
+}
+
+var OidcServiceClass = reflect.TypeOf((*OidcService)(nil)).Elem()
+
+type OidcService interface {
+	glue.InitializingBean
+
+	// names of the configured providers
+	Providers() []string
+
+	// stores state with PKCE verifier and nonce, returns URL of the provider authorization page and the binding value for the browser
+	StartLogin(ctx context.Context, provider, linkUserId string) (authURL, binding string, err error)
+
+	// consumes the state, checks the binding, exchanges code and validates ID token, ErrOidcInvalidState on error
+	FinishLogin(ctx context.Context, state, code, binding string) (identity *pb.ExternalIdentity, linkUserId string, err error)
+}
+
+var ApiTokenServiceClass = reflect.TypeOf((*ApiTokenService)(nil)).Elem()
+
+type ApiTokenService interface {
+
+	// returns the plain token, only the hash is stored, ErrApiTokenLimit if the user has too many tokens
+	CreateToken(ctx context.Context, userId, name string, scopes []string, ttlSeconds int) (token string, entity *pb.ApiTokenEntity, err error)
+
+	// checks hash and expiration, updates the last usage, ErrApiTokenInvalid on error
+	ValidateToken(ctx context.Context, token string) (userId string, entity *pb.ApiTokenEntity, err error)
+
+	EnumTokens(ctx context.Context, userId string, cb func(entity *pb.ApiTokenEntity) bool) error
+
+	// ErrApiTokenNotFound on error
+	RevokeToken(ctx context.Context, userId, tokenId string) error
+
+	// removes all tokens of the user, returns number of removed tokens
+	RevokeAllTokens(ctx context.Context, userId string) (int, error)
+
+}
+
+var WebauthnServiceClass = reflect.TypeOf((*WebauthnService)(nil)).Elem()
+
+type WebauthnService interface {
+	glue.InitializingBean
+
+	// stores the challenge, returns JSON options for navigator.credentials.create()
+	BeginRegistration(ctx context.Context, user *pb.UserEntity) (string, error)
+
+	// verifies the attestation and stores the credential, ErrWebauthnInvalidResponse on error
+	FinishRegistration(ctx context.Context, userId, name string, clientDataJSON, attestationObject []byte) (*pb.WebauthnCredentialEntity, error)
+
+	// stores the challenge, returns JSON options for navigator.credentials.get(), empty userId for passkeys
+	BeginLogin(ctx context.Context, userId string) (string, error)
+
+	// verifies the assertion and updates the sign count, returns the owner of the credential and the UV flag
+	// ErrWebauthnInvalidResponse on error, ErrWebauthnSignCount if the authenticator could be cloned
+	FinishLogin(ctx context.Context, credentialId string, userHandle, clientDataJSON, authenticatorData, signature []byte) (userId string, userVerified bool, err error)
+
+	EnumCredentials(ctx context.Context, userId string, cb func(cred *pb.WebauthnCredentialEntity) bool) error
+
+	// ErrWebauthnCredentialNotFound on error
+	RemoveCredential(ctx context.Context, userId, credentialId string) error
+}
+
+var PasswordHasherClass = reflect.TypeOf((*PasswordHasher)(nil)).Elem()
+
+type PasswordHasher interface {
+
+	// returns encoded hash with the algorithm prefix
+	HashPassword(password string) ([]byte, error)
+
+	// needsRehash is true if the hash was made by an outdated algorithm or cost settings
+	VerifyPassword(hash []byte, password string) (ok bool, needsRehash bool, err error)
+
+}
+
+var PasswordPolicyClass = reflect.TypeOf((*PasswordPolicy)(nil)).Elem()
+
+type PasswordPolicy interface {
+	glue.InitializingBean
+
+	// returns human readable violations, empty if the password is acceptable; identities are username, email and so on
+	Validate(password string, identities ...string) []string
+
 }
 
 var SecurityLogServiceClass = reflect.TypeOf((*SecurityLogService)(nil)).Elem()
@@ -60,23 +203,201 @@ type SecurityLogService interface {
 
 	LogEvent(ctx context.Context, userId, eventName, remoteIP, userAgent string) error
 
+	/**
+	Records the call of the admin on behalf of the user to the logs of both users.
+	 */
+	LogImpersonation(ctx context.Context, adminId, userId, details, remoteIP, userAgent string) error
+
 	EnumEvents(ctx context.Context, userId string, cb func(item *pb.SecurityLogEntity) bool) error
 
+	// pages through the events from the newest one, reverse starts from the oldest
+	ScanEvents(ctx context.Context, userId, cursor string, reverse bool, offset, limit int, cb func(item *pb.SecurityLogEntity, position int)) (nextCursor string, err error)
+
+	// number of events capped the same way as EstimateUsers
+	EstimateEvents(ctx context.Context, userId string) (total int, estimated bool, err error)
+
+}
+
+var SessionServiceClass = reflect.TypeOf((*SessionService)(nil)).Elem()
+
+type SessionService interface {
+
+	// generates session id and the first refresh id, stores the session with the ttl of refresh token
+	CreateSession(ctx context.Context, userId string, session *pb.SessionEntity, ttlSeconds int) (*pb.SessionEntity, error)
+
+	// ErrSessionNotFound on error
+	GetSession(ctx context.Context, userId, sessionId string) (*pb.SessionEntity, error)
+
+	// replaces refresh id, updates last usage and extends ttl, ErrSessionNotFound on error
+	// ErrRefreshTokenReuse if refresh id was already used, the whole session is removed in this case
+	RotateRefreshToken(ctx context.Context, userId, sessionId, refreshId, remoteIP, userAgent string, ttlSeconds int) (*pb.SessionEntity, error)
+
+	RemoveSession(ctx context.Context, userId, sessionId string) error
+
+	// removes all sessions except the given one, returns number of removed sessions
+	RemoveOtherSessions(ctx context.Context, userId, keepSessionId string) (int, error)
+
+	EnumSessions(ctx context.Context, userId string, cb func(session *pb.SessionEntity) bool) error
+
+}
+
+var LockoutServiceClass = reflect.TypeOf((*LockoutService)(nil)).Elem()
+
+type LockoutService interface {
+
+	// returns how long the caller must wait before the next login attempt, zero if allowed, empty userId or remoteIP are skipped
+	CheckLogin(ctx context.Context, userId, remoteIP string) (time.Duration, error)
+
+	// checks the same way as CheckLogin and reserves the account for the attempt in the same transaction,
+	// the reservation ends by LoginFailed, LoginSucceeded or ReleaseLogin
+	ReserveLogin(ctx context.Context, userId, remoteIP string) (time.Duration, error)
+
+	// ends the reservation without counting the attempt
+	ReleaseLogin(ctx context.Context, userId string) error
+
+	// counts failed attempt, returns true for the counter that has just reached the lock threshold
+	LoginFailed(ctx context.Context, userId, remoteIP string) (accountLocked, ipLocked bool, err error)
+
+	LoginSucceeded(ctx context.Context, userId string) error
+
+	Unlock(ctx context.Context, userId string) error
+
+}
+
+var RoleServiceClass = reflect.TypeOf((*RoleService)(nil)).Elem()
+
+type RoleService interface {
+
+	// ErrRoleNotFound on error, returns the built-in admin role too
+	GetRole(ctx context.Context, name string) (*pb.RoleEntity, error)
+
+	// creates or replaces the role, ErrUnknownPermission or ErrRoleBuiltIn on error
+	SaveRole(ctx context.Context, role *pb.RoleEntity) error
+
+	// removes the role and takes it away from all users, ErrRoleNotFound or ErrRoleBuiltIn on error
+	RemoveRole(ctx context.Context, name string) error
+
+	// the built-in admin role goes first
+	EnumRoles(ctx context.Context, cb func(role *pb.RoleEntity) bool) error
+
+	// union of permissions of the user roles, all permissions for UserRole ADMIN
+	GetPermissions(ctx context.Context, user *pb.UserEntity) (map[string]bool, error)
+
 }
 
 var PageServiceClass = reflect.TypeOf((*PageService)(nil)).Elem()
 
 type PageService interface {
+	glue.InitializingBean
 
 	// ErrPageNotFound on error
 	GetPage(ctx context.Context, name string) (*pb.PageEntity, error)
 
-	CreatePage(ctx context.Context, page *pb.AdminPage) error
+	// stores the first revision of the page, the revision is TRUSTED if the author holds the pages.trust permission
+	CreatePage(ctx context.Context, page *pb.AdminPage, authorId, authorName string, authorTrusted bool) error
 
-	UpdatePage(ctx context.Context, page *pb.AdminPage) error
+	// stores the new revision with the change note, keeps the creation time,
+	// TRUSTED page falls back to STANDARD unless the author holds the pages.trust permission
+	UpdatePage(ctx context.Context, page *pb.AdminPage, authorId, authorName string, authorTrusted bool) error
 
 	RemovePage(ctx context.Context, name string) error
 
 	EnumPages(ctx context.Context, cb func(page *pb.PageEntity) bool) error
 
+	ScanPages(ctx context.Context, cursor string, reverse bool, offset, limit int, cb func(page *pb.PageEntity, position int)) (nextCursor string, err error)
+
+	// number of pages capped the same way as EstimateUsers
+	EstimatePages(ctx context.Context) (total int, estimated bool, err error)
+
+	// ErrRevisionNotFound on error
+	GetRevision(ctx context.Context, name string, revision int32) (*pb.PageRevisionEntity, error)
+
+	// pages through the revisions from the newest one, reverse starts from the oldest
+	ScanRevisions(ctx context.Context, name, cursor string, reverse bool, limit int, cb func(rev *pb.PageRevisionEntity)) (nextCursor string, err error)
+
+	// line based diff, to is the last revision if zero, from is the previous one of to if zero
+	DiffRevisions(ctx context.Context, name string, from, to int32) (fromRev, toRev *pb.PageRevisionEntity, lines []utils.DiffLine, err error)
+
+	// stores the content of the revision as the new one, the trust level follows the rules of UpdatePage
+	RollbackPage(ctx context.Context, name string, revision int32, authorId, authorName, note string, authorTrusted bool) (newRevision int32, err error)
+
+	// DRAFT, IN_REVIEW from the draft, PUBLISHED makes the last revision visible, ARCHIVED hides the page; ErrInvalidPageState on error
+	SetPageState(ctx context.Context, name string, state pb.PageState) (*pb.PageEntity, error)
+
+	// zero cancels the time, ErrInvalidSchedule if unpublishing goes before publishing,
+	// the publishing applies to the current revision and is cancelled by the next update or rollback
+	SchedulePage(ctx context.Context, name string, publishAt, unpublishAt int64) error
+
+	// pages with publish or unpublish time before the time
+	EnumScheduledPages(ctx context.Context, dueBefore time.Time, cb func(page *pb.PageEntity) bool) error
+
+	// the page and the revision visible on the site, ErrPageNotFound or ErrPageNotPublished on error
+	GetPublishedPage(ctx context.Context, name string) (*pb.PageEntity, *pb.PageRevisionEntity, error)
+
+	SetPageTrust(ctx context.Context, name string, trust pb.PageTrust) error
+
+}
+
+var PageRendererClass = reflect.TypeOf((*PageRenderer)(nil)).Elem()
+
+type PageRenderer interface {
+	glue.InitializingBean
+
+	// HTML of the page content sanitized by the trust level
+	Render(content string, contentType pb.ContentType, trust pb.PageTrust) string
+
+}
+
+var PageCacheClass = reflect.TypeOf((*PageCache)(nil)).Elem()
+
+type PageCache interface {
+	glue.InitializingBean
+
+	// rendered page by the name, the published revision and the trust level with the ETag and the publishing time,
+	// render is called on the miss
+	Load(page *pb.PageEntity, rev *pb.PageRevisionEntity, render func() *pb.PageContent) (content *pb.PageContent, etag string, modified int64)
+
+	// drops the rendered page after the change
+	Invalidate(name string)
+
+	// hit and miss counters
+	GetStats(cb func(name, value string) bool) error
+
+}
+
+var PageSchedulerClass = reflect.TypeOf((*PageScheduler)(nil)).Elem()
+
+type PageScheduler interface {
+	glue.InitializingBean
+	glue.DisposableBean
+
+	// publishes and archives pages with the due time, returns number of changed pages
+	ApplySchedule(ctx context.Context, now time.Time) (int, error)
+
+}
+
+var UserPurgerClass = reflect.TypeOf((*UserPurger)(nil)).Elem()
+
+type UserPurger interface {
+	glue.InitializingBean
+	glue.DisposableBean
+
+	// drops the content of users deleted before the time and sends the goodbye mail, returns number of purged users
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int, error)
+
+	// time of the purge for the deleted user
+	PurgeTime(user *pb.UserEntity) time.Time
+
+}
+
+var ExportServiceClass = reflect.TypeOf((*ExportService)(nil)).Elem()
+
+type ExportService interface {
+
+	// collects all entities under the user prefix in json or zip format, stores the result for the download, ErrUnknownExportFormat on error
+	ExportUser(ctx context.Context, userId, format string) (*pb.ExportEntity, error)
+
+	// ErrExportNotFound if the export is expired
+	GetExport(ctx context.Context, exportId string) (*pb.ExportEntity, error)
+
 }
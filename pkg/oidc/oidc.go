@@ -0,0 +1,445 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+/**
+Package oidc is a minimal OpenID Connect relying party: authorization code flow with PKCE,
+discovery, JWKS cache and ID token validation.
+ */
+
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt"
+	"github.com/pkg/errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	discoveryPath    = "/.well-known/openid-configuration"
+	jwksRefreshLimit = time.Minute
+	maxResponseSize  = 1 << 20
+	verifierSize     = 32
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid id token")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+var validMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// optional, override the discovery document
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JwksURI               string
+}
+
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu          sync.Mutex
+	discovery   *discoveryDocument
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+func NewProvider(config Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{
+		config: config,
+		client: client,
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+/**
+Generates random PKCE code verifier, RFC 7636.
+ */
+func GenerateCodeVerifier() (string, error) {
+	b := make([]byte, verifierSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURI, state, nonce, codeVerifier string) (string, error) {
+
+	doc, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(doc.AuthorizationEndpoint)
+	if err != nil {
+		return "", errors.Errorf("invalid authorization endpoint '%s', %v", doc.AuthorizationEndpoint, err)
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(p.config.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", CodeChallengeS256(codeVerifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+/**
+Exchanges authorization code to the ID token and validates it.
+ */
+func (p *Provider) Exchange(ctx context.Context, redirectURI, code, codeVerifier, nonce string) (*Claims, error) {
+
+	doc, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", codeVerifier)
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var resp tokenResponse
+	status, err := p.doJSON(req, &resp)
+	if err != nil {
+		return nil, errors.Errorf("token endpoint of '%s', %v", p.config.Name, err)
+	}
+	if resp.Error != "" {
+		return nil, errors.Errorf("token endpoint of '%s' returned '%s', %s", p.config.Name, resp.Error, resp.ErrorDescription)
+	}
+	if status != http.StatusOK {
+		return nil, errors.Errorf("token endpoint of '%s' returned status %d", p.config.Name, status)
+	}
+	if resp.IDToken == "" {
+		return nil, errors.Errorf("token endpoint of '%s' returned no id_token", p.config.Name)
+	}
+
+	return p.Verify(ctx, resp.IDToken, nonce)
+}
+
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+
+	doc, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &jwt.Parser{ValidMethods: validMethods}
+	claims := jwt.MapClaims{}
+
+	_, err = parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.getKey(ctx, doc, kid)
+	})
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+
+	if !claims.VerifyIssuer(doc.Issuer, true) {
+		return nil, errors.Wrap(ErrInvalidToken, "issuer mismatch")
+	}
+	if !claims.VerifyAudience(p.config.ClientID, true) {
+		return nil, errors.Wrap(ErrInvalidToken, "audience mismatch")
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.Wrap(ErrInvalidToken, "token expired")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.config.ClientID {
+		return nil, errors.Wrap(ErrInvalidToken, "authorized party mismatch")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce == "" || tokenNonce != nonce {
+		return nil, errors.Wrap(ErrInvalidToken, "nonce mismatch")
+	}
+
+	result := &Claims{
+		Subject:           stringClaim(claims, "sub"),
+		Email:             stringClaim(claims, "email"),
+		Name:              stringClaim(claims, "name"),
+		PreferredUsername: stringClaim(claims, "preferred_username"),
+	}
+	if result.Subject == "" {
+		return nil, errors.Wrap(ErrInvalidToken, "empty subject")
+	}
+
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = v
+	case string:
+		// some providers send it as a string
+		result.EmailVerified = v == "true"
+	}
+
+	return result, nil
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+func (p *Provider) getDiscovery(ctx context.Context) (*discoveryDocument, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	doc := &discoveryDocument{
+		Issuer:                p.config.Issuer,
+		AuthorizationEndpoint: p.config.AuthorizationEndpoint,
+		TokenEndpoint:         p.config.TokenEndpoint,
+		JwksURI:               p.config.JwksURI,
+	}
+
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JwksURI == "" {
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(p.config.Issuer, "/")+discoveryPath, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var loaded discoveryDocument
+		status, err := p.doJSON(req, &loaded)
+		if err != nil {
+			return nil, errors.Errorf("discovery of '%s', %v", p.config.Name, err)
+		}
+		if status != http.StatusOK {
+			return nil, errors.Errorf("discovery of '%s' returned status %d", p.config.Name, status)
+		}
+		if loaded.Issuer != p.config.Issuer {
+			return nil, errors.Errorf("discovery of '%s' returned issuer '%s' instead of '%s'", p.config.Name, loaded.Issuer, p.config.Issuer)
+		}
+
+		if doc.AuthorizationEndpoint == "" {
+			doc.AuthorizationEndpoint = loaded.AuthorizationEndpoint
+		}
+		if doc.TokenEndpoint == "" {
+			doc.TokenEndpoint = loaded.TokenEndpoint
+		}
+		if doc.JwksURI == "" {
+			doc.JwksURI = loaded.JwksURI
+		}
+	}
+
+	p.discovery = doc
+	return doc, nil
+}
+
+func (p *Provider) getKey(ctx context.Context, doc *discoveryDocument, kid string) (crypto.PublicKey, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.findKey(kid); ok {
+		return key, nil
+	}
+
+	// keys are rotated by the provider, reload but not too often
+	if time.Since(p.keysFetched) < jwksRefreshLimit {
+		return nil, ErrUnknownKey
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, doc.JwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	status, err := p.doJSON(req, &jwks)
+	if err != nil {
+		return nil, errors.Errorf("jwks of '%s', %v", p.config.Name, err)
+	}
+	if status != http.StatusOK {
+		return nil, errors.Errorf("jwks of '%s' returned status %d", p.config.Name, status)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// skip unsupported keys
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	if key, ok := p.findKey(kid); ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (p *Provider) findKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *Provider) doJSON(req *http.Request, out interface{}) (int, error) {
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if err := json.Unmarshal(content, out); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, nil
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+
+	default:
+		return nil, errors.Errorf("unsupported key type '%s'", k.Kty)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt"
+	"github.com/sprintframework/template/pkg/oidc"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientId    = "test-client"
+	testRedirectURI = "https://example.com/oidc/callback"
+)
+
+type authRequest struct {
+	nonce     string
+	challenge string
+}
+
+/**
+Local OIDC provider, the authorization step is simulated by the authorize method.
+ */
+type stubProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authRequest
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &stubProvider{
+		key:   key,
+		codes: make(map[string]authRequest),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kid": "k1",
+					"kty": "RSA",
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+
+		r.ParseForm()
+
+		p.mu.Lock()
+		req, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.mu.Unlock()
+
+		if !ok || oidc.CodeChallengeS256(r.PostForm.Get("code_verifier")) != req.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"id_token":     p.signToken(t, req.nonce, testClientId),
+		})
+	})
+
+	p.server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *stubProvider) authorize(t *testing.T, authURL string) (state, code string) {
+
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+
+	q := u.Query()
+	require.Equal(t, testClientId, q.Get("client_id"))
+	require.Equal(t, testRedirectURI, q.Get("redirect_uri"))
+	require.Equal(t, "S256", q.Get("code_challenge_method"))
+
+	code = "code-" + q.Get("state")
+
+	p.mu.Lock()
+	p.codes[code] = authRequest{
+		nonce:     q.Get("nonce"),
+		challenge: q.Get("code_challenge"),
+	}
+	p.mu.Unlock()
+
+	return q.Get("state"), code
+}
+
+func (p *stubProvider) signToken(t *testing.T, nonce, audience string) string {
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.server.URL,
+		"sub":            "12345",
+		"aud":            audience,
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "user@example.com",
+		"email_verified": true,
+		"name":           "Test User",
+	})
+	token.Header["kid"] = "k1"
+
+	signed, err := token.SignedString(p.key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+
+	stub := newStubProvider(t)
+	defer stub.server.Close()
+
+	provider := oidc.NewProvider(oidc.Config{
+		Name:     "stub",
+		Issuer:   stub.server.URL,
+		ClientID: testClientId,
+	}, nil)
+
+	ctx := context.Background()
+
+	verifier, err := oidc.GenerateCodeVerifier()
+	require.NoError(t, err)
+
+	authURL, err := provider.AuthCodeURL(ctx, testRedirectURI, "state1", "nonce1", verifier)
+	require.NoError(t, err)
+
+	_, code := stub.authorize(t, authURL)
+
+	// wrong PKCE verifier
+	_, err = provider.Exchange(ctx, testRedirectURI, code, "wrong", "nonce1")
+	require.Error(t, err)
+
+	authURL, err = provider.AuthCodeURL(ctx, testRedirectURI, "state2", "nonce2", verifier)
+	require.NoError(t, err)
+	_, code = stub.authorize(t, authURL)
+
+	claims, err := provider.Exchange(ctx, testRedirectURI, code, verifier, "nonce2")
+	require.NoError(t, err)
+	require.Equal(t, "12345", claims.Subject)
+	require.Equal(t, "user@example.com", claims.Email)
+	require.True(t, claims.EmailVerified)
+
+	// code is single use
+	_, err = provider.Exchange(ctx, testRedirectURI, code, verifier, "nonce2")
+	require.Error(t, err)
+
+}
+
+func TestVerifyIDToken(t *testing.T) {
+
+	stub := newStubProvider(t)
+	defer stub.server.Close()
+
+	provider := oidc.NewProvider(oidc.Config{
+		Name:     "stub",
+		Issuer:   stub.server.URL,
+		ClientID: testClientId,
+	}, nil)
+
+	ctx := context.Background()
+
+	_, err := provider.Verify(ctx, stub.signToken(t, "nonce", testClientId), "nonce")
+	require.NoError(t, err)
+
+	_, err = provider.Verify(ctx, stub.signToken(t, "nonce", testClientId), "other")
+	require.Error(t, err)
+
+	_, err = provider.Verify(ctx, stub.signToken(t, "nonce", "other-client"), "nonce")
+	require.Error(t, err)
+
+	// signed by unknown key
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	stub.key = otherKey
+	_, err = provider.Verify(ctx, stub.signToken(t, "nonce", testClientId), "nonce")
+	require.Error(t, err)
+
+}
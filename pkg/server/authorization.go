@@ -0,0 +1,318 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/sprintframework/sprint"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	apiTokenContextKey = "pat"
+	apiTokenScopeUser  = "user"
+	apiTokenScopeAdmin = "admin"
+
+	// role of the node token used by the command line, it has all permissions
+	nodeAdminRole = "ADMIN"
+)
+
+type methodPolicy struct {
+	policy *pb.AuthPolicy  // nil if the method does not have the option
+	own    bool            // method of the lighttemplate services
+}
+
+/**
+Authenticates the call and checks the (auth) policy of the method, so handlers only need to get the user.
+Methods of the framework services do not have the policy and check the roles themselves.
+ */
+func (t *implGrpcServerFactory) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+
+	ctx, err := t.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mp := t.getPolicy(fullMethod)
+	if mp.policy == nil {
+		if mp.own {
+			return nil, status.Errorf(codes.PermissionDenied, "method '%s' has no auth policy", fullMethod)
+		}
+		return ctx, nil
+	}
+
+	if user, ok := t.AuthorizationMiddleware.GetUser(ctx); ok && user.Context[impersonatorContextKey] != "" {
+		if err := t.auditImpersonation(ctx, user, fullMethod, mp.policy); err != nil {
+			return nil, err
+		}
+	}
+
+	if mp.policy.Public {
+		return ctx, nil
+	}
+
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	if err := t.checkActiveUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	if !hasAnyRole(user, mp.policy.Roles) {
+		return nil, status.Errorf(codes.PermissionDenied, "role %s is required", strings.Join(mp.policy.Roles, " or "))
+	}
+
+	if mp.policy.Permission != "" && !user.Roles[nodeAdminRole] {
+		if err := t.checkPermission(ctx, user, mp.policy.Permission); err != nil {
+			return nil, err
+		}
+	}
+
+	return ctx, nil
+}
+
+/**
+Personal access token is exchanged to the short living JWT with roles of its scopes,
+so handlers see the same AuthorizedUser as for the interactive login.
+ */
+func (t *implGrpcServerFactory) authenticate(ctx context.Context) (context.Context, error) {
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok {
+		if auth := md.Get("authorization"); len(auth) == 1 && strings.HasPrefix(auth[0], "Bearer "+service.ApiTokenPrefix) {
+			token, err := t.exchangeApiToken(ctx, strings.TrimPrefix(auth[0], "Bearer "))
+			if err != nil {
+				return nil, err
+			}
+			md = md.Copy()
+			md.Set("authorization", "Bearer "+token)
+			ctx = metadata.NewIncomingContext(ctx, md)
+		}
+	}
+
+	return t.AuthorizationMiddleware.Authenticate(ctx)
+}
+
+/**
+Every call of the impersonating admin goes to the security logs of both users, including the denied ones.
+ */
+func (t *implGrpcServerFactory) auditImpersonation(ctx context.Context, user *sprint.AuthorizedUser, fullMethod string, policy *pb.AuthPolicy) error {
+
+	adminId := user.Context[impersonatorContextKey]
+	remoteIP, userAgent := getCallerInfo(ctx)
+
+	err := t.SecurityLogService.LogImpersonation(ctx, adminId, user.Username, fullMethod, remoteIP, userAgent)
+	if err != nil {
+		id := t.NodeService.Issue().String()
+		t.Log.Error("LogImpersonation", zap.String("errorId", id), zap.String("adminId", adminId), zap.String("userId", user.Username), zap.Error(err))
+		return status.Errorf(codes.Internal, "internal error %s", id)
+	}
+
+	if policy.DenyImpersonation {
+		return status.Errorf(codes.PermissionDenied, "method is not allowed during impersonation")
+	}
+
+	return nil
+}
+
+func (t *implGrpcServerFactory) getPolicy(fullMethod string) *methodPolicy {
+
+	if v, ok := t.policies.Load(fullMethod); ok {
+		return v.(*methodPolicy)
+	}
+
+	mp := new(methodPolicy)
+
+	// "/lighttemplate.SiteService/Page" -> "lighttemplate.SiteService.Page"
+	name := strings.Replace(strings.TrimPrefix(fullMethod, "/"), "/", ".", 1)
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+	if err == nil {
+		if md, ok := desc.(protoreflect.MethodDescriptor); ok {
+			mp.own = md.ParentFile().Package() == pb.File_auth_options_proto.Package()
+			if proto.HasExtension(md.Options(), pb.E_Auth) {
+				mp.policy, _ = proto.GetExtension(md.Options(), pb.E_Auth).(*pb.AuthPolicy)
+			}
+		}
+	}
+
+	t.policies.Store(fullMethod, mp)
+	return mp
+}
+
+func (t *implGrpcServerFactory) checkPermission(ctx context.Context, user *sprint.AuthorizedUser, permission string) error {
+
+	current, ok := toCurrentUser(user)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	// roles could change during the life of the token, always check the current ones
+	permissions, err := t.getUserPermissions(ctx, current.UserId)
+	if err == service.ErrUserNotFound {
+		return status.Errorf(codes.Unauthenticated, "user not found")
+	}
+	if err != nil {
+		id := t.NodeService.Issue().String()
+		t.Log.Error("CheckPermission", zap.String("errorId", id), zap.String("userId", current.UserId), zap.Error(err))
+		return status.Errorf(codes.Internal, "internal error %s", id)
+	}
+
+	if !permissions[permission] {
+		return status.Errorf(codes.PermissionDenied, "permission '%s' is required", permission)
+	}
+
+	return nil
+}
+
+/**
+Access tokens stay valid until the expiration, so the account status and the session of the token are checked
+on every call. Suspension, deletion, forced password reset and revoked sessions take effect immediately.
+ */
+func (t *implGrpcServerFactory) checkActiveUser(ctx context.Context, user *sprint.AuthorizedUser) error {
+
+	if user.Roles[nodeAdminRole] {
+		return nil
+	}
+
+	current, ok := toCurrentUser(user)
+	if !ok {
+		// handlers reject tokens without the user
+		return nil
+	}
+
+	entity, err := t.UserService.GetUser(ctx, current.UserId)
+	if err == service.ErrUserNotFound {
+		return status.Errorf(codes.Unauthenticated, "user not found")
+	}
+	if err != nil {
+		id := t.NodeService.Issue().String()
+		t.Log.Error("CheckActiveUser", zap.String("errorId", id), zap.String("userId", current.UserId), zap.Error(err))
+		return status.Errorf(codes.Internal, "internal error %s", id)
+	}
+
+	if checkUserStatus(entity) != nil || t.RequireVerifiedEmail && !entity.Verified {
+		return status.Errorf(codes.Unauthenticated, "account is not active")
+	}
+
+	if sessionId := current.Context[sessionContextKey]; sessionId != "" {
+		_, err = t.SessionService.GetSession(ctx, current.UserId, sessionId)
+		if err == service.ErrSessionNotFound {
+			return status.Errorf(codes.Unauthenticated, "session revoked")
+		}
+		if err != nil {
+			id := t.NodeService.Issue().String()
+			t.Log.Error("CheckActiveUser", zap.String("errorId", id), zap.String("userId", current.UserId), zap.Error(err))
+			return status.Errorf(codes.Internal, "internal error %s", id)
+		}
+	}
+
+	return nil
+}
+
+func (t *implGrpcServerFactory) getUserPermissions(ctx context.Context, userId string) (map[string]bool, error) {
+
+	entity, err := t.UserService.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.RoleService.GetPermissions(ctx, entity)
+}
+
+func (t *implGrpcServerFactory) exchangeApiToken(ctx context.Context, token string) (string, error) {
+
+	userId, entity, err := t.ApiTokenService.ValidateToken(ctx, token)
+	if err == nil {
+		var user *pb.UserEntity
+		user, err = t.UserService.GetUser(ctx, userId)
+		if err == nil {
+			// the token is not the login, but suspended or deleted accounts lose the access the same way
+			if statusErr := checkUserStatus(user); statusErr != nil || user.PasswordResetRequired {
+				t.Log.Warn("ApiTokenRefused", zap.String("userId", userId), zap.String("tokenId", entity.TokenId),
+					zap.String("status", user.Status.String()), zap.Bool("passwordResetRequired", user.PasswordResetRequired))
+				return "", status.Errorf(codes.Unauthenticated, "api token is not allowed for the account")
+			}
+			return t.generateApiAccessToken(user, entity)
+		}
+	}
+
+	if err != service.ErrApiTokenInvalid && err != service.ErrUserNotFound {
+		t.Log.Error("ApiToken", zap.Error(err))
+	}
+	return "", status.Errorf(codes.Unauthenticated, "invalid api token")
+}
+
+func (t *implGrpcServerFactory) generateApiAccessToken(user *pb.UserEntity, entity *pb.ApiTokenEntity) (string, error) {
+
+	roles := make(map[string]bool)
+	for _, scope := range entity.Scopes {
+		switch scope {
+		case apiTokenScopeUser:
+			roles["WEB_USER"] = true
+		case apiTokenScopeAdmin:
+			// the user could lose the admin access after the token was created
+			if hasAdminAccess(user) {
+				roles["WEB_ADMIN"] = true
+			}
+		}
+	}
+
+	expiresAt := time.Now().Add(time.Minute * time.Duration(t.AccessTokenMinutes)).Unix()
+	if entity.ExpireTimestamp < expiresAt {
+		expiresAt = entity.ExpireTimestamp
+	}
+
+	return t.AuthorizationMiddleware.GenerateToken(&sprint.AuthorizedUser{
+		Username:  user.UserId,
+		Roles:     roles,
+		Context:   map[string]string{
+			usernameContextKey: user.Username,
+			apiTokenContextKey: entity.TokenId,
+		},
+		ExpiresAt: expiresAt,
+	})
+}
+
+func hasAnyRole(user *sprint.AuthorizedUser, roles []string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+	for _, role := range roles {
+		if user.Roles[role] {
+			return true
+		}
+	}
+	return false
+}
+
+// WEB_ADMIN role in the token opens the admin part of the webapp, permissions are checked per method
+func hasAdminAccess(entity *pb.UserEntity) bool {
+	return entity.Role == pb.UserRole_ADMIN || len(entity.Roles) > 0
+}
+
+func sortedPermissions(permissions map[string]bool) []string {
+	var list []string
+	for p, ok := range permissions {
+		if ok {
+			list = append(list, p)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/sprintframework/sprint"
+)
+
+const (
+	usernameContextKey         = "name"
+	impersonatorContextKey     = "imp"
+	impersonatorNameContextKey = "imp_name"
+)
+
+/**
+User of the web token. Tokens are issued for the immutable user id, the username is only a display claim
+and could be outdated after the user changed it.
+ */
+type CurrentUser struct {
+	UserId   string
+	Username string
+	Roles    map[string]bool
+	Context  map[string]string
+	Token    string
+
+	ImpersonatorId   string  // admin user id if the token is issued by AdminImpersonate
+	ImpersonatorName string
+}
+
+func (t *implUIGrpcServer) CurrentUser(ctx context.Context) (*CurrentUser, bool) {
+	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	if !ok {
+		return nil, false
+	}
+	return toCurrentUser(user)
+}
+
+func toCurrentUser(user *sprint.AuthorizedUser) (*CurrentUser, bool) {
+	// tokens issued before the user id subject do not have the claim
+	username, ok := user.Context[usernameContextKey]
+	if !ok || user.Username == "" {
+		return nil, false
+	}
+	return &CurrentUser{
+		UserId:   user.Username,
+		Username: username,
+		Roles:    user.Roles,
+		Context:  user.Context,
+		Token:    user.Token,
+		ImpersonatorId:   user.Context[impersonatorContextKey],
+		ImpersonatorName: user.Context[impersonatorNameContextKey],
+	}, true
+}
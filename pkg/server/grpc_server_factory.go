@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/codeallergy/glue"
+	"github.com/sprintframework/sprint"
+	"github.com/sprintframework/sprintframework/sprintserver"
+	"github.com/sprintframework/sprintframework/sprintutils"
+	"github.com/sprintframework/template/pkg/api"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+type grpcServerScanner struct {
+	beanName string
+	scan     []interface{}
+}
+
+/**
+Same as sprintserver.GrpcServerScanner, but the grpc server is created with the authorization interceptor
+that enforces the (auth) policy of the rpc methods.
+ */
+func GrpcServerScanner(beanName string, scan... interface{}) glue.Scanner {
+	return &grpcServerScanner{
+		beanName: beanName,
+		scan:     scan,
+	}
+}
+
+func (t *grpcServerScanner) Beans() []interface{} {
+	beans := []interface{}{
+		sprintserver.AuthorizationMiddleware(),
+		GrpcServerFactory(t.beanName),
+		&struct {
+			// make them visible
+			Servers     []sprint.Server `inject:"optional"`
+			GrpcServers []*grpc.Server  `inject:"optional"`
+			HttpServers []*http.Server  `inject:"optional"`
+		}{},
+	}
+	return append(beans, t.scan...)
+}
+
+type implGrpcServerFactory struct {
+
+	Properties              glue.Properties                `inject`
+	Log                     *zap.Logger                    `inject`
+	AuthorizationMiddleware sprint.AuthorizationMiddleware `inject`
+	NodeService             sprint.NodeService             `inject`
+
+	UserService             api.UserService      `inject`
+	RoleService             api.RoleService      `inject`
+	ApiTokenService         api.ApiTokenService  `inject`
+	SessionService          api.SessionService   `inject`
+	SecurityLogService      api.SecurityLogService  `inject`
+
+	AccessTokenMinutes   int   `value:"auth.access-token-minutes,default=20"`
+	RequireVerifiedEmail bool  `value:"auth.require-verified-email,default=false"`
+
+	beanName  string
+	policies  sync.Map   // key is the full method name, value is *methodPolicy
+}
+
+func GrpcServerFactory(beanName string) glue.FactoryBean {
+	return &implGrpcServerFactory{beanName: beanName}
+}
+
+func (t *implGrpcServerFactory) Object() (object interface{}, err error) {
+
+	defer sprintutils.PanicToError(&err)
+
+	listenAddr := t.Properties.GetString( fmt.Sprintf("%s.%s", t.beanName, "bind-address"), "")
+
+	t.Log.Info("GrpcServerFactory",
+		zap.String("listenAddr", listenAddr),
+		zap.String("bean", t.beanName))
+
+	return t.createServer()
+}
+
+func (t *implGrpcServerFactory) ObjectType() reflect.Type {
+	return sprint.GrpcServerClass
+}
+
+func (t *implGrpcServerFactory) ObjectName() string {
+	return t.beanName
+}
+
+func (t *implGrpcServerFactory) Singleton() bool {
+	return true
+}
+
+func (t *implGrpcServerFactory) createServer() (*grpc.Server, error) {
+
+	var opts []grpc.ServerOption
+
+	opts = append(opts, grpc.StreamInterceptor(t.streamInterceptor))
+	opts = append(opts, grpc.UnaryInterceptor(t.unaryInterceptor))
+
+	return grpc.NewServer(opts...), nil
+}
+
+func (t *implGrpcServerFactory) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	ctx, err := t.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (t *implGrpcServerFactory) streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	ctx, err := t.authorize(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &authorizedStream{ServerStream: stream, ctx: ctx})
+}
+
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (t *authorizedStream) Context() context.Context {
+	return t.ctx
+}
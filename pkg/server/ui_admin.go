@@ -11,6 +11,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sprintframework/template/pkg/pb"
 	"github.com/sprintframework/template/pkg/service"
+	"github.com/sprintframework/template/pkg/utils"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
@@ -19,67 +20,74 @@ import (
 
 func (t *implUIGrpcServer) AdminPageScan(ctx context.Context, req *pb.AdminScanRequest) (resp *pb.AdminPageScanResponse, err error) {
 
-	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !user.Roles["WEB_ADMIN"] {
-		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
 	}
 
 	defer func() {
 
 		if err != nil {
-			err = t.wrapError(err, "AdminPageScan", user.Username)
+			err = t.wrapError(err, "AdminPageScan", user.UserId)
 		}
 
 	}()
 
-	offset := int(req.Offset)
-	if offset < 0 {
-		offset = 0
-	}
-	limit := int(req.Limit)
-
-	var total int
-	var items []*pb.PageItem
-	err = t.PageService.EnumPages(ctx, func(page *pb.PageEntity) bool {
-		if offset > 0 {
-			offset--
-		} else if limit > 0 {
-			items = append(items, &pb.PageItem{
-				Position:     int32(total + 1),
-				Name:         page.Name,
-				Title:        page.Title,
-				CreatedAt:    page.CreTimestamp,
-			})
-			limit--
+	resp = new(pb.AdminPageScanResponse)
+
+	if req.Cursor == "" || req.WithTotal {
+		total, estimated, err := t.PageService.EstimatePages(ctx)
+		if err != nil {
+			return nil, err
 		}
-		total++
-		return true
-	})
+		resp.Total = int32(total)
+		resp.TotalEstimated = estimated
+	}
 
+	resp.NextCursor, err = t.PageService.ScanPages(ctx, req.Cursor, req.Reverse, int(req.Offset), int(req.Limit), func(page *pb.PageEntity, position int) {
+		resp.Items = append(resp.Items, &pb.PageItem{
+			Position:     int32(position),
+			Name:         page.Name,
+			Title:        page.Title,
+			CreatedAt:    page.CreTimestamp,
+			ModifiedAt:   page.ModTimestamp,
+			Revision:     page.Revision,
+			State:        page.State.String(),
+			PublishedRevision: page.PublishedRevision,
+		})
+	})
+	if err == service.ErrInvalidCursor {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid cursor")
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &pb.AdminPageScanResponse{Items: items, Total: int32(total)}, nil
+	return resp, nil
 
 }
 
 func (t *implUIGrpcServer) AdminCreatePage(ctx context.Context, req *pb.AdminPage) (resp *emptypb.Empty, err error) {
 
-	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !user.Roles["WEB_ADMIN"] {
-		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
 	}
 
 	defer func() {
 
 		if err != nil {
-			err = t.wrapError(err, "AdminCreatePage", user.Username)
+			err = t.wrapError(err, "AdminCreatePage", user.UserId)
 		}
 
 	}()
 
-	err = t.PageService.CreatePage(ctx, req)
+	trusted, err := t.canTrustPages(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.PageService.CreatePage(ctx, req, user.UserId, user.Username, trusted)
 	return &emptypb.Empty{}, err
 
 }
@@ -99,9 +107,9 @@ func (t *implUIGrpcServer) parseContentType(ct string) (pb.ContentType, error) {
 
 func (t *implUIGrpcServer) AdminGetPage(ctx context.Context, req *pb.PageName) (*pb.AdminPage, error) {
 
-	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !user.Roles["WEB_ADMIN"] {
-		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
 	}
 
 	page, err := t.PageService.GetPage(ctx, req.Name)
@@ -109,7 +117,7 @@ func (t *implUIGrpcServer) AdminGetPage(ctx context.Context, req *pb.PageName) (
 		return nil, status.Errorf(codes.NotFound, "page not found")
 	}
 	if err != nil {
-		return nil, t.wrapError(err, "AdminGetPage", user.Username)
+		return nil, t.wrapError(err, "AdminGetPage", user.UserId)
 	}
 
 	return &pb.AdminPage{
@@ -117,40 +125,53 @@ func (t *implUIGrpcServer) AdminGetPage(ctx context.Context, req *pb.PageName) (
 		Title:       page.Title,
 		Content:     page.Content,
 		ContentType: page.ContentType.String(),
+		CreatedAt:   page.CreTimestamp,
+		ModifiedAt:  page.ModTimestamp,
+		Revision:    page.Revision,
+		State:       page.State.String(),
+		PublishedRevision: page.PublishedRevision,
+		PublishAt:   page.PublishAt,
+		UnpublishAt: page.UnpublishAt,
+		Trust:       page.Trust.String(),
 	}, nil
 
 }
 
 func (t *implUIGrpcServer) AdminUpdatePage(ctx context.Context, req *pb.AdminPage) (resp *emptypb.Empty, err error) {
 
-	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !user.Roles["WEB_ADMIN"] {
-		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
 	}
 
 	defer func() {
 
 		if err != nil {
-			err = t.wrapError(err, "AdminSavePage", user.Username)
+			err = t.wrapError(err, "AdminSavePage", user.UserId)
 		}
 
 	}()
 
-	err = t.PageService.UpdatePage(ctx, req)
+	trusted, err := t.canTrustPages(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.PageService.UpdatePage(ctx, req, user.UserId, user.Username, trusted)
 	return &emptypb.Empty{}, err
 
 }
 
 func (t *implUIGrpcServer) AdminDeletePage(ctx context.Context, req *pb.PageName) (*emptypb.Empty, error) {
 
-	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !user.Roles["WEB_ADMIN"] {
-		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
 	}
 
 	err := t.PageService.RemovePage(ctx, req.Name)
 	if err != nil {
-		return nil, t.wrapError(err, "AdminDeletePage", user.Username)
+		return nil, t.wrapError(err, "AdminDeletePage", user.UserId)
 	}
 
 	return &emptypb.Empty{}, nil
@@ -159,59 +180,97 @@ func (t *implUIGrpcServer) AdminDeletePage(ctx context.Context, req *pb.PageName
 
 func (t *implUIGrpcServer) AdminUserScan(ctx context.Context, req *pb.AdminScanRequest) (resp *pb.AdminUserScanResponse, err error) {
 
-	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !user.Roles["WEB_ADMIN"] {
-		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
 	}
 
 	defer func() {
 
 		if err != nil {
-			err = t.wrapError(err, "AdminUserScan", user.Username)
+			err = t.wrapError(err, "AdminUserScan", user.UserId)
 		}
 
 	}()
 
-	offset := int(req.Offset)
-	if offset < 0 {
-		offset = 0
-	}
-	limit := int(req.Limit)
-
-	var total int
-	var items []*pb.UserItem
-	err = t.UserService.EnumUsers(ctx, func(user *pb.UserEntity) bool {
-		if offset > 0 {
-			offset--
-		} else if limit > 0 {
-			items = append(items, &pb.UserItem{
-				Position:     int32(total + 1),
-				Id:           user.UserId,
-				Username:     user.Username,
-				Email:        user.Email,
-				FullName:     getFullName(user),
-				Role:         user.Role.String(),
-				CreatedAt:    user.CreTimestamp,
-			})
-			limit--
+	resp = new(pb.AdminUserScanResponse)
+
+	if req.Cursor == "" || req.WithTotal {
+		total, estimated, err := t.UserService.EstimateUsers(ctx)
+		if err != nil {
+			return nil, err
 		}
-		total++
-		return true
+		resp.Total = int32(total)
+		resp.TotalEstimated = estimated
+	}
+
+	resp.NextCursor, err = t.UserService.ScanUsers(ctx, req.Cursor, req.Reverse, int(req.Offset), int(req.Limit), func(user *pb.UserEntity, position int) {
+		resp.Items = append(resp.Items, toUserItem(user, position))
 	})
+	if err == service.ErrInvalidCursor {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid cursor")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
 
+}
+
+func (t *implUIGrpcServer) AdminUserSearch(ctx context.Context, req *pb.AdminUserSearchRequest) (resp *pb.AdminUserSearchResponse, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminUserSearch", user.UserId)
+		}
+
+	}()
+
+	list, nextCursor, err := t.UserService.SearchUsers(ctx, req)
+	if err == service.ErrInvalidCursor {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid cursor")
+	}
+	if err == service.ErrUnknownSort {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown sort '%s'", req.Sort)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &pb.AdminUserScanResponse{Items: items, Total: int32(total)}, nil
+	resp = &pb.AdminUserSearchResponse{NextCursor: nextCursor}
+	for i, user := range list {
+		resp.Items = append(resp.Items, toUserItem(user, i + 1))
+	}
+
+	return resp, nil
+}
 
+func toUserItem(user *pb.UserEntity, position int) *pb.UserItem {
+	return &pb.UserItem{
+		Position:     int32(position),
+		Id:           user.UserId,
+		Username:     user.Username,
+		Email:        user.Email,
+		FullName:     getFullName(user),
+		Role:         user.Role.String(),
+		Roles:        user.Roles,
+		CreatedAt:    user.CreTimestamp,
+		Status:       user.Status.String(),
+	}
 }
 
 func (t *implUIGrpcServer) AdminGetUser(ctx context.Context, req *pb.UserId) (*pb.AdminUser, error) {
 
-	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !admin.Roles["WEB_ADMIN"] {
-		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	_, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
 	}
 
 	user, err := t.UserService.GetUser(ctx, req.Id)
@@ -222,6 +281,11 @@ func (t *implUIGrpcServer) AdminGetUser(ctx context.Context, req *pb.UserId) (*p
 		return nil, t.wrapError(err, "AdminGetUser", req.Id)
 	}
 
+	var purgeAt int64
+	if user.Status == pb.UserStatus_DELETED {
+		purgeAt = t.UserPurger.PurgeTime(user).Unix()
+	}
+
 	return &pb.AdminUser{
 		Id:        user.UserId,
 		Username:  user.Username,
@@ -229,6 +293,12 @@ func (t *implUIGrpcServer) AdminGetUser(ctx context.Context, req *pb.UserId) (*p
 		FullName:  getFullName(user),
 		CreatedAt: user.CreTimestamp,
 		Role: user.Role.String(),
+		Roles: user.Roles,
+		Status: user.Status.String(),
+		StatusReason: user.StatusReason,
+		StatusTimestamp: user.StatusTimestamp,
+		PasswordResetRequired: user.PasswordResetRequired,
+		PurgeAt: purgeAt,
 	}, nil
 
 }
@@ -237,13 +307,13 @@ func (t *implUIGrpcServer) AdminUpdateUser(ctx context.Context, req *pb.AdminUse
 
 	resp = &emptypb.Empty{}
 
-	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !admin.Roles["WEB_ADMIN"] {
-		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	admin, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
 	}
 
-	if admin.Username == req.Id {
-		return nil, status.Errorf(codes.Unauthenticated, "self role change not permitted")
+	if admin.UserId == utils.NormalizeUserId(req.Id) {
+		return nil, status.Errorf(codes.PermissionDenied, "self role change not permitted")
 	}
 
 	var pbRole pb.UserRole
@@ -257,12 +327,28 @@ func (t *implUIGrpcServer) AdminUpdateUser(ctx context.Context, req *pb.AdminUse
 		return nil, status.Errorf(codes.InvalidArgument, "unknown role '%s'", role)
 	}
 
+	scope, err := t.getGrantScope(ctx, admin)
+	if err != nil {
+		return nil, t.wrapError(err, "AdminUpdateUser", req.Id)
+	}
+
+	var denied string
 	err = t.UserService.DoWithUser(ctx, req.Id, func(user *pb.UserEntity) error {
+		if denied = scope.checkTarget(user); denied != "" {
+			return nil
+		}
+		if user.Role != pbRole && !scope.admin {
+			denied = "only the admin could change the admin role"
+			return nil
+		}
 		user.Role = pbRole
 		return nil
 	})
 	if err != nil {
-		err = t.wrapError(err, "AdminUpdateUser", req.Id)
+		return nil, t.wrapError(err, "AdminUpdateUser", req.Id)
+	}
+	if denied != "" {
+		return nil, status.Errorf(codes.PermissionDenied, "%s", denied)
 	}
 
 	return
@@ -270,31 +356,82 @@ func (t *implUIGrpcServer) AdminUpdateUser(ctx context.Context, req *pb.AdminUse
 
 func (t *implUIGrpcServer) AdminDeleteUser(ctx context.Context, req *pb.UserId) (resp *emptypb.Empty, err error) {
 
-	resp = &emptypb.Empty{}
+	admin, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
 
-	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !admin.Roles["WEB_ADMIN"] {
-		return nil, status.Errorf(codes.Unauthenticated, "role WEB_ADMIN is required")
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminDeleteUser", req.Id)
+		}
+
+	}()
+
+	userId := utils.NormalizeUserId(req.Id)
+	if userId == admin.UserId {
+		return nil, status.Errorf(codes.PermissionDenied, "self deletion not permitted")
+	}
+
+	err = t.checkAdminTarget(ctx, admin, userId)
+	if err != nil {
+		return nil, err
 	}
 
-	err = t.UserService.RemoveUser(ctx, req.Id)
+	err = t.UserService.SoftDeleteUser(ctx, userId)
+	if err = userStatusError(err); err != nil {
+		return nil, err
+	}
+
+	err = t.revokeSessions(ctx, userId, "AccountDeleted")
 	if err != nil {
-		err = t.wrapError(err, "AdminDeleteUser", req.Id)
-	} else {
-		err = t.UserService.DropUserContent(context.Background(), req.Id)
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (t *implUIGrpcServer) AdminUnlockUser(ctx context.Context, req *pb.UserId) (resp *emptypb.Empty, err error) {
+
+	admin, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
 		if err != nil {
-			err = t.wrapError(err, "DropUserContent", req.Id)
+			err = t.wrapError(err, "AdminUnlockUser", req.Id)
 		}
+
+	}()
+
+	err = t.checkAdminTarget(ctx, admin, req.Id)
+	if err != nil {
+		return nil, err
 	}
 
-	return
+	err = t.LockoutService.Unlock(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+	err = t.SecurityLogService.LogEvent(ctx, req.Id, "AccountUnlocked", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
 }
 
 func (t *implUIGrpcServer) AdminRun(ctx context.Context, req *pb.Command)  (*pb.CommandResult, error) {
 
+	// the node token of the command line is not a web user
 	admin, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !admin.Roles["ADMIN"] {
-		return nil, status.Errorf(codes.Unauthenticated, "role ADMIN is required")
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
 	}
 
 	switch req.Command {
@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"strings"
+)
+
+/**
+Preview renders the last or the requested revision of the page for the admin, the public Page call shows only the published one.
+ */
+
+func (t *implUIGrpcServer) AdminPreviewPage(ctx context.Context, req *pb.PageRevisionId) (resp *pb.PageContent, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminPreviewPage", user.UserId)
+		}
+
+	}()
+
+	page, err := t.PageService.GetPage(ctx, req.Name)
+	if err != nil {
+		return nil, pageStateError(err)
+	}
+
+	revision := req.Revision
+	if revision == 0 {
+		revision = page.Revision
+	}
+
+	rev, err := t.PageService.GetRevision(ctx, req.Name, revision)
+	if err != nil {
+		return nil, pageStateError(err)
+	}
+
+	return t.renderPage(page, rev), nil
+}
+
+func (t *implUIGrpcServer) AdminSubmitPage(ctx context.Context, req *pb.PageName) (*emptypb.Empty, error) {
+	return t.setPageState(ctx, "AdminSubmitPage", req.Name, pb.PageState_IN_REVIEW)
+}
+
+func (t *implUIGrpcServer) AdminPublishPage(ctx context.Context, req *pb.PageName) (*emptypb.Empty, error) {
+	return t.setPageState(ctx, "AdminPublishPage", req.Name, pb.PageState_PUBLISHED)
+}
+
+func (t *implUIGrpcServer) AdminArchivePage(ctx context.Context, req *pb.PageName) (*emptypb.Empty, error) {
+	return t.setPageState(ctx, "AdminArchivePage", req.Name, pb.PageState_ARCHIVED)
+}
+
+func (t *implUIGrpcServer) setPageState(ctx context.Context, method, name string, state pb.PageState) (resp *emptypb.Empty, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, method, user.UserId)
+		}
+
+	}()
+
+	_, err = t.PageService.SetPageState(ctx, name, state)
+	if err != nil {
+		return nil, pageStateError(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (t *implUIGrpcServer) AdminSetPageTrust(ctx context.Context, req *pb.AdminPageTrust) (resp *emptypb.Empty, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminSetPageTrust", user.UserId)
+		}
+
+	}()
+
+	trust, ok := pb.PageTrust_value[strings.ToUpper(strings.TrimSpace(req.Trust))]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown trust level '%s'", req.Trust)
+	}
+
+	err = t.PageService.SetPageTrust(ctx, req.Name, pb.PageTrust(trust))
+	if err != nil {
+		return nil, pageStateError(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// content of the editor without pages.trust permission is never rendered verbatim
+func (t *implUIGrpcServer) canTrustPages(ctx context.Context, user *CurrentUser) (bool, error) {
+
+	scope, err := t.getGrantScope(ctx, user)
+	if err != nil {
+		return false, err
+	}
+
+	return scope.permissions[service.PermissionPagesTrust], nil
+}
+
+func (t *implUIGrpcServer) AdminSchedulePage(ctx context.Context, req *pb.AdminScheduleRequest) (resp *emptypb.Empty, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminSchedulePage", user.UserId)
+		}
+
+	}()
+
+	err = t.PageService.SchedulePage(ctx, req.Name, req.PublishAt, req.UnpublishAt)
+	if err != nil {
+		return nil, pageStateError(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func pageStateError(err error) error {
+	switch err {
+	case service.ErrPageNotFound:
+		return status.Errorf(codes.NotFound, "page not found")
+	case service.ErrRevisionNotFound:
+		return status.Errorf(codes.NotFound, "revision not found")
+	case service.ErrInvalidPageState:
+		return status.Errorf(codes.FailedPrecondition, "invalid page state")
+	case service.ErrInvalidSchedule:
+		return status.Errorf(codes.InvalidArgument, "unpublish time must be after the publish time")
+	default:
+		return err
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (t *implUIGrpcServer) AdminPageRevisions(ctx context.Context, req *pb.AdminRevisionsRequest) (resp *pb.AdminRevisionsResponse, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminPageRevisions", user.UserId)
+		}
+
+	}()
+
+	resp = new(pb.AdminRevisionsResponse)
+	resp.NextCursor, err = t.PageService.ScanRevisions(ctx, req.Name, req.Cursor, req.Reverse, int(req.Limit), func(rev *pb.PageRevisionEntity) {
+		resp.Items = append(resp.Items, &pb.PageRevisionItem{
+			Revision:   rev.Revision,
+			Title:      rev.Title,
+			AuthorId:   rev.AuthorId,
+			AuthorName: rev.AuthorName,
+			Timestamp:  rev.Timestamp,
+			Note:       rev.Note,
+		})
+	})
+	if err == service.ErrInvalidCursor {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid cursor")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (t *implUIGrpcServer) AdminGetPageRevision(ctx context.Context, req *pb.PageRevisionId) (resp *pb.AdminPageRevision, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminGetPageRevision", user.UserId)
+		}
+
+	}()
+
+	rev, err := t.PageService.GetRevision(ctx, req.Name, req.Revision)
+	if err == service.ErrRevisionNotFound {
+		return nil, status.Errorf(codes.NotFound, "revision not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.AdminPageRevision{
+		Name:        rev.Name,
+		Revision:    rev.Revision,
+		Title:       rev.Title,
+		Content:     rev.Content,
+		ContentType: rev.ContentType.String(),
+		AuthorId:    rev.AuthorId,
+		AuthorName:  rev.AuthorName,
+		Timestamp:   rev.Timestamp,
+		Note:        rev.Note,
+	}, nil
+}
+
+func (t *implUIGrpcServer) AdminDiffPageRevisions(ctx context.Context, req *pb.AdminDiffRequest) (resp *pb.AdminDiffResponse, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminDiffPageRevisions", user.UserId)
+		}
+
+	}()
+
+	fromRev, toRev, lines, err := t.PageService.DiffRevisions(ctx, req.Name, req.From, req.To)
+	if err == service.ErrPageNotFound {
+		return nil, status.Errorf(codes.NotFound, "page not found")
+	}
+	if err == service.ErrRevisionNotFound {
+		return nil, status.Errorf(codes.NotFound, "revision not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp = &pb.AdminDiffResponse{
+		From:      fromRev.Revision,
+		To:        toRev.Revision,
+		FromTitle: fromRev.Title,
+		ToTitle:   toRev.Title,
+	}
+	for _, line := range lines {
+		resp.Lines = append(resp.Lines, &pb.DiffLine{
+			Op:       line.Op,
+			Text:     line.Text,
+			FromLine: int32(line.FromLine),
+			ToLine:   int32(line.ToLine),
+		})
+	}
+
+	return resp, nil
+}
+
+func (t *implUIGrpcServer) AdminRollbackPage(ctx context.Context, req *pb.AdminRollbackRequest) (resp *pb.AdminRollbackResponse, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminRollbackPage", user.UserId)
+		}
+
+	}()
+
+	trusted, err := t.canTrustPages(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	revision, err := t.PageService.RollbackPage(ctx, req.Name, req.Revision, user.UserId, user.Username, req.Note, trusted)
+	if err == service.ErrPageNotFound {
+		return nil, status.Errorf(codes.NotFound, "page not found")
+	}
+	if err == service.ErrRevisionNotFound {
+		return nil, status.Errorf(codes.NotFound, "revision not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.AdminRollbackResponse{Revision: revision}, nil
+}
@@ -0,0 +1,330 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"github.com/sprintframework/template/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func (t *implUIGrpcServer) AdminSetUserRoles(ctx context.Context, req *pb.AdminUserRoles) (resp *emptypb.Empty, err error) {
+
+	admin, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminSetUserRoles", req.Id)
+		}
+
+	}()
+
+	userId := utils.NormalizeUserId(req.Id)
+	if userId == admin.UserId {
+		return nil, status.Errorf(codes.PermissionDenied, "self role change not permitted")
+	}
+
+	scope, err := t.getGrantScope(ctx, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []string
+	seen := make(map[string]bool)
+	for _, name := range req.Roles {
+		role, err := t.RoleService.GetRole(ctx, name)
+		if err == service.ErrRoleNotFound {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown role '%s'", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !seen[role.Name] {
+			seen[role.Name] = true
+			roles = append(roles, role.Name)
+		}
+	}
+
+	var denied string
+	err = t.UserService.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+		if denied = scope.checkTarget(user); denied != "" {
+			return nil
+		}
+		// roles the user keeps are not checked, the added and removed ones are limited by the caller
+		current := make(map[string]bool)
+		for _, name := range user.Roles {
+			current[name] = true
+		}
+		var changed []string
+		for _, name := range roles {
+			if !current[name] {
+				changed = append(changed, name)
+			}
+		}
+		for _, name := range user.Roles {
+			if !seen[name] {
+				changed = append(changed, name)
+			}
+		}
+		for _, name := range changed {
+			role, err := t.RoleService.GetRole(ctx, name)
+			if err == service.ErrRoleNotFound {
+				// the role was removed already, nothing to revoke
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if !scope.canGrant(role) {
+				denied = fmt.Sprintf("role '%s' exceeds your permissions", name)
+				return nil
+			}
+		}
+		user.Roles = roles
+		return nil
+	})
+	if err == service.ErrUserNotFound {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if denied != "" {
+		return nil, status.Errorf(codes.PermissionDenied, "%s", denied)
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+	err = t.SecurityLogService.LogEvent(ctx, userId, "RolesChanged", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (t *implUIGrpcServer) AdminRoles(ctx context.Context, _ *emptypb.Empty) (resp *pb.AdminRolesResponse, err error) {
+
+	admin, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	resp = &pb.AdminRolesResponse{
+		Permissions: service.Permissions,
+	}
+
+	err = t.RoleService.EnumRoles(ctx, func(role *pb.RoleEntity) bool {
+		resp.Items = append(resp.Items, &pb.AdminRole{
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: role.Permissions,
+			Builtin:     role.Name == service.AdminRoleName,
+		})
+		return true
+	})
+	if err != nil {
+		return nil, t.wrapError(err, "AdminRoles", admin.UserId)
+	}
+
+	return resp, nil
+}
+
+func (t *implUIGrpcServer) AdminSaveRole(ctx context.Context, req *pb.AdminRole) (resp *emptypb.Empty, err error) {
+
+	admin, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	scope, err := t.getGrantScope(ctx, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	role := &pb.RoleEntity{
+		Name:        req.Name,
+		Description: req.Description,
+		Permissions: req.Permissions,
+	}
+	if !scope.canGrant(role) {
+		return nil, status.Errorf(codes.PermissionDenied, "role permissions exceed your permissions")
+	}
+
+	// removing the permission from the role is the same as revoking it from every holder
+	prev, err := t.RoleService.GetRole(ctx, req.Name)
+	if err != nil && err != service.ErrRoleNotFound {
+		return nil, t.roleError(err, "AdminSaveRole", admin.UserId)
+	}
+	if prev != nil && !scope.canGrant(&pb.RoleEntity{Name: prev.Name, Permissions: removedPermissions(prev, role)}) {
+		return nil, status.Errorf(codes.PermissionDenied, "removed permissions exceed your permissions")
+	}
+
+	err = t.RoleService.SaveRole(ctx, role)
+	if err != nil {
+		return nil, t.roleError(err, "AdminSaveRole", admin.UserId)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (t *implUIGrpcServer) AdminDeleteRole(ctx context.Context, req *pb.RoleName) (resp *emptypb.Empty, err error) {
+
+	admin, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	scope, err := t.getGrantScope(ctx, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	// removing the role strips it from every holder, the same as granting it
+	role, err := t.RoleService.GetRole(ctx, req.Name)
+	if err != nil {
+		return nil, t.roleError(err, "AdminDeleteRole", admin.UserId)
+	}
+	if !scope.canGrant(role) {
+		return nil, status.Errorf(codes.PermissionDenied, "role permissions exceed your permissions")
+	}
+
+	err = t.RoleService.RemoveRole(ctx, req.Name)
+	if err != nil {
+		return nil, t.roleError(err, "AdminDeleteRole", admin.UserId)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func removedPermissions(prev, next *pb.RoleEntity) []string {
+	kept := make(map[string]bool)
+	for _, p := range next.Permissions {
+		kept[p] = true
+	}
+	var removed []string
+	for _, p := range prev.Permissions {
+		if !kept[p] {
+			removed = append(removed, p)
+		}
+	}
+	return removed
+}
+
+func (t *implUIGrpcServer) roleError(err error, method, username string) error {
+	switch errors.Cause(err) {
+	case service.ErrRoleNotFound:
+		return status.Errorf(codes.NotFound, "role not found")
+	case service.ErrRoleBuiltIn:
+		return status.Errorf(codes.FailedPrecondition, "built-in role could not be changed")
+	case service.ErrUnknownPermission:
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	default:
+		return t.wrapError(err, method, username)
+	}
+}
+
+/**
+Nobody grants more than they hold. Roles and permissions are limited to the ones of the caller,
+the admin role and UserRole ADMIN are granted only by admins, accounts of admins are changed only by admins.
+ */
+type grantScope struct {
+	admin       bool
+	permissions map[string]bool
+}
+
+func (t *implUIGrpcServer) getGrantScope(ctx context.Context, caller *CurrentUser) (*grantScope, error) {
+
+	if caller.Roles[nodeAdminRole] {
+		permissions := make(map[string]bool)
+		for _, p := range service.Permissions {
+			permissions[p] = true
+		}
+		return &grantScope{admin: true, permissions: permissions}, nil
+	}
+
+	entity, err := t.UserService.GetUser(ctx, caller.UserId)
+	if err == service.ErrUserNotFound {
+		return nil, status.Errorf(codes.Unauthenticated, "user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := t.RoleService.GetPermissions(ctx, entity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grantScope{admin: isAdmin(entity), permissions: permissions}, nil
+}
+
+func (t *grantScope) canGrant(role *pb.RoleEntity) bool {
+	if role.Name == service.AdminRoleName {
+		return t.admin
+	}
+	for _, p := range role.Permissions {
+		if !t.permissions[p] {
+			return false
+		}
+	}
+	return true
+}
+
+// returns the reason if the caller could not change roles of the user
+func (t *grantScope) checkTarget(user *pb.UserEntity) string {
+	if isAdmin(user) && !t.admin {
+		return "account of the admin could be managed only by the admin"
+	}
+	return ""
+}
+
+/**
+Every admin call that acts on the account of the user goes through the check, the delegated staff roles could
+not lock out, delete, export or impersonate the admins.
+ */
+func (t *implUIGrpcServer) checkAdminTarget(ctx context.Context, caller *CurrentUser, userId string) error {
+
+	scope, err := t.getGrantScope(ctx, caller)
+	if err != nil {
+		return err
+	}
+
+	user, err := t.UserService.GetUser(ctx, userId)
+	if err == service.ErrUserNotFound {
+		return status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	if denied := scope.checkTarget(user); denied != "" {
+		return status.Errorf(codes.PermissionDenied, "%s", denied)
+	}
+
+	return nil
+}
+
+func isAdmin(entity *pb.UserEntity) bool {
+	if entity.Role == pb.UserRole_ADMIN {
+		return true
+	}
+	for _, name := range entity.Roles {
+		if name == service.AdminRoleName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"github.com/sprintframework/template/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+/**
+Suspension and forced password reset revoke all sessions of the user, so the refresh is rejected
+and issued access tokens expire in auth.access-token-minutes.
+ */
+
+func (t *implUIGrpcServer) AdminSuspendUser(ctx context.Context, req *pb.AdminSuspendRequest) (resp *emptypb.Empty, err error) {
+
+	admin, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminSuspendUser", req.Id)
+		}
+
+	}()
+
+	userId := utils.NormalizeUserId(req.Id)
+	if userId == admin.UserId {
+		return nil, status.Errorf(codes.PermissionDenied, "self suspension not permitted")
+	}
+
+	err = t.checkAdminTarget(ctx, admin, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.UserService.SetStatus(ctx, userId, pb.UserStatus_SUSPENDED, req.Reason)
+	if err = userStatusError(err); err != nil {
+		return nil, err
+	}
+
+	err = t.revokeSessions(ctx, userId, "AccountSuspended")
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (t *implUIGrpcServer) AdminActivateUser(ctx context.Context, req *pb.UserId) (resp *emptypb.Empty, err error) {
+
+	admin, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminActivateUser", req.Id)
+		}
+
+	}()
+
+	userId := utils.NormalizeUserId(req.Id)
+
+	err = t.checkAdminTarget(ctx, admin, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.UserService.SetStatus(ctx, userId, pb.UserStatus_ACTIVE, "")
+	if err = userStatusError(err); err != nil {
+		return nil, err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+	err = t.SecurityLogService.LogEvent(ctx, userId, "AccountActivated", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (t *implUIGrpcServer) AdminRestoreUser(ctx context.Context, req *pb.UserId) (resp *emptypb.Empty, err error) {
+
+	admin, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminRestoreUser", req.Id)
+		}
+
+	}()
+
+	userId := utils.NormalizeUserId(req.Id)
+
+	err = t.checkAdminTarget(ctx, admin, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.UserService.RestoreUser(ctx, userId)
+	if err = userStatusError(err); err != nil {
+		return nil, err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+	err = t.SecurityLogService.LogEvent(ctx, userId, "AccountRestored", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (t *implUIGrpcServer) AdminForcePasswordReset(ctx context.Context, req *pb.UserId) (resp *emptypb.Empty, err error) {
+
+	admin, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminForcePasswordReset", req.Id)
+		}
+
+	}()
+
+	userId := utils.NormalizeUserId(req.Id)
+
+	err = t.checkAdminTarget(ctx, admin, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.UserService.SetPasswordResetRequired(ctx, userId, true)
+	if err = userStatusError(err); err != nil {
+		return nil, err
+	}
+
+	err = t.revokeSessions(ctx, userId, "PasswordResetRequired")
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (t *implUIGrpcServer) AdminRevokeSessions(ctx context.Context, req *pb.UserId) (resp *emptypb.Empty, err error) {
+
+	admin, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminRevokeSessions", req.Id)
+		}
+
+	}()
+
+	userId := utils.NormalizeUserId(req.Id)
+
+	err = t.checkAdminTarget(ctx, admin, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.revokeSessions(ctx, userId, "SessionsRevoked")
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+/**
+Signs the user out everywhere, personal access tokens are revoked as well. Access tokens of the removed sessions
+are rejected by the interceptor.
+ */
+func (t *implUIGrpcServer) revokeSessions(ctx context.Context, userId, event string) error {
+
+	_, err := t.SessionService.RemoveOtherSessions(ctx, userId, "")
+	if err != nil {
+		return err
+	}
+
+	_, err = t.ApiTokenService.RevokeAllTokens(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+	return t.SecurityLogService.LogEvent(ctx, userId, event, remoteIP, userAgent)
+}
+
+func userStatusError(err error) error {
+	switch err {
+	case service.ErrUserNotFound:
+		return status.Errorf(codes.NotFound, "user not found")
+	case service.ErrUserDeleted:
+		return status.Errorf(codes.FailedPrecondition, "user deleted")
+	case service.ErrUserNotDeleted:
+		return status.Errorf(codes.FailedPrecondition, "user not deleted")
+	default:
+		return err
+	}
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func (t *implUIGrpcServer) CreateApiToken(ctx context.Context, req *pb.CreateApiTokenRequest) (resp *pb.CreateApiTokenResponse, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	// token could not create other tokens
+	if user.Context[apiTokenContextKey] != "" {
+		return nil, status.Errorf(codes.PermissionDenied, "interactive login is required")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "CreateApiToken", user.UserId)
+		}
+
+	}()
+
+	userId := user.UserId
+
+	entity, err := t.UserService.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		switch scope {
+		case apiTokenScopeUser:
+		case apiTokenScopeAdmin:
+			if !hasAdminAccess(entity) {
+				return nil, status.Errorf(codes.PermissionDenied, "scope '%s' requires an admin role", scope)
+			}
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unknown scope '%s'", scope)
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		scopes = append(scopes, apiTokenScopeUser)
+	}
+
+	days := int(req.ExpiresInDays)
+	if days == 0 {
+		days = t.ApiTokenDays
+	}
+	if days < 0 || days > t.ApiTokenMaxDays {
+		return nil, status.Errorf(codes.InvalidArgument, "expiration must be from 1 to %d days", t.ApiTokenMaxDays)
+	}
+
+	token, tokenEntity, err := t.ApiTokenService.CreateToken(ctx, userId, req.Name, scopes, days * 24 * 3600)
+	if err == service.ErrApiTokenLimit {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many api tokens, revoke unused ones")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+
+	err = t.SecurityLogService.LogEvent(ctx, userId, "ApiTokenCreated", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.CreateApiTokenResponse{
+		Token: token,
+		Item:  toApiTokenItem(tokenEntity),
+	}, nil
+}
+
+func (t *implUIGrpcServer) ApiTokens(ctx context.Context, _ *emptypb.Empty) (resp *pb.ApiTokensResponse, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "ApiTokens", user.UserId)
+		}
+
+	}()
+
+	userId := user.UserId
+
+	resp = new(pb.ApiTokensResponse)
+	err = t.ApiTokenService.EnumTokens(ctx, userId, func(entity *pb.ApiTokenEntity) bool {
+		resp.Items = append(resp.Items, toApiTokenItem(entity))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (t *implUIGrpcServer) RevokeApiToken(ctx context.Context, req *pb.ApiTokenIdRequest) (resp *emptypb.Empty, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "RevokeApiToken", user.UserId)
+		}
+
+	}()
+
+	userId := user.UserId
+
+	err = t.ApiTokenService.RevokeToken(ctx, userId, req.TokenId)
+	if err == service.ErrApiTokenNotFound {
+		return nil, status.Errorf(codes.NotFound, "api token not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+
+	err = t.SecurityLogService.LogEvent(ctx, userId, "ApiTokenRevoked", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func toApiTokenItem(entity *pb.ApiTokenEntity) *pb.ApiTokenItem {
+	return &pb.ApiTokenItem{
+		TokenId:    entity.TokenId,
+		Name:       entity.Name,
+		Scopes:     entity.Scopes,
+		CreatedAt:  entity.CreTimestamp,
+		ExpiresAt:  entity.ExpireTimestamp,
+		LastUsedAt: entity.LastTimestamp,
+	}
+}
@@ -11,47 +11,277 @@ import (
 	"github.com/sprintframework/sprintframework/sprintutils"
 	"github.com/sprintframework/template/pkg/pb"
 	"github.com/sprintframework/template/pkg/service"
+	"github.com/sprintframework/template/pkg/utils"
 	"github.com/sprintframework/sprint"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
-	"math/rand"
-	"strconv"
 	"time"
 )
 
+const (
+	recoverCodeLength = 8
+)
+
 func (t *implUIGrpcServer) Login(ctx context.Context, req *pb.LoginRequest) (resp *pb.LoginResponse, err error) {
 
+	remoteIP, userAgent := getCallerInfo(ctx)
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "Login", req.Login)
+		}
+
+	}()
+
+	// locked account stays locked whatever the password is
+	release, err := t.reserveLoginByLogin(ctx, req.Login, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	entity, err := t.UserService.AuthenticateUser(ctx, req.Login, req.Password)
 	if err == service.ErrUserNotFound {
+		if err = t.loginFailed(ctx, "", remoteIP, userAgent); err != nil {
+			return nil, err
+		}
 		return nil, status.Errorf(codes.NotFound, "user not found")
 	}
 	if err == service.ErrUserInvalidPassword {
+		if err = t.loginFailed(ctx, entity.UserId, remoteIP, userAgent); err != nil {
+			return nil, err
+		}
 		return nil, status.Errorf(codes.Unauthenticated, "invalid password")
 	}
+	if err == service.ErrUserDeleted {
+		return nil, status.Errorf(codes.FailedPrecondition, "account is deleted, undelete it to login")
+	}
+	if err == service.ErrUserSuspended {
+		return nil, checkUserStatus(entity)
+	}
+	if err == service.ErrPasswordResetRequired {
+		return nil, checkLoginStatus(entity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t.RequireVerifiedEmail && !entity.Verified {
+		return nil, status.Errorf(codes.FailedPrecondition, "email is not verified")
+	}
+
+	if entity.TotpEnabled {
+		return t.issueMfaToken(entity, req.Device)
+	}
+
+	session, err := t.createSession(ctx, entity.UserId, req.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err = t.issueTokens(entity, session)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.LockoutService.LoginSucceeded(ctx, entity.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.SecurityLogService.LogEvent(ctx, entity.UserId, "Login", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	t.loginCnt.Inc()
+
+	return resp, nil
+}
+
+func (t *implUIGrpcServer) UndeleteAccount(ctx context.Context, req *pb.LoginRequest) (resp *emptypb.Empty, err error) {
+
+	remoteIP, userAgent := getCallerInfo(ctx)
 
 	defer func() {
 
 		if err != nil {
-			err = t.wrapError(err, "Login", entity.UserId)
+			err = t.wrapError(err, "UndeleteAccount", req.Login)
 		}
 
 	}()
 
+	// locked account stays locked whatever the password is
+	release, err := t.reserveLoginByLogin(ctx, req.Login, remoteIP)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
+
+	entity, err := t.UserService.AuthenticateUser(ctx, req.Login, req.Password)
+	if err == service.ErrUserNotFound {
+		if err = t.loginFailed(ctx, "", remoteIP, userAgent); err != nil {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err == service.ErrUserInvalidPassword {
+		if err = t.loginFailed(ctx, entity.UserId, remoteIP, userAgent); err != nil {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "invalid password")
+	}
+	if err != service.ErrUserDeleted {
+		if err == nil || entity != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "account is not deleted")
+		}
+		return nil, err
+	}
+
+	err = t.UserService.RestoreUser(ctx, entity.UserId)
+	if err = userStatusError(err); err != nil {
+		return nil, err
+	}
+
+	err = t.SecurityLogService.LogEvent(ctx, entity.UserId, "AccountRestored", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (t *implUIGrpcServer) checkLoginAllowed(ctx context.Context, userId, remoteIP string) error {
+
+	wait, err := t.LockoutService.CheckLogin(ctx, userId, remoteIP)
+	if err != nil {
+		return err
+	}
+
+	return loginWaitError(wait)
+}
+
+/**
+Reserves the account for the attempt that verifies the secret, parallel attempts are refused until the outcome.
+The returned function ends the reservation if neither loginFailed nor LoginSucceeded did it.
+ */
+func (t *implUIGrpcServer) reserveLogin(ctx context.Context, userId, remoteIP string) (func(), error) {
+
+	wait, err := t.LockoutService.ReserveLogin(ctx, userId, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = loginWaitError(wait); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := t.LockoutService.ReleaseLogin(ctx, userId); err != nil {
+			t.Log.Error("ReleaseLogin", zap.String("userId", userId), zap.Error(err))
+		}
+	}, nil
+}
+
+/**
+Resolves the account of the login the same way as AuthenticateUser does and reserves it together with the check of the remote IP.
+ */
+func (t *implUIGrpcServer) reserveLoginByLogin(ctx context.Context, login, remoteIP string) (func(), error) {
+
+	userId, err := t.UserService.GetUserIdByLogin(ctx, login)
+	if err == service.ErrUserNotFound {
+		// AuthenticateUser also accepts the user id as login
+		userId = login
+	} else if err != nil {
+		return nil, err
+	}
+
+	return t.reserveLogin(ctx, userId, remoteIP)
+}
+
+func loginWaitError(wait time.Duration) error {
+
+	if wait > 0 {
+		seconds := int64((wait + time.Second - 1) / time.Second)
+		return status.Errorf(codes.ResourceExhausted, "too many failed login attempts, try again in %d seconds", seconds)
+	}
+
+	return nil
+}
+
+/**
+Suspended and deleted users can not login or refresh tokens with any method.
+ */
+func checkUserStatus(entity *pb.UserEntity) error {
+
+	switch entity.Status {
+	case pb.UserStatus_SUSPENDED:
+		return status.Errorf(codes.PermissionDenied, "account suspended")
+	case pb.UserStatus_DELETED:
+		return status.Errorf(codes.NotFound, "user not found")
+	}
+
+	return nil
+}
+
+/**
+Every login method issues tokens only for the account that could login with the password.
+ */
+func checkLoginStatus(entity *pb.UserEntity) error {
+
+	if err := checkUserStatus(entity); err != nil {
+		return err
+	}
+
+	if entity.PasswordResetRequired {
+		return status.Errorf(codes.FailedPrecondition, "password reset is required, please restore the password")
+	}
+
+	return nil
+}
+
+func (t *implUIGrpcServer) loginFailed(ctx context.Context, userId, remoteIP, userAgent string) error {
+
+	accountLocked, ipLocked, err := t.LockoutService.LoginFailed(ctx, userId, remoteIP)
+	if err != nil {
+		return err
+	}
+
+	if userId != "" {
+		if accountLocked {
+			err = t.SecurityLogService.LogEvent(ctx, userId, "AccountLocked", remoteIP, userAgent)
+		} else if ipLocked {
+			err = t.SecurityLogService.LogEvent(ctx, userId, "IpLocked", remoteIP, userAgent)
+		}
+	} else if ipLocked {
+		t.Log.Warn("IpLocked", zap.String("remoteIP", remoteIP), zap.String("userAgent", userAgent))
+	}
+
+	return err
+}
+
+func (t *implUIGrpcServer) issueTokens(entity *pb.UserEntity, session *pb.SessionEntity) (*pb.LoginResponse, error) {
+
+	if err := checkLoginStatus(entity); err != nil {
+		return nil, err
+	}
 
 	roles := make(map[string]bool)
 	roles["WEB_USER"] = true
-	if entity.Role == pb.UserRole_ADMIN {
+	if hasAdminAccess(entity) {
 		roles["WEB_ADMIN"] = true
 	}
 
 	token, err := t.AuthorizationMiddleware.GenerateToken(&sprint.AuthorizedUser{
-		Username:  entity.Username,
+		Username:  entity.UserId,
 		Roles:     roles,
+		Context:   map[string]string{
+			usernameContextKey: entity.Username,
+			sessionContextKey:  session.SessionId,
+		},
 		ExpiresAt: time.Now().Add(time.Minute * time.Duration(t.AccessTokenMinutes)).Unix(),
 	})
 
@@ -60,7 +290,12 @@ func (t *implUIGrpcServer) Login(ctx context.Context, req *pb.LoginRequest) (res
 	}
 
 	refreshToken, err := t.AuthorizationMiddleware.GenerateToken(&sprint.AuthorizedUser{
-		Username:  entity.Username,
+		Username:  entity.UserId,
+		Context:   map[string]string{
+			usernameContextKey: entity.Username,
+			sessionContextKey:  session.SessionId,
+			refreshContextKey:  session.RefreshId,
+		},
 		ExpiresAt: time.Now().Add(time.Hour * time.Duration(t.RefreshTokenHours)).Unix(),
 	})
 
@@ -68,14 +303,6 @@ func (t *implUIGrpcServer) Login(ctx context.Context, req *pb.LoginRequest) (res
 		return nil, err
 	}
 
-	remoteIP, userAgent := getCallerInfo(ctx)
-	err = t.SecurityLogService.LogEvent(ctx, entity.UserId, "Login", remoteIP, userAgent)
-	if err != nil {
-		return nil, err
-	}
-
-	t.loginCnt.Inc()
-
 	return &pb.LoginResponse{
 		Token: token,
 		RefreshToken: refreshToken,
@@ -84,9 +311,16 @@ func (t *implUIGrpcServer) Login(ctx context.Context, req *pb.LoginRequest) (res
 
 func (t *implUIGrpcServer) Logout(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
 
-	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
+	user, ok := t.CurrentUser(ctx)
 	if ok {
 		t.AuthorizationMiddleware.InvalidateToken(user.Token)
+
+		if sessionId := user.Context[sessionContextKey]; sessionId != "" {
+			err := t.SessionService.RemoveSession(ctx, user.UserId, sessionId)
+			if err != nil {
+				return nil, t.wrapError(err, "Logout", user.UserId)
+			}
+		}
 	}
 
 	return &emptypb.Empty{}, nil
@@ -94,28 +328,25 @@ func (t *implUIGrpcServer) Logout(ctx context.Context, _ *emptypb.Empty) (*empty
 
 func (t *implUIGrpcServer) Refresh(ctx context.Context, req *pb.RefreshRequest) (resp *pb.LoginResponse, err error) {
 	
-	user, err := t.AuthorizationMiddleware.ParseToken(req.RefreshToken)
-	if err != nil {
+	token, err := t.AuthorizationMiddleware.ParseToken(req.RefreshToken)
+	if err != nil || token.Context[mfaContextKey] != "" {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid refresh token")
+	}
+
+	user, ok := toCurrentUser(token)
+	if !ok {
 		return nil, status.Errorf(codes.Unauthenticated, "invalid refresh token")
 	}
+	userId := user.UserId
 
 	defer func() {
 
 		if err != nil {
-			err = t.wrapError(err, "Refresh", user.Username)
+			err = t.wrapError(err, "Refresh", userId)
 		}
 
 	}()
 
-	userId, err := t.UserService.GetUserIdByUsername(ctx, user.Username)
-	if err == service.ErrUserNotFound {
-		err = status.Errorf(codes.NotFound, "username not found")
-		return
-	}
-	if err != nil {
-		return
-	}
-
 	info, err := t.UserService.GetUser(ctx, userId)
 	if err == service.ErrUserNotFound {
 		err = status.Errorf(codes.NotFound, "user not found")
@@ -125,35 +356,30 @@ func (t *implUIGrpcServer) Refresh(ctx context.Context, req *pb.RefreshRequest)
 		return
 	}
 
-	roles := make(map[string]bool)
-	roles["WEB_USER"] = true
-	if info.Role == pb.UserRole_ADMIN {
-		roles["WEB_ADMIN"] = true
-	}
-
-	token, err := t.AuthorizationMiddleware.GenerateToken(&sprint.AuthorizedUser{
-		Username:  user.Username,
-		Roles:     roles,
-		ExpiresAt: time.Now().Add(time.Minute * time.Duration(t.AccessTokenMinutes)).Unix(),
-	})
-
+	err = checkUserStatus(info)
 	if err != nil {
-		return nil, err
+		return
 	}
 
-	refreshToken, err := t.AuthorizationMiddleware.GenerateToken(&sprint.AuthorizedUser{
-		Username:  user.Username,
-		ExpiresAt: time.Now().Add(time.Hour * time.Duration(t.RefreshTokenHours)).Unix(),
-	})
+	remoteIP, userAgent := getCallerInfo(ctx)
 
-	if err != nil {
+	session, err := t.SessionService.RotateRefreshToken(ctx, userId, user.Context[sessionContextKey], user.Context[refreshContextKey], remoteIP, userAgent, t.RefreshTokenHours * 3600)
+	switch err {
+	case nil:
+	case service.ErrSessionNotFound:
+		err = status.Errorf(codes.Unauthenticated, "session revoked")
+		return
+	case service.ErrRefreshTokenReuse:
+		if err = t.SecurityLogService.LogEvent(ctx, userId, "RefreshTokenReuse", remoteIP, userAgent); err != nil {
+			return
+		}
+		err = status.Errorf(codes.Unauthenticated, "refresh token already used, session revoked")
+		return
+	default:
 		return
 	}
 
-	return &pb.LoginResponse{
-		Token: token,
-		RefreshToken: refreshToken,
-	}, nil
+	return t.issueTokens(info, session)
 }
 
 func (t *implUIGrpcServer) IsUsernameAvailable(ctx context.Context, req *pb.UsernameRequest) (resp *pb.UsernameResponse, err error) {
@@ -190,18 +416,12 @@ func (t *implUIGrpcServer) IsUsernameAvailable(ctx context.Context, req *pb.User
 
 func (t *implUIGrpcServer) User(ctx context.Context, _ *emptypb.Empty) (*pb.UserResponse, error) {
 
-	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !user.Roles["WEB_USER"] {
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
 		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
 	}
 
-	userId, err := t.UserService.GetUserIdByUsername(ctx, user.Username)
-	if err == service.ErrUserNotFound {
-		return nil, status.Errorf(codes.NotFound, "user id not found")
-	}
-	if err != nil {
-		return nil, t.wrapError(err, "User", user.Username)
-	}
+	userId := user.UserId
 
 	info, err := t.UserService.GetUser(ctx, userId)
 	if err == service.ErrUserNotFound {
@@ -211,7 +431,22 @@ func (t *implUIGrpcServer) User(ctx context.Context, _ *emptypb.Empty) (*pb.User
 		return nil, t.wrapError(err, "UserId", userId)
 	}
 
-	u := &pb.User{
+	permissions, err := t.RoleService.GetPermissions(ctx, info)
+	if err != nil {
+		return nil, t.wrapError(err, "UserPermissions", userId)
+	}
+
+	resp := t.toUser(info, t.getWebUserRole(user))
+	resp.Permissions = sortedPermissions(permissions)
+	resp.Impersonator = user.ImpersonatorName
+
+	return &pb.UserResponse{
+		User: resp,
+	}, nil
+}
+
+func (t *implUIGrpcServer) toUser(info *pb.UserEntity, role string) *pb.User {
+	return &pb.User{
 		UserId:     info.UserId,
 		Username:   info.Username,
 		FirstName:  info.FirstName,
@@ -219,15 +454,12 @@ func (t *implUIGrpcServer) User(ctx context.Context, _ *emptypb.Empty) (*pb.User
 		LastName:   info.LastName,
 		Email:      info.Email,
 		Since:      int64(time.Unix(info.CreTimestamp, 0).Year()),
-		Role:       t.getWebUserRole(user),
+		Role:       role,
+		Verified:   info.Verified,
 	}
-
-	return &pb.UserResponse{
-		User: u,
-	}, nil
 }
 
-func (t *implUIGrpcServer) getWebUserRole(user *sprint.AuthorizedUser) string {
+func (t *implUIGrpcServer) getWebUserRole(user *CurrentUser) string {
 	var role string
 	if user.Roles["WEB_USER"] {
 		role = "USER"
@@ -257,10 +489,23 @@ func (t *implUIGrpcServer) Register(ctx context.Context, req *pb.RegisterRequest
 		return nil, err
 	}
 
+	err = t.checkPasswordPolicy("password", req.Password, req.Username, req.Email)
+	if err != nil {
+		return nil, err
+	}
+
 	entity, err := t.UserService.CreateUser(ctx, req)
 	if err == service.ErrUserAlreadyExist {
 		return nil, status.Errorf(codes.AlreadyExists, "user already exist")
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	verifyToken, verifyLink, err := t.generateVerifyToken(entity)
+	if err != nil {
+		return nil, err
+	}
 
 	subject := fmt.Sprintf("Welcome to %s, %s.", t.WebappName, req.FirstName)
 	sender := t.Properties.GetString("mail.sender", "noreply@localhost")
@@ -274,6 +519,8 @@ func (t *implUIGrpcServer) Register(ctx context.Context, req *pb.RegisterRequest
 		Data:         map[string]interface{} {
 			"FirstName": req.FirstName,
 			"Project": t.WebappName,
+			"VerifyToken": verifyToken,
+			"VerifyLink": verifyLink,
 		},
 	}
 
@@ -347,7 +594,11 @@ func (t *implUIGrpcServer) doRestore(ctx context.Context, req *pb.RestoreRequest
 	}
 
 
-	code := strconv.FormatInt(int64(rand.Int31()), 10)
+	code, err := utils.RandomString(utils.NumericAlphabet, recoverCodeLength)
+	if err != nil {
+		return nil, err
+	}
+
 	subject := fmt.Sprintf("%s is %s recover passcode", code, t.WebappName)
 	sender := t.Properties.GetString("mail.sender", "noreply@localhost")
 
@@ -387,10 +638,7 @@ func (t *implUIGrpcServer) Reset(ctx context.Context, req *pb.ResetRequest) (res
 
 	//t.Log.Info("Reset", zap.Any("req", req.String()))
 
-	err = t.UserService.ValidateRecoverCode(ctx, req.Login, req.Code)
-	if err == service.ErrInvalidRecoverCode {
-		return nil, status.Errorf(codes.InvalidArgument, "wrong recovery code")
-	}
+	remoteIP, userAgent := getCallerInfo(ctx)
 
 	defer func() {
 
@@ -400,11 +648,33 @@ func (t *implUIGrpcServer) Reset(ctx context.Context, req *pb.ResetRequest) (res
 
 	}()
 
+	userId, err := t.UserService.GetUserIdByLogin(ctx, req.Login)
+	if err == service.ErrUserNotFound {
+		// no recovery code was sent to unknown login
+		return nil, status.Errorf(codes.InvalidArgument, "wrong recovery code")
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	userId, err := t.UserService.GetUserIdByLogin(ctx, req.Login)
+	entity, err := t.UserService.GetUser(ctx, userId)
+	if err == service.ErrUserNotFound {
+		return nil, status.Errorf(codes.InvalidArgument, "wrong recovery code")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// weak password should not burn the recovery code
+	err = t.checkPasswordPolicy("password", req.Password, entity.Username, entity.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.UserService.ValidateRecoverCode(ctx, req.Login, req.Code, remoteIP)
+	if err == service.ErrInvalidRecoverCode {
+		return nil, status.Errorf(codes.InvalidArgument, "wrong recovery code")
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -418,7 +688,6 @@ func (t *implUIGrpcServer) Reset(ctx context.Context, req *pb.ResetRequest) (res
 	support := t.Properties.GetString("mail.support", "support@localhost")
 
 	subject := fmt.Sprintf("Password reset for %s.", req.Login)
-	remoteIP, userAgent := getCallerInfo(ctx)
 
 	err = t.SecurityLogService.LogEvent(ctx, userId, "ResetPassword", remoteIP, userAgent)
 	if err != nil {
@@ -448,60 +717,48 @@ func (t *implUIGrpcServer) Reset(ctx context.Context, req *pb.ResetRequest) (res
 
 func (t *implUIGrpcServer) SecurityLog(ctx context.Context, req *pb.SecurityLogRequest) (resp *pb.SecurityLogResponse, err error) {
 
-	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !user.Roles["WEB_USER"] {
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
 		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
 	}
 
 	defer func() {
 
 		if err != nil {
-			err = t.wrapError(err, "SecurityLog", user.Username)
+			err = t.wrapError(err, "SecurityLog", user.UserId)
 		}
 
 	}()
 
-	userId, err := t.UserService.GetUserIdByUsername(ctx, user.Username)
-	if err != nil {
-		return nil, err
-	}
+	userId := user.UserId
+	resp = new(pb.SecurityLogResponse)
 
-	var log []*pb.SecurityLogEntity
-	err = t.SecurityLogService.EnumEvents(ctx, userId, func(event *pb.SecurityLogEntity) bool {
-		log = append(log, event)
-		return true
+	if req.Cursor == "" || req.WithTotal {
+		total, estimated, err := t.SecurityLogService.EstimateEvents(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+		resp.Total = int32(total)
+		resp.TotalEstimated = estimated
+	}
+
+	resp.NextCursor, err = t.SecurityLogService.ScanEvents(ctx, userId, req.Cursor, req.Reverse, int(req.Offset), int(req.Limit), func(event *pb.SecurityLogEntity, position int) {
+		resp.Items = append(resp.Items, &pb.SecurityLogItem{
+			Position:  int32(position),
+			EventName: event.EventName,
+			EventTime: event.EventTime,
+			RemoteIp:  event.RemoteIp,
+			UserAgent: event.UserAgent,
+			Actor:     event.Actor,
+			Details:   event.Details,
+		})
 	})
+	if err == service.ErrInvalidCursor {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid cursor")
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	total := len(log)
-	offset := int(req.Offset)
-	if offset < 0 {
-		offset = 0
-	}
-	limit := int(req.Limit)
-
-	if offset >= total {
-		return &pb.SecurityLogResponse{Total: int32(total)}, nil
-	}
-	var items []*pb.SecurityLogItem
-
-	for j := total - 1 - offset; j >= 0 && limit > 0; j-- {
-
-		items = append(items, &pb.SecurityLogItem{
-			Position: int32(j + 1),
-			EventName: log[j].EventName,
-			EventTime: log[j].EventTime,
-			RemoteIp:  log[j].RemoteIp,
-			UserAgent: log[j].UserAgent,
-		})
-
-		limit--
-	}
-
-	return &pb.SecurityLogResponse{
-		Total:   int32(total),
-		Items:   items,
-	}, nil
+	return resp, nil
 }
\ No newline at end of file
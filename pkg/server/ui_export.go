@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"github.com/sprintframework/template/pkg/utils"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+func (t *implUIGrpcServer) ExportMyData(ctx context.Context, req *pb.ExportRequest) (resp *pb.ExportResponse, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "ExportMyData", user.UserId)
+		}
+
+	}()
+
+	return t.exportUser(ctx, user.UserId, req.Format, "DataExported")
+}
+
+func (t *implUIGrpcServer) AdminExportUser(ctx context.Context, req *pb.AdminExportRequest) (resp *pb.ExportResponse, err error) {
+
+	admin, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminExportUser", req.Id)
+		}
+
+	}()
+
+	userId := utils.NormalizeUserId(req.Id)
+
+	err = t.checkAdminTarget(ctx, admin, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.exportUser(ctx, userId, req.Format, "DataExportedByAdmin")
+}
+
+func (t *implUIGrpcServer) exportUser(ctx context.Context, userId, format, event string) (*pb.ExportResponse, error) {
+
+	entity, err := t.ExportService.ExportUser(ctx, userId, strings.ToLower(format))
+	if err == service.ErrUserNotFound {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err == service.ErrUnknownExportFormat {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown export format '%s', use json or zip", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+	err = t.SecurityLogService.LogEvent(ctx, userId, event, remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	link := fmt.Sprintf("/api/export/%s", entity.ExportId)
+	if t.WebappURL != "" {
+		link = strings.TrimRight(t.WebappURL, "/") + link
+	}
+
+	return &pb.ExportResponse{
+		Url:       link,
+		FileName:  entity.FileName,
+		ExpiresAt: entity.ExpireTimestamp,
+	}, nil
+}
+
+func (t *implUIGrpcServer) DownloadExport(ctx context.Context, req *pb.ExportId) (*httpbody.HttpBody, error) {
+
+	entity, err := t.ExportService.GetExport(ctx, req.Id)
+	if err == service.ErrExportNotFound {
+		return nil, status.Errorf(codes.NotFound, "export not found or expired")
+	}
+	if err != nil {
+		return nil, t.wrapError(err, "DownloadExport", req.Id)
+	}
+
+	return &httpbody.HttpBody{
+		ContentType: entity.ContentType,
+		Data:        entity.Content,
+	}, nil
+}
@@ -9,13 +9,14 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
-	"github.com/gomarkdown/markdown"
 	"github.com/pkg/errors"
 	"github.com/keyvalstore/store"
 	"github.com/codeallergy/glue"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/sprintframework/template/pkg/api"
 	"github.com/sprintframework/template/pkg/pb"
 	"github.com/sprintframework/template/pkg/service"
+	"github.com/sprintframework/template/pkg/utils"
 	"github.com/sprintframework/sprint"
 	"github.com/sprintframework/sprintframework/sprintutils"
 	"go.uber.org/atomic"
@@ -23,6 +24,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"net/http"
@@ -38,6 +40,7 @@ type implUIGrpcServer struct {
 	pb.UnimplementedAdminServiceServer
 
 	WebappName string `value:"webapp.name,default=Light-Template"`
+	WebappURL  string `value:"webapp.url,default="`
 
 	GrpcServer       *grpc.Server   `inject`
 	UIGatewayServer  *http.Server   `inject:"bean=control-gateway-server"`
@@ -51,11 +54,25 @@ type implUIGrpcServer struct {
 	MailService           sprint.MailService  `inject`
 
 	UserService           api.UserService   `inject`
+	UserPurger            api.UserPurger    `inject`
 	SecurityLogService    api.SecurityLogService  `inject`
 	PageService           api.PageService   `inject`
+	PageRenderer          api.PageRenderer  `inject`
+	PageCache             api.PageCache     `inject`
+	LockoutService        api.LockoutService  `inject`
+	SessionService        api.SessionService  `inject`
+	ApiTokenService       api.ApiTokenService  `inject`
+	RoleService           api.RoleService  `inject`
+	PasswordPolicy        api.PasswordPolicy  `inject`
+	OidcService           api.OidcService  `inject`
+	WebauthnService       api.WebauthnService  `inject`
+	ExportService         api.ExportService  `inject`
 	TransactionalManager  store.TransactionalManager  `inject:"bean=host-store"`
 
 	usernameLimiterMap   sync.Map   // key is the IP, value is struct RateLimiter
+	verifyLimiterMap     sync.Map   // key is the user id, value is the time of the last verification mail
+
+	gatewayMux           *runtime.ServeMux
 
 	Log             *zap.Logger          `inject`
 
@@ -65,6 +82,13 @@ type implUIGrpcServer struct {
 
 	AccessTokenMinutes   int   `value:"auth.access-token-minutes,default=20"`
 	RefreshTokenHours    int   `value:"auth.refresh-token-hours,default=24"`
+	MfaTokenMinutes      int   `value:"auth.mfa-token-minutes,default=5"`
+	VerifyTokenHours     int   `value:"auth.verify-token-hours,default=48"`
+	VerifyResendSeconds  int   `value:"auth.verify-resend-seconds,default=60"`
+	RequireVerifiedEmail bool  `value:"auth.require-verified-email,default=false"`
+	ApiTokenDays         int   `value:"auth.api-token-days,default=90"`
+	ApiTokenMaxDays      int   `value:"auth.api-token-max-days,default=365"`
+	ImpersonateMinutes   int   `value:"auth.impersonate-minutes,default=15"`
 }
 
 func UIGrpcServer() api.GRPCServer {
@@ -106,7 +130,9 @@ func (t *implUIGrpcServer) PostConstruct() (err error) {
 	pb.RegisterAuthServiceHandlerFromEndpoint(context.Background(), api, t.GrpcAddress, opts)
 	pb.RegisterSiteServiceHandlerFromEndpoint(context.Background(), api, t.GrpcAddress, opts)
 
-	return nil
+	// registered later, so it takes over the generated handler of the page
+	t.gatewayMux = api
+	return api.HandlePath("GET", "/api/page/{name}", t.servePage)
 }
 
 func (t *implUIGrpcServer) GetStats(cb func(name, value string) bool) error {
@@ -115,88 +141,93 @@ func (t *implUIGrpcServer) GetStats(cb func(name, value string) bool) error {
 	cb("register.cnt", strconv.FormatInt(t.registerCnt.Load(), 10))
 	cb("restore.cnt", strconv.FormatInt(t.restoreCnt.Load(), 10))
 
-	return nil
+	return t.PageCache.GetStats(cb)
 }
 
-func (t *implUIGrpcServer) Page(ctx context.Context, req *pb.PageName) (*pb.PageContent, error) {
+func (t *implUIGrpcServer) Page(ctx context.Context, req *pb.PageName) (resp *pb.PageContent, err error) {
 
-	page, err := t.PageService.GetPage(ctx, req.Name)
-	if err == service.ErrPageNotFound {
-		return &pb.PageContent{
-			Title:   "Page Not Found",
-			Content: fmt.Sprintf("Oops, requested page '%s' is not found.", req.Name),
-		}, nil
+	resp, etag, modified, err := t.publishedPage(ctx, req.Name)
+	if err != nil {
+		id := t.NodeService.Issue().String()
+		t.Log.Error("Page", zap.String("errorId", id), zap.Error(err))
+		return nil, status.Errorf(codes.Internal, "internal error %s", id)
 	}
 
-	defer func() {
+	if etag != "" {
+		grpc.SetHeader(ctx, metadata.Pairs(
+			"etag", etag,
+			"last-modified", time.Unix(modified, 0).UTC().Format(http.TimeFormat)))
+	}
 
-		if err != nil {
-			id := t.NodeService.Issue().String()
-			t.Log.Error("Page", zap.String("errorId", id), zap.Error(err))
-			err = status.Errorf(codes.Internal, "internal error %s", id)
-		}
+	return resp, nil
+}
 
-	}()
+/**
+Rendered content of the published page from the cache with the ETag and the modification time.
+Missing page is not cached and has no ETag.
+ */
+func (t *implUIGrpcServer) publishedPage(ctx context.Context, name string) (*pb.PageContent, string, int64, error) {
+
+	name = utils.NormalizePageId(name)
 
+	page, rev, err := t.PageService.GetPublishedPage(ctx, name)
+	if err == service.ErrPageNotFound || err == service.ErrPageNotPublished {
+		return &pb.PageContent{
+			Title:   "Page Not Found",
+			Content: fmt.Sprintf("Oops, requested page '%s' is not found.", name),
+		}, "", 0, nil
+	}
 	if err != nil {
-		return nil, err
+		return nil, "", 0, err
 	}
 
-	var content string
-	if page.ContentType == pb.ContentType_MARKDOWN {
-		content = string(markdown.ToHTML([]byte(page.Content), nil, nil))
-	} else {
-		content = page.Content
-	}
+	content, etag, modified := t.PageCache.Load(page, rev, func() *pb.PageContent {
+		return t.renderPage(page, rev)
+	})
+	return content, etag, modified, nil
+}
 
-	return &pb.PageContent{Title: page.Title, Content: content }, nil
+func (t *implUIGrpcServer) renderPage(page *pb.PageEntity, rev *pb.PageRevisionEntity) *pb.PageContent {
+	return &pb.PageContent{
+		Title:   rev.Title,
+		Content: t.PageRenderer.Render(rev.Content, rev.ContentType, service.RenderTrust(page, rev)),
+	}
 }
 
 
+/**
+The account is soft deleted, the user content and the goodbye mail are handled by the UserPurger after the grace period.
+ */
 func (t *implUIGrpcServer) UserDelete(ctx context.Context, req *pb.UserId) (resp *emptypb.Empty, err error) {
 
-	resp = &emptypb.Empty{}
-
-	user, ok := t.AuthorizationMiddleware.GetUser(ctx)
-	if !ok || !user.Roles["WEB_USER"] {
-		return nil, status.Errorf(codes.Unauthenticated, "role WEB_USER is required")
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
 	}
 
-	if user.Username != req.Id {
-		return nil, status.Errorf(codes.Unauthenticated, "logged in user '%s' can not delete user id '%s'", user.Username, req.Id)
+	if user.UserId != utils.NormalizeUserId(req.Id) {
+		return nil, status.Errorf(codes.PermissionDenied, "logged in user '%s' can not delete user id '%s'", user.UserId, req.Id)
 	}
 
-	entity, err := t.UserService.GetUser(ctx, req.Id)
-	if err == service.ErrUserNotFound {
-		return nil, status.Errorf(codes.NotFound, "user not found")
-	}
+	defer func() {
 
-	err = t.UserService.RemoveUser(ctx, req.Id)
-	if err != nil {
-		err = t.wrapError(err, "UserDelete", req.Id)
-	} else {
-		err = t.UserService.DropUserContent(context.Background(), req.Id)
 		if err != nil {
-			err = t.wrapError(err, "UserDelete", req.Id)
+			err = t.wrapError(err, "UserDelete", user.UserId)
 		}
+
+	}()
+
+	err = t.UserService.SoftDeleteUser(ctx, user.UserId)
+	if err = userStatusError(err); err != nil {
+		return nil, err
 	}
 
-	subject := fmt.Sprintf("Goodbye %s.", entity.FirstName)
-	sender := t.Properties.GetString("mail.sender", "noreply@localhost")
-
-	mail := sprint.Mail{
-		Sender:      sender,
-		Recipients:   []string{entity.Email},
-		Subject:      subject,
-		TextTemplate: "resources:mail/deleted_user_text.tmpl",
-		HtmlTemplate: "resources:mail/deleted_user_html.tmpl",
-		Data:         map[string]interface{} {
-			"FirstName": entity.FirstName,
-			"Project": t.WebappName,
-		},
+	err = t.revokeSessions(ctx, user.UserId, "AccountDeleted")
+	if err != nil {
+		return nil, err
 	}
 
-	go t.MailService.SendMail(&mail, time.Minute, false)
+	t.AuthorizationMiddleware.InvalidateToken(user.Token)
 
-	return
+	return &emptypb.Empty{}, nil
 }
@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/sprintframework/sprint"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"github.com/sprintframework/template/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"time"
+)
+
+/**
+Impersonation token is the short living access token of the user with WEB_USER role only and without refresh,
+the admin identity is in the token context. Calls with it are logged by the interceptor.
+ */
+
+func (t *implUIGrpcServer) AdminImpersonate(ctx context.Context, req *pb.UserId) (resp *pb.ImpersonateResponse, err error) {
+
+	admin, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "AdminImpersonate", req.Id)
+		}
+
+	}()
+
+	userId := utils.NormalizeUserId(req.Id)
+	if userId == admin.UserId {
+		return nil, status.Errorf(codes.InvalidArgument, "self impersonation not permitted")
+	}
+
+	err = t.checkAdminTarget(ctx, admin, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	entity, err := t.UserService.GetUser(ctx, userId)
+	if err == service.ErrUserNotFound {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(time.Minute * time.Duration(t.ImpersonateMinutes)).Unix()
+
+	token, err := t.AuthorizationMiddleware.GenerateToken(&sprint.AuthorizedUser{
+		Username:  entity.UserId,
+		Roles:     map[string]bool{"WEB_USER": true},
+		Context:   map[string]string{
+			usernameContextKey:         entity.Username,
+			impersonatorContextKey:     admin.UserId,
+			impersonatorNameContextKey: admin.Username,
+		},
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+	err = t.SecurityLogService.LogImpersonation(ctx, admin.UserId, entity.UserId, "start", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ImpersonateResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func (t *implUIGrpcServer) ExitImpersonation(ctx context.Context, _ *emptypb.Empty) (resp *emptypb.Empty, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	if user.ImpersonatorId == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "not an impersonation token")
+	}
+
+	t.AuthorizationMiddleware.InvalidateToken(user.Token)
+
+	return &emptypb.Empty{}, nil
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/sprintframework/sprint"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"time"
+)
+
+const (
+	mfaContextKey    = "mfa"
+	deviceContextKey = "device"
+)
+
+func (t *implUIGrpcServer) issueMfaToken(entity *pb.UserEntity, device string) (*pb.LoginResponse, error) {
+
+	mfaToken, err := t.AuthorizationMiddleware.GenerateToken(&sprint.AuthorizedUser{
+		Username: entity.UserId,
+		Context: map[string]string{
+			mfaContextKey:    entity.UserId,
+			deviceContextKey: device,
+		},
+		ExpiresAt: time.Now().Add(time.Minute * time.Duration(t.MfaTokenMinutes)).Unix(),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.LoginResponse{
+		MfaRequired: true,
+		MfaToken:    mfaToken,
+	}, nil
+}
+
+func (t *implUIGrpcServer) LoginVerify(ctx context.Context, req *pb.LoginVerifyRequest) (resp *pb.LoginResponse, err error) {
+
+	user, err := t.AuthorizationMiddleware.ParseToken(req.MfaToken)
+	if err != nil || user.Context[mfaContextKey] == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid mfa token")
+	}
+	userId := user.Context[mfaContextKey]
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "LoginVerify", userId)
+		}
+
+	}()
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+
+	release, err := t.reserveLogin(ctx, userId, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	backupCode, err := t.UserService.VerifyTotp(ctx, userId, req.Code)
+	if err == service.ErrTotpInvalidCode {
+		if err = t.SecurityLogService.LogEvent(ctx, userId, "TotpFailed", remoteIP, userAgent); err != nil {
+			return nil, err
+		}
+		if err = t.loginFailed(ctx, userId, remoteIP, userAgent); err != nil {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "invalid code")
+	}
+	if err == service.ErrUserNotFound {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entity, err := t.UserService.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkLoginStatus(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := t.createSession(ctx, userId, user.Context[deviceContextKey])
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err = t.issueTokens(entity, session)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.LockoutService.LoginSucceeded(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if backupCode {
+		err = t.SecurityLogService.LogEvent(ctx, userId, "TotpBackupCodeUsed", remoteIP, userAgent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = t.SecurityLogService.LogEvent(ctx, userId, "Login", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	t.loginCnt.Inc()
+
+	return resp, nil
+}
+
+func (t *implUIGrpcServer) TotpEnroll(ctx context.Context, _ *emptypb.Empty) (resp *pb.TotpEnrollResponse, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "TotpEnroll", user.UserId)
+		}
+
+	}()
+
+	userId := user.UserId
+
+	secret, uri, err := t.UserService.EnrollTotp(ctx, userId, t.WebappName)
+	if err == service.ErrTotpAlreadyEnabled {
+		return nil, status.Errorf(codes.FailedPrecondition, "two-factor authentication is already enabled")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.TotpEnrollResponse{
+		Secret: secret,
+		Uri:    uri,
+	}, nil
+}
+
+func (t *implUIGrpcServer) TotpConfirm(ctx context.Context, req *pb.TotpCodeRequest) (resp *pb.TotpConfirmResponse, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "TotpConfirm", user.UserId)
+		}
+
+	}()
+
+	userId := user.UserId
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+
+	backupCodes, err := t.UserService.ConfirmTotp(ctx, userId, req.Code)
+	switch err {
+	case nil:
+	case service.ErrTotpInvalidCode:
+		if err = t.SecurityLogService.LogEvent(ctx, userId, "TotpFailed", remoteIP, userAgent); err != nil {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "invalid code")
+	case service.ErrTotpNotEnrolled:
+		return nil, status.Errorf(codes.FailedPrecondition, "two-factor authentication enrollment is not started")
+	case service.ErrTotpAlreadyEnabled:
+		return nil, status.Errorf(codes.FailedPrecondition, "two-factor authentication is already enabled")
+	default:
+		return nil, err
+	}
+
+	err = t.SecurityLogService.LogEvent(ctx, userId, "TotpEnabled", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.TotpConfirmResponse{
+		BackupCodes: backupCodes,
+	}, nil
+}
+
+func (t *implUIGrpcServer) TotpDisable(ctx context.Context, req *pb.TotpCodeRequest) (resp *emptypb.Empty, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "TotpDisable", user.UserId)
+		}
+
+	}()
+
+	userId := user.UserId
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+
+	err = t.UserService.DisableTotp(ctx, userId, req.Code)
+	switch err {
+	case nil:
+	case service.ErrTotpInvalidCode:
+		if err = t.SecurityLogService.LogEvent(ctx, userId, "TotpFailed", remoteIP, userAgent); err != nil {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "invalid code")
+	case service.ErrTotpNotEnrolled:
+		return nil, status.Errorf(codes.FailedPrecondition, "two-factor authentication is not enabled")
+	default:
+		return nil, err
+	}
+
+	err = t.SecurityLogService.LogEvent(ctx, userId, "TotpDisabled", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
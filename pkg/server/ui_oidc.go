@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func (t *implUIGrpcServer) OidcProviders(ctx context.Context, _ *emptypb.Empty) (*pb.OidcProvidersResponse, error) {
+	return &pb.OidcProvidersResponse{
+		Providers: t.OidcService.Providers(),
+	}, nil
+}
+
+func (t *implUIGrpcServer) OidcStart(ctx context.Context, req *pb.OidcStartRequest) (resp *pb.OidcStartResponse, err error) {
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "OidcStart", req.Provider)
+		}
+
+	}()
+
+	var linkUserId string
+	if user, ok := t.CurrentUser(ctx); ok && user.Roles["WEB_USER"] {
+		// linked account logs in without any token, only the owner of the login session could link it
+		if user.ImpersonatorId != "" || user.Context[apiTokenContextKey] != "" {
+			return nil, status.Errorf(codes.PermissionDenied, "external account could be linked only in the own login session")
+		}
+		linkUserId = user.UserId
+	}
+
+	authURL, binding, err := t.OidcService.StartLogin(ctx, req.Provider, linkUserId)
+	if err == service.ErrOidcProviderNotFound {
+		return nil, status.Errorf(codes.NotFound, "provider not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.OidcStartResponse{
+		AuthorizationUrl: authURL,
+		Binding:          binding,
+	}, nil
+}
+
+/**
+Returns tokens on login, empty response if the external account was linked to the current user.
+ */
+func (t *implUIGrpcServer) OidcCallback(ctx context.Context, req *pb.OidcCallbackRequest) (resp *pb.LoginResponse, err error) {
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "OidcCallback", req.State)
+		}
+
+	}()
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+
+	err = t.checkLoginAllowed(ctx, "", remoteIP)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, linkUserId, err := t.OidcService.FinishLogin(ctx, req.State, req.Code, req.Binding)
+	if err == service.ErrOidcInvalidState || err == service.ErrOidcProviderNotFound {
+		if err = t.loginFailed(ctx, "", remoteIP, userAgent); err != nil {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "external login failed, please try again")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if linkUserId != "" {
+		err = t.UserService.LinkIdentity(ctx, linkUserId, identity.Provider, identity.Subject)
+		if err == service.ErrIdentityAlreadyLinked {
+			return nil, status.Errorf(codes.AlreadyExists, "external account is linked to another user")
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = t.SecurityLogService.LogEvent(ctx, linkUserId, "IdentityLinked", remoteIP, userAgent)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.LoginResponse{}, nil
+	}
+
+	userId, err := t.UserService.GetUserIdByIdentity(ctx, identity.Provider, identity.Subject)
+	if err == service.ErrUserNotFound {
+		if identity.Email == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "external account has no email")
+		}
+		entity, err := t.UserService.CreateExternalUser(ctx, identity)
+		if err == service.ErrEmailNotVerified {
+			return nil, status.Errorf(codes.FailedPrecondition, "email of the external account is not verified by the provider")
+		}
+		if err == service.ErrEmailAlreadyUsed {
+			// never link by email automatically, the provider could be wrong about the owner
+			return nil, status.Errorf(codes.FailedPrecondition, "email already registered, login with password and link the external account")
+		}
+		if err != nil {
+			return nil, err
+		}
+		userId = entity.UserId
+		err = t.SecurityLogService.LogEvent(ctx, userId, "Registration", remoteIP, userAgent)
+		if err != nil {
+			return nil, err
+		}
+		t.registerCnt.Inc()
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = t.checkLoginAllowed(ctx, userId, "")
+	if err != nil {
+		return nil, err
+	}
+
+	entity, err := t.UserService.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkLoginStatus(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.RequireVerifiedEmail && !entity.Verified {
+		return nil, status.Errorf(codes.FailedPrecondition, "email is not verified")
+	}
+
+	if entity.TotpEnabled {
+		return t.issueMfaToken(entity, req.Device)
+	}
+
+	session, err := t.createSession(ctx, userId, req.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err = t.issueTokens(entity, session)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.SecurityLogService.LogEvent(ctx, userId, "OidcLogin", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	t.loginCnt.Inc()
+
+	return resp, nil
+}
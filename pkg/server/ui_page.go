@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/**
+Gateway handler of the public page with ETag, Last-Modified and conditional requests. The generated one can not
+answer 304 and puts the response metadata to Grpc-Metadata headers, the page is public, so it skips the interceptors.
+ */
+func (t *implUIGrpcServer) servePage(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+
+	mux := t.gatewayMux
+	_, outbound := runtime.MarshalerForRequest(mux, r)
+
+	content, etag, modified, err := t.publishedPage(r.Context(), pathParams["name"])
+	if err != nil {
+		id := t.NodeService.Issue().String()
+		t.Log.Error("Page", zap.String("errorId", id), zap.Error(err))
+		runtime.HTTPError(r.Context(), mux, outbound, w, r, status.Errorf(codes.Internal, "internal error %s", id))
+		return
+	}
+
+	if etag != "" {
+		lastModified := time.Unix(modified, 0).UTC()
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "no-cache")
+
+		if notModified(r, etag, lastModified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	data, err := outbound.Marshal(content)
+	if err != nil {
+		runtime.HTTPError(r.Context(), mux, outbound, w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", outbound.ContentType(content))
+	w.Write(data)
+}
+
+// If-None-Match takes precedence over If-Modified-Since by RFC 7232
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.After(since)
+}
@@ -0,0 +1,323 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/sprintframework/sprint"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"github.com/sprintframework/template/pkg/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	changeEmailContextKey = "change_email"
+	oldEmailContextKey    = "old_email"
+)
+
+func (t *implUIGrpcServer) notifyAccountChange(email, change, remoteIP string) error {
+
+	mail := sprint.Mail{
+		Sender:       t.Properties.GetString("mail.sender", "noreply@localhost"),
+		Recipients:   []string{email},
+		Subject:      fmt.Sprintf("Your %s on %s was changed.", change, t.WebappName),
+		TextTemplate: "resources:mail/account_changed_text.tmpl",
+		HtmlTemplate: "resources:mail/account_changed_html.tmpl",
+		Data:         map[string]interface{} {
+			"Change": change,
+			"RemoteIP": remoteIP,
+			"HelpEmail": t.Properties.GetString("mail.support", "support@localhost"),
+			"Project": t.WebappName,
+		},
+	}
+
+	return t.MailService.SendMail(&mail, time.Minute, true)
+}
+
+func (t *implUIGrpcServer) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (resp *emptypb.Empty, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "ChangePassword", user.UserId)
+		}
+
+	}()
+
+	userId := user.UserId
+
+	entity, err := t.UserService.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.checkPasswordPolicy("new_password", req.NewPassword, entity.Username, entity.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+
+	// the stolen access token does not give unlimited guesses of the current password
+	release, err := t.reserveLogin(ctx, userId, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	email, err := t.UserService.ChangePassword(ctx, userId, req.CurrentPassword, req.NewPassword)
+	if err == service.ErrUserInvalidPassword {
+		if err = t.loginFailed(ctx, userId, remoteIP, userAgent); err != nil {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "wrong current password")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// other devices have to login with the new password
+	if sessionId := user.Context[sessionContextKey]; sessionId != "" {
+		_, err = t.SessionService.RemoveOtherSessions(ctx, userId, sessionId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = t.SecurityLogService.LogEvent(ctx, userId, "ChangePassword", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.notifyAccountChange(email, "password", remoteIP)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (t *implUIGrpcServer) ChangeEmail(ctx context.Context, req *pb.ChangeEmailRequest) (resp *emptypb.Empty, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "ChangeEmail", user.UserId)
+		}
+
+	}()
+
+	newEmail := utils.NormalizeEmail(req.NewEmail)
+	if newEmail == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "new email is empty")
+	}
+
+	userId := user.UserId
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+
+	release, err := t.reserveLogin(ctx, userId, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	entity, err := t.UserService.AuthenticateUser(ctx, userId, req.Password)
+	if err == service.ErrUserInvalidPassword {
+		if err = t.loginFailed(ctx, userId, remoteIP, userAgent); err != nil {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "wrong current password")
+	}
+	if err == service.ErrUserDeleted {
+		return nil, status.Errorf(codes.FailedPrecondition, "account is deleted, undelete it to change the email")
+	}
+	if err == service.ErrUserSuspended {
+		return nil, checkUserStatus(entity)
+	}
+	if err == service.ErrPasswordResetRequired {
+		return nil, checkLoginStatus(entity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = t.UserService.GetUserIdByEmail(ctx, newEmail)
+	if err == nil {
+		return nil, status.Errorf(codes.AlreadyExists, "email already registered")
+	}
+	if err != service.ErrUserNotFound {
+		return nil, err
+	}
+
+	token, err := t.AuthorizationMiddleware.GenerateToken(&sprint.AuthorizedUser{
+		Username: entity.UserId,
+		Context: map[string]string{
+			changeEmailContextKey: entity.UserId,
+			emailContextKey:       newEmail,
+			oldEmailContextKey:    entity.Email,
+		},
+		ExpiresAt: time.Now().Add(time.Hour * time.Duration(t.VerifyTokenHours)).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var link string
+	if t.WebappURL != "" {
+		link = fmt.Sprintf("%s/change_email?token=%s", strings.TrimRight(t.WebappURL, "/"), url.QueryEscape(token))
+	}
+
+	mail := sprint.Mail{
+		Sender:       t.Properties.GetString("mail.sender", "noreply@localhost"),
+		Recipients:   []string{newEmail},
+		Subject:      fmt.Sprintf("Confirm your new email on %s", t.WebappName),
+		TextTemplate: "resources:mail/change_email_text.tmpl",
+		HtmlTemplate: "resources:mail/change_email_html.tmpl",
+		Data:         map[string]interface{} {
+			"FirstName": entity.FirstName,
+			"Project": t.WebappName,
+			"VerifyToken": token,
+			"VerifyLink": link,
+		},
+	}
+
+	err = t.MailService.SendMail(&mail, time.Minute, true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.SecurityLogService.LogEvent(ctx, userId, "ChangeEmailRequested", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (t *implUIGrpcServer) ConfirmEmailChange(ctx context.Context, req *pb.VerifyEmailRequest) (resp *emptypb.Empty, err error) {
+
+	user, err := t.AuthorizationMiddleware.ParseToken(req.Token)
+	if err != nil || user.Context[changeEmailContextKey] == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid or expired confirmation token")
+	}
+	userId := user.Context[changeEmailContextKey]
+	oldEmail := user.Context[oldEmailContextKey]
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "ConfirmEmailChange", userId)
+		}
+
+	}()
+
+	err = t.UserService.ChangeEmail(ctx, userId, oldEmail, user.Context[emailContextKey])
+	switch err {
+	case nil:
+	case service.ErrInvalidVerifyToken:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid or expired confirmation token")
+	case service.ErrEmailAlreadyUsed:
+		return nil, status.Errorf(codes.AlreadyExists, "email already registered")
+	case service.ErrUserNotFound:
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	default:
+		return nil, err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+	err = t.SecurityLogService.LogEvent(ctx, userId, "ChangeEmail", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	// the old address is the one that has to know about the change
+	err = t.notifyAccountChange(oldEmail, "email", remoteIP)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (t *implUIGrpcServer) UpdateProfile(ctx context.Context, req *pb.UpdateProfileRequest) (resp *pb.UpdateProfileResponse, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "UpdateProfile", user.UserId)
+		}
+
+	}()
+
+	userId := user.UserId
+
+	entity, err := t.UserService.UpdateProfile(ctx, userId, req)
+	if err == service.ErrUsernameNotAvailable {
+		return nil, status.Errorf(codes.AlreadyExists, "username not available")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp = &pb.UpdateProfileResponse{
+		User: t.toUser(entity, t.getWebUserRole(user)),
+	}
+
+	// username is the display claim of the tokens, reissue them only for the own login session of the caller,
+	// impersonation and api access tokens have no session and keep the old claim until they expire
+	sessionId := user.Context[sessionContextKey]
+	if entity.Username != user.Username && sessionId != "" && user.ImpersonatorId == "" && user.Context[apiTokenContextKey] == "" {
+		session, err := t.SessionService.GetSession(ctx, userId, sessionId)
+		switch err {
+		case nil:
+			tokens, err := t.issueTokens(entity, session)
+			if err != nil {
+				return nil, err
+			}
+			resp.Token = tokens.Token
+			resp.RefreshToken = tokens.RefreshToken
+		case service.ErrSessionNotFound:
+		default:
+			return nil, err
+		}
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+	err = t.SecurityLogService.LogEvent(ctx, userId, "UpdateProfile", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.notifyAccountChange(entity.Email, "profile", remoteIP)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
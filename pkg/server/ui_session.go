@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/sprintframework/template/pkg/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"strings"
+)
+
+const (
+	sessionContextKey = "sid"
+	refreshContextKey = "rid"
+	maxDeviceLength = 64
+)
+
+func (t *implUIGrpcServer) createSession(ctx context.Context, userId, device string) (*pb.SessionEntity, error) {
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+
+	device = strings.TrimSpace(device)
+	if len(device) > maxDeviceLength {
+		device = device[:maxDeviceLength]
+	}
+
+	return t.SessionService.CreateSession(ctx, userId, &pb.SessionEntity{
+		Device:    device,
+		RemoteIp:  remoteIP,
+		UserAgent: userAgent,
+	}, t.RefreshTokenHours * 3600)
+}
+
+func (t *implUIGrpcServer) Sessions(ctx context.Context, _ *emptypb.Empty) (resp *pb.SessionsResponse, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "Sessions", user.UserId)
+		}
+
+	}()
+
+	userId := user.UserId
+
+	currentId := user.Context[sessionContextKey]
+
+	resp = new(pb.SessionsResponse)
+	err = t.SessionService.EnumSessions(ctx, userId, func(session *pb.SessionEntity) bool {
+		resp.Items = append(resp.Items, &pb.SessionItem{
+			SessionId:  session.SessionId,
+			Device:     session.Device,
+			RemoteIp:   session.RemoteIp,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreTimestamp,
+			LastUsedAt: session.LastTimestamp,
+			Current:    session.SessionId == currentId,
+		})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (t *implUIGrpcServer) RevokeSession(ctx context.Context, req *pb.SessionIdRequest) (resp *emptypb.Empty, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "RevokeSession", user.UserId)
+		}
+
+	}()
+
+	userId := user.UserId
+
+	err = t.SessionService.RemoveSession(ctx, userId, req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+	err = t.SecurityLogService.LogEvent(ctx, userId, "SessionRevoked", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (t *implUIGrpcServer) RevokeOtherSessions(ctx context.Context, _ *emptypb.Empty) (resp *emptypb.Empty, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "RevokeOtherSessions", user.UserId)
+		}
+
+	}()
+
+	currentId := user.Context[sessionContextKey]
+	if currentId == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "current session is unknown, please login again")
+	}
+
+	userId := user.UserId
+
+	_, err = t.SessionService.RemoveOtherSessions(ctx, userId, currentId)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+	err = t.SecurityLogService.LogEvent(ctx, userId, "OtherSessionsRevoked", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
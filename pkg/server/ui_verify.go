@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/sprintframework/sprint"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	verifyContextKey = "verify"
+	emailContextKey  = "email"
+)
+
+/**
+The verification token is a signed JWT, nothing is stored on the server side.
+The token binds user id and email, so it stops working after the email change.
+ */
+
+func (t *implUIGrpcServer) generateVerifyToken(entity *pb.UserEntity) (token, link string, err error) {
+
+	token, err = t.AuthorizationMiddleware.GenerateToken(&sprint.AuthorizedUser{
+		Username: entity.UserId,
+		Context: map[string]string{
+			verifyContextKey: entity.UserId,
+			emailContextKey:  entity.Email,
+		},
+		ExpiresAt: time.Now().Add(time.Hour * time.Duration(t.VerifyTokenHours)).Unix(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	if t.WebappURL != "" {
+		link = fmt.Sprintf("%s/verify?token=%s", strings.TrimRight(t.WebappURL, "/"), url.QueryEscape(token))
+	}
+
+	return token, link, nil
+}
+
+func (t *implUIGrpcServer) VerifyEmail(ctx context.Context, req *pb.VerifyEmailRequest) (resp *emptypb.Empty, err error) {
+
+	user, err := t.AuthorizationMiddleware.ParseToken(req.Token)
+	if err != nil || user.Context[verifyContextKey] == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid or expired verification token")
+	}
+	userId := user.Context[verifyContextKey]
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "VerifyEmail", userId)
+		}
+
+	}()
+
+	err = t.UserService.VerifyEmail(ctx, userId, user.Context[emailContextKey])
+	if err == service.ErrInvalidVerifyToken {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid or expired verification token")
+	}
+	if err == service.ErrUserNotFound {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+	err = t.SecurityLogService.LogEvent(ctx, userId, "EmailVerified", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (t *implUIGrpcServer) ResendVerification(ctx context.Context, req *pb.ResendVerificationRequest) (resp *emptypb.Empty, err error) {
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "ResendVerification", req.Login)
+		}
+
+	}()
+
+	userId, err := t.UserService.GetUserIdByLogin(ctx, req.Login)
+	if err == service.ErrUserNotFound {
+		// do nothing, let's make illusion that this email also registered
+		t.Log.Info("ResendVerificationUserNotFound", zap.Any("login", req.Login))
+		return &emptypb.Empty{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if value, ok := t.verifyLimiterMap.Load(userId); ok {
+		if lastTime, ok := value.(time.Time); ok && now.Sub(lastTime) < time.Second * time.Duration(t.VerifyResendSeconds) {
+			return nil, status.Errorf(codes.ResourceExhausted, "verification mail was sent recently, please check your inbox")
+		}
+	}
+
+	entity, err := t.UserService.GetUser(ctx, userId)
+	if err == service.ErrUserNotFound {
+		return &emptypb.Empty{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if entity.Verified {
+		return &emptypb.Empty{}, nil
+	}
+
+	token, link, err := t.generateVerifyToken(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	mail := sprint.Mail{
+		Sender:       t.Properties.GetString("mail.sender", "noreply@localhost"),
+		Recipients:   []string{entity.Email},
+		Subject:      fmt.Sprintf("Confirm your email on %s", t.WebappName),
+		TextTemplate: "resources:mail/verify_text.tmpl",
+		HtmlTemplate: "resources:mail/verify_html.tmpl",
+		Data:         map[string]interface{} {
+			"FirstName": entity.FirstName,
+			"Project": t.WebappName,
+			"VerifyToken": token,
+			"VerifyLink": link,
+		},
+	}
+
+	err = t.MailService.SendMail(&mail, time.Minute, true)
+	if err != nil {
+		return nil, err
+	}
+
+	t.verifyLimiterMap.Store(userId, now)
+
+	return &emptypb.Empty{}, nil
+}
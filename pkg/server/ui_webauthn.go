@@ -0,0 +1,336 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func (t *implUIGrpcServer) WebauthnRegisterBegin(ctx context.Context, _ *emptypb.Empty) (resp *pb.WebauthnOptionsResponse, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "WebauthnRegisterBegin", user.UserId)
+		}
+
+	}()
+
+	userId := user.UserId
+
+	entity, err := t.UserService.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := t.WebauthnService.BeginRegistration(ctx, entity)
+	if err == service.ErrWebauthnNotConfigured {
+		return nil, status.Errorf(codes.FailedPrecondition, "webauthn is not configured")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.WebauthnOptionsResponse{
+		Options: options,
+	}, nil
+}
+
+func (t *implUIGrpcServer) WebauthnRegisterFinish(ctx context.Context, req *pb.WebauthnRegisterRequest) (resp *pb.WebauthnCredentialItem, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "WebauthnRegisterFinish", user.UserId)
+		}
+
+	}()
+
+	clientDataJSON, err := decodeBase64URL("client_data_json", req.ClientDataJson)
+	if err != nil {
+		return nil, err
+	}
+
+	attestationObject, err := decodeBase64URL("attestation_object", req.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+
+	userId := user.UserId
+
+	entity, err := t.UserService.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := t.WebauthnService.FinishRegistration(ctx, userId, req.Name, clientDataJSON, attestationObject)
+	if err == service.ErrWebauthnNotConfigured {
+		return nil, status.Errorf(codes.FailedPrecondition, "webauthn is not configured")
+	}
+	if err == service.ErrWebauthnInvalidResponse {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid webauthn response")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+
+	err = t.SecurityLogService.LogEvent(ctx, userId, "WebauthnCredentialAdded", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.notifyAccountChange(entity.Email, "passkeys", remoteIP)
+	if err != nil {
+		return nil, err
+	}
+
+	return toWebauthnCredentialItem(cred), nil
+}
+
+func (t *implUIGrpcServer) WebauthnLoginBegin(ctx context.Context, req *pb.WebauthnLoginBeginRequest) (resp *pb.WebauthnOptionsResponse, err error) {
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "WebauthnLoginBegin", req.Login)
+		}
+
+	}()
+
+	var userId string
+	if req.Login != "" {
+		userId, err = t.UserService.GetUserIdByLogin(ctx, req.Login)
+		if err == service.ErrUserNotFound {
+			// the same options as for the passkey, do not disclose the user
+			userId, err = "", nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	options, err := t.WebauthnService.BeginLogin(ctx, userId)
+	if err == service.ErrWebauthnNotConfigured {
+		return nil, status.Errorf(codes.FailedPrecondition, "webauthn is not configured")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.WebauthnOptionsResponse{
+		Options: options,
+	}, nil
+}
+
+/**
+Returns the same response as Login. TOTP is asked only if the authenticator did not verify the user,
+the passkey with user verification is a multi-factor credential itself.
+ */
+func (t *implUIGrpcServer) WebauthnLoginFinish(ctx context.Context, req *pb.WebauthnLoginRequest) (resp *pb.LoginResponse, err error) {
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "WebauthnLoginFinish", req.CredentialId)
+		}
+
+	}()
+
+	err = t.checkLoginAllowed(ctx, "", remoteIP)
+	if err != nil {
+		return nil, err
+	}
+
+	userHandle, err := decodeBase64URL("user_handle", req.UserHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataJSON, err := decodeBase64URL("client_data_json", req.ClientDataJson)
+	if err != nil {
+		return nil, err
+	}
+
+	authenticatorData, err := decodeBase64URL("authenticator_data", req.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := decodeBase64URL("signature", req.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, userVerified, err := t.WebauthnService.FinishLogin(ctx, req.CredentialId, userHandle, clientDataJSON, authenticatorData, signature)
+	if err == service.ErrWebauthnNotConfigured {
+		return nil, status.Errorf(codes.FailedPrecondition, "webauthn is not configured")
+	}
+	if err == service.ErrWebauthnSignCount {
+		if err = t.SecurityLogService.LogEvent(ctx, userId, "WebauthnSignCountError", remoteIP, userAgent); err != nil {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "passkey is rejected, it could be cloned")
+	}
+	if err == service.ErrWebauthnInvalidResponse {
+		if err = t.loginFailed(ctx, "", remoteIP, userAgent); err != nil {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "passkey login failed")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// locked account stays locked with the passkey too
+	err = t.checkLoginAllowed(ctx, userId, "")
+	if err != nil {
+		return nil, err
+	}
+
+	entity, err := t.UserService.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkLoginStatus(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.RequireVerifiedEmail && !entity.Verified {
+		return nil, status.Errorf(codes.FailedPrecondition, "email is not verified")
+	}
+
+	if entity.TotpEnabled && !userVerified {
+		return t.issueMfaToken(entity, req.Device)
+	}
+
+	session, err := t.createSession(ctx, userId, req.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err = t.issueTokens(entity, session)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.LockoutService.LoginSucceeded(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.SecurityLogService.LogEvent(ctx, userId, "WebauthnLogin", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	t.loginCnt.Inc()
+
+	return resp, nil
+}
+
+func (t *implUIGrpcServer) WebauthnCredentials(ctx context.Context, _ *emptypb.Empty) (resp *pb.WebauthnCredentialsResponse, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "WebauthnCredentials", user.UserId)
+		}
+
+	}()
+
+	userId := user.UserId
+
+	resp = new(pb.WebauthnCredentialsResponse)
+	err = t.WebauthnService.EnumCredentials(ctx, userId, func(cred *pb.WebauthnCredentialEntity) bool {
+		resp.Items = append(resp.Items, toWebauthnCredentialItem(cred))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (t *implUIGrpcServer) WebauthnRemoveCredential(ctx context.Context, req *pb.WebauthnCredentialIdRequest) (resp *emptypb.Empty, err error) {
+
+	user, ok := t.CurrentUser(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authorized")
+	}
+
+	defer func() {
+
+		if err != nil {
+			err = t.wrapError(err, "WebauthnRemoveCredential", user.UserId)
+		}
+
+	}()
+
+	userId := user.UserId
+
+	entity, err := t.UserService.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.WebauthnService.RemoveCredential(ctx, userId, req.CredentialId)
+	if err == service.ErrWebauthnCredentialNotFound {
+		return nil, status.Errorf(codes.NotFound, "credential not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	remoteIP, userAgent := getCallerInfo(ctx)
+
+	err = t.SecurityLogService.LogEvent(ctx, userId, "WebauthnCredentialRemoved", remoteIP, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.notifyAccountChange(entity.Email, "passkeys", remoteIP)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func toWebauthnCredentialItem(cred *pb.WebauthnCredentialEntity) *pb.WebauthnCredentialItem {
+	return &pb.WebauthnCredentialItem{
+		CredentialId: cred.CredentialId,
+		Name:         cred.Name,
+		CreatedAt:    cred.CreTimestamp,
+		LastUsedAt:   cred.LastTimestamp,
+	}
+}
@@ -8,9 +8,11 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
 	"github.com/pkg/errors"
 	"github.com/sprintframework/template/pkg/pb"
 	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
@@ -88,6 +90,38 @@ func appendH2ToNextProtos(ps []string) []string {
 	return append(ret, alpnProtoStrH2)
 }
 
+// accepts base64url with or without padding, as produced by PublicKeyCredential.toJSON()
+func decodeBase64URL(field, value string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s", field)
+	}
+	return data, nil
+}
+
+// returns InvalidArgument status with BadRequest details for the webapp, nil if the password is acceptable
+func (t *implUIGrpcServer) checkPasswordPolicy(field, password string, identities ...string) error {
+
+	violations := t.PasswordPolicy.Validate(password, identities...)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	badRequest := new(errdetails.BadRequest)
+	for _, description := range violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: description,
+		})
+	}
+
+	st, err := status.New(codes.InvalidArgument, violations[0]).WithDetails(badRequest)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, violations[0])
+	}
+	return st.Err()
+}
+
 func (t *implUIGrpcServer) wrapError(err error, method, username string) error {
 	if _, ok := status.FromError(err); ok {
 		return err
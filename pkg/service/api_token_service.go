@@ -0,0 +1,228 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/keyvalstore/store"
+	"github.com/pkg/errors"
+	"github.com/sprintframework/template/pkg/api"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/utils"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"strings"
+	"time"
+)
+
+const (
+	ApiTokenPrefix = "pat_"
+
+	apiTokenIdLength     = 12
+	apiTokenSecretLength = 32
+	apiTokenTouchSeconds = 60
+	maxApiTokenNameLength = 64
+)
+
+/**
+Personal access token has the form pat_<user id>_<token id>_<secret>, the user id and the token id
+find the entity under the user prefix, the secret is compared by the hash.
+ */
+
+type implApiTokenService struct {
+	Log                  *zap.Logger                `inject`
+	HostStore            store.DataStore            `inject:"bean=host-store"`
+	TransactionalManager store.TransactionalManager `inject:"bean=host-store"`
+
+	MaxTokens int `value:"api-token.max-tokens,default=20"` // per user
+}
+
+func ApiTokenService() api.ApiTokenService {
+	return &implApiTokenService{}
+}
+
+func (t *implApiTokenService) CreateToken(ctx context.Context, userId, name string, scopes []string, ttlSeconds int) (token string, entity *pb.ApiTokenEntity, err error) {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return "", nil, errors.New("user id is empty")
+	}
+
+	if ttlSeconds <= 0 {
+		return "", nil, errors.New("api token ttl is empty")
+	}
+
+	tokenId, err := utils.RandomString(utils.AlphaNumericAlphabet, apiTokenIdLength)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret, err := utils.RandomString(utils.AlphaNumericAlphabet, apiTokenSecretLength)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if len(name) > maxApiTokenNameLength {
+		name = name[:maxApiTokenNameLength]
+	}
+
+	now := time.Now().Unix()
+	entity = &pb.ApiTokenEntity{
+		TokenId:         tokenId,
+		Name:            name,
+		TokenHash:       utils.HashCode(secret),
+		Scopes:          scopes,
+		CreTimestamp:    now,
+		ExpireTimestamp: now + int64(ttlSeconds),
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	cnt := 0
+	err = t.EnumTokens(ctx, userId, func(entity *pb.ApiTokenEntity) bool {
+		cnt++
+		return true
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	if cnt >= t.MaxTokens {
+		return "", nil, ErrApiTokenLimit
+	}
+
+	err = t.HostStore.Set(ctx).ByKey("%s:user:api_token:%s", userId, tokenId).WithTtl(ttlSeconds).Proto(entity)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return ApiTokenPrefix + userId + "_" + tokenId + "_" + secret, entity, nil
+}
+
+func (t *implApiTokenService) ValidateToken(ctx context.Context, token string) (string, *pb.ApiTokenEntity, error) {
+
+	if !strings.HasPrefix(token, ApiTokenPrefix) {
+		return "", nil, ErrApiTokenInvalid
+	}
+
+	parts := strings.Split(strings.TrimPrefix(token, ApiTokenPrefix), "_")
+	if len(parts) != 3 {
+		return "", nil, ErrApiTokenInvalid
+	}
+
+	userId, tokenId, secret := parts[0], parts[1], parts[2]
+	if userId == "" || userId != utils.NormalizeUserId(userId) || len(tokenId) != apiTokenIdLength || tokenId != utils.NormalizeUserId(tokenId) {
+		return "", nil, ErrApiTokenInvalid
+	}
+
+	entity := new(pb.ApiTokenEntity)
+	err := t.HostStore.Get(ctx).ByKey("%s:user:api_token:%s", userId, tokenId).ToProto(entity)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if entity.TokenId != tokenId || subtle.ConstantTimeCompare([]byte(entity.TokenHash), []byte(utils.HashCode(secret))) != 1 {
+		return "", nil, ErrApiTokenInvalid
+	}
+
+	now := time.Now().Unix()
+	if entity.ExpireTimestamp <= now {
+		return "", nil, ErrApiTokenInvalid
+	}
+
+	if now - entity.LastTimestamp >= apiTokenTouchSeconds {
+		entity.LastTimestamp = now
+		err = t.HostStore.Set(ctx).ByKey("%s:user:api_token:%s", userId, tokenId).WithTtl(int(entity.ExpireTimestamp - now)).Proto(entity)
+		if err != nil {
+			// the token is valid, usage time is not important
+			t.Log.Warn("ApiTokenTouch", zap.String("userId", userId), zap.String("tokenId", tokenId), zap.Error(err))
+		}
+	}
+
+	return userId, entity, nil
+}
+
+func (t *implApiTokenService) EnumTokens(ctx context.Context, userId string, cb func(entity *pb.ApiTokenEntity) bool) error {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return errors.New("user id is empty")
+	}
+
+	return t.HostStore.Enumerate(ctx).
+		ByPrefix("%s:user:api_token:", userId).
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.ApiTokenEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.ApiTokenEntity); ok {
+				return cb(v)
+			}
+			return true
+		})
+
+}
+
+func (t *implApiTokenService) RevokeToken(ctx context.Context, userId, tokenId string) (err error) {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return errors.New("user id is empty")
+	}
+
+	tokenId = utils.NormalizeUserId(tokenId)
+	if tokenId == "" {
+		return ErrApiTokenNotFound
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	entity := new(pb.ApiTokenEntity)
+	err = t.HostStore.Get(ctx).ByKey("%s:user:api_token:%s", userId, tokenId).ToProto(entity)
+	if err != nil {
+		return err
+	}
+	if entity.TokenId != tokenId {
+		return ErrApiTokenNotFound
+	}
+
+	return t.HostStore.Remove(ctx).ByKey("%s:user:api_token:%s", userId, tokenId).Do()
+}
+
+func (t *implApiTokenService) RevokeAllTokens(ctx context.Context, userId string) (cnt int, err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	var list []string
+	err = t.EnumTokens(ctx, userId, func(entity *pb.ApiTokenEntity) bool {
+		list = append(list, entity.TokenId)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	userId = utils.NormalizeUserId(userId)
+	for _, tokenId := range list {
+		if err = t.HostStore.Remove(ctx).ByKey("%s:user:api_token:%s", userId, tokenId).Do(); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+
+	return cnt, nil
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service_test
+
+import (
+	"context"
+	"github.com/codeallergy/glue"
+	"github.com/keyvalstore/badgerstore"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestApiToken(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	apiTokenService := service.ApiTokenService()
+
+	ctx, err := glue.New(log, hostStore, apiTokenService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	token, entity, err := apiTokenService.CreateToken(bg, "u1", "ci", []string{"user"}, 3600)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(token, service.ApiTokenPrefix))
+	require.NotContains(t, entity.TokenHash, token)
+
+	userId, validated, err := apiTokenService.ValidateToken(bg, token)
+	require.NoError(t, err)
+	require.Equal(t, "u1", userId)
+	require.Equal(t, entity.TokenId, validated.TokenId)
+	require.NotZero(t, validated.LastTimestamp)
+
+	// wrong secret, zero is not in the alphabet
+	_, _, err = apiTokenService.ValidateToken(bg, token[:len(token)-1]+"0")
+	require.Equal(t, service.ErrApiTokenInvalid, err)
+
+	// token of another user
+	_, _, err = apiTokenService.ValidateToken(bg, strings.Replace(token, "_u1_", "_u2_", 1))
+	require.Error(t, err)
+
+	var list []*pb.ApiTokenEntity
+	err = apiTokenService.EnumTokens(bg, "u1", func(entity *pb.ApiTokenEntity) bool {
+		list = append(list, entity)
+		return true
+	})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, []string{"user"}, list[0].Scopes)
+
+	err = apiTokenService.RevokeToken(bg, "u1", entity.TokenId)
+	require.NoError(t, err)
+
+	_, _, err = apiTokenService.ValidateToken(bg, token)
+	require.Error(t, err)
+
+	err = apiTokenService.RevokeToken(bg, "u1", entity.TokenId)
+	require.Equal(t, service.ErrApiTokenNotFound, err)
+
+	token, _, err = apiTokenService.CreateToken(bg, "u1", "ci", []string{"user"}, 3600)
+	require.NoError(t, err)
+
+	_, _, err = apiTokenService.CreateToken(bg, "u1", "deploy", []string{"user"}, 3600)
+	require.NoError(t, err)
+
+	cnt, err := apiTokenService.RevokeAllTokens(bg, "u1")
+	require.NoError(t, err)
+	require.Equal(t, 2, cnt)
+
+	_, _, err = apiTokenService.ValidateToken(bg, token)
+	require.Error(t, err)
+
+}
@@ -9,4 +9,42 @@ const (
 	BatchSize = 128
 )
 
+const (
+	PermissionPagesRead   = "pages.read"
+	PermissionPagesWrite  = "pages.write"
+	PermissionPagesPublish = "pages.publish"
+	PermissionPagesTrust = "pages.trust"
+	PermissionUsersRead   = "users.read"
+	PermissionUsersWrite  = "users.write"
+	PermissionUsersDelete = "users.delete"
+	PermissionUsersImpersonate = "users.impersonate"
+	PermissionUsersExport = "users.export"
+	PermissionRolesRead   = "roles.read"
+	PermissionRolesWrite  = "roles.write"
+	PermissionAdminRun    = "admin.run"
+)
+
+/**
+All known permissions, custom roles could only use them.
+ */
+var Permissions = []string{
+	PermissionPagesRead,
+	PermissionPagesWrite,
+	PermissionPagesPublish,
+	PermissionPagesTrust,
+	PermissionUsersRead,
+	PermissionUsersWrite,
+	PermissionUsersDelete,
+	PermissionUsersImpersonate,
+	PermissionUsersExport,
+	PermissionRolesRead,
+	PermissionRolesWrite,
+	PermissionAdminRun,
+}
+
+/**
+Built-in role with all permissions, it is not stored and implied by UserRole ADMIN.
+ */
+const AdminRoleName = "admin"
+
 
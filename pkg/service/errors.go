@@ -16,10 +16,52 @@ var (
 	ErrUserAlreadyExist = errors.New("user already exist")
 	ErrUserNotFound = errors.New("user not found")
 	ErrUserInvalidPassword = errors.New("wrong password")
+	ErrUserSuspended = errors.New("user suspended")
+	ErrUserDeleted = errors.New("user deleted")
+	ErrUserNotDeleted = errors.New("user not deleted")
+	ErrPasswordResetRequired = errors.New("password reset required")
+	ErrUsernameNotAvailable = errors.New("username not available")
+	ErrEmailAlreadyUsed = errors.New("email already used")
 
 	ErrInvalidRecoverCode = errors.New("invalid recover code")
+	ErrInvalidVerifyToken = errors.New("invalid verify token")
+
+	ErrTotpNotEnrolled = errors.New("totp not enrolled")
+	ErrTotpAlreadyEnabled = errors.New("totp already enabled")
+	ErrTotpInvalidCode = errors.New("invalid totp code")
+
+	ErrSessionNotFound = errors.New("session not found")
+	ErrRefreshTokenReuse = errors.New("refresh token reuse")
+
+	ErrOidcProviderNotFound = errors.New("oidc provider not found")
+	ErrOidcInvalidState = errors.New("invalid oidc state")
+	ErrIdentityAlreadyLinked = errors.New("identity already linked")
+	ErrEmailNotVerified = errors.New("email not verified")
+
+	ErrApiTokenInvalid = errors.New("invalid api token")
+	ErrApiTokenNotFound = errors.New("api token not found")
+	ErrApiTokenLimit = errors.New("too many api tokens")
+
+	ErrWebauthnNotConfigured = errors.New("webauthn not configured")
+	ErrWebauthnInvalidResponse = errors.New("invalid webauthn response")
+	ErrWebauthnSignCount = errors.New("webauthn sign count did not increase")
+	ErrWebauthnCredentialNotFound = errors.New("webauthn credential not found")
+
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrUnknownSort = errors.New("unknown sort")
+
+	ErrExportNotFound = errors.New("export not found")
+	ErrUnknownExportFormat = errors.New("unknown export format")
+
+	ErrRoleNotFound = errors.New("role not found")
+	ErrRoleBuiltIn = errors.New("role is built-in")
+	ErrUnknownPermission = errors.New("unknown permission")
 
 	ErrPageNotFound = errors.New("page not found")
+	ErrRevisionNotFound = errors.New("revision not found")
+	ErrPageNotPublished = errors.New("page not published")
+	ErrInvalidPageState = errors.New("invalid page state")
+	ErrInvalidSchedule = errors.New("invalid schedule")
 )
 
 
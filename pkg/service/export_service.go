@@ -0,0 +1,287 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/keyvalstore/store"
+	"github.com/pkg/errors"
+	"github.com/sprintframework/template/pkg/api"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/utils"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	exportIdLength = 32
+
+	ExportFormatJson = "json"
+	ExportFormatZip  = "zip"
+)
+
+/**
+Export contains every entity under the user prefix decoded by protojson, secrets like the password hash
+are cleared. Entities of unknown type go to the "other" section with the raw value.
+ */
+
+type exportSection struct {
+	name      string
+	prefix    string   // key after the user id prefix
+	newEntity func() proto.Message
+	redact    func(proto.Message)
+}
+
+var exportSections = []*exportSection{
+	{
+		name:      "profile",
+		prefix:    "user",
+		newEntity: func() proto.Message { return new(pb.UserEntity) },
+		redact: func(m proto.Message) {
+			user := m.(*pb.UserEntity)
+			user.PasswordHash = nil
+			user.TotpSecret = ""
+			user.TotpPendingSecret = ""
+			user.TotpBackupCodes = nil
+		},
+	},
+	{
+		name:      "security_log",
+		prefix:    "user:security-log:",
+		newEntity: func() proto.Message { return new(pb.SecurityLogEntity) },
+	},
+	{
+		name:      "sessions",
+		prefix:    "user:session:",
+		newEntity: func() proto.Message { return new(pb.SessionEntity) },
+		redact: func(m proto.Message) {
+			m.(*pb.SessionEntity).RefreshId = ""
+		},
+	},
+	{
+		name:      "api_tokens",
+		prefix:    "user:api_token:",
+		newEntity: func() proto.Message { return new(pb.ApiTokenEntity) },
+		redact: func(m proto.Message) {
+			m.(*pb.ApiTokenEntity).TokenHash = ""
+		},
+	},
+	{
+		name:      "webauthn_credentials",
+		prefix:    "user:webauthn:",
+		newEntity: func() proto.Message { return new(pb.WebauthnCredentialEntity) },
+	},
+}
+
+type exportOther struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type implExportService struct {
+	Log         *zap.Logger       `inject`
+	HostStore   store.DataStore   `inject:"bean=host-store"`
+	UserService api.UserService   `inject`
+
+	LinkMinutes int `value:"export.link-minutes,default=60"`
+}
+
+func ExportService() api.ExportService {
+	return &implExportService{}
+}
+
+func (t *implExportService) ExportUser(ctx context.Context, userId, format string) (*pb.ExportEntity, error) {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return nil, errors.New("user id is empty")
+	}
+
+	if format == "" {
+		format = ExportFormatJson
+	}
+	if format != ExportFormatJson && format != ExportFormatZip {
+		return nil, ErrUnknownExportFormat
+	}
+
+	_, err := t.UserService.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	sections, err := t.collect(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	entity := &pb.ExportEntity{
+		UserId:          userId,
+		CreTimestamp:    now.Unix(),
+		ExpireTimestamp: now.Add(time.Duration(t.LinkMinutes) * time.Minute).Unix(),
+	}
+
+	baseName := fmt.Sprintf("%s-%s", userId, now.UTC().Format("20060102-150405"))
+
+	switch format {
+	case ExportFormatJson:
+		sections["user_id"] = userId
+		sections["exported_at"] = now.UTC().Format(time.RFC3339)
+		entity.Content, err = json.MarshalIndent(sections, "", "  ")
+		entity.FileName = baseName + ".json"
+		entity.ContentType = "application/json"
+	case ExportFormatZip:
+		entity.Content, err = zipSections(sections)
+		entity.FileName = baseName + ".zip"
+		entity.ContentType = "application/zip"
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entity.ExportId, err = utils.RandomString(utils.AlphaNumericAlphabet, exportIdLength)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.HostStore.Set(ctx).ByKey("export:%s", entity.ExportId).WithTtl(t.LinkMinutes * 60).Proto(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	return entity, nil
+}
+
+func (t *implExportService) collect(ctx context.Context, userId string) (map[string]interface{}, error) {
+
+	sections := make(map[string]interface{})
+	for _, section := range exportSections {
+		if section.prefix != "user" {
+			sections[section.name] = []json.RawMessage{}
+		}
+	}
+
+	var other []*exportOther
+	userPrefix := userId + ":"
+	marshaler := protojson.MarshalOptions{UseProtoNames: true}
+
+	var lastErr error
+	err := t.UserService.DumpUser(ctx, userId, func(entry *store.RawEntry) bool {
+
+		key := strings.TrimPrefix(string(entry.Key), userPrefix)
+		section := findExportSection(key)
+		if section == nil {
+			other = append(other, &exportOther{Key: key, Value: append([]byte(nil), entry.Value...)})
+			return true
+		}
+
+		entity := section.newEntity()
+		if err := proto.Unmarshal(entry.Value, entity); err != nil {
+			t.Log.Warn("ExportUnmarshal", zap.String("userId", userId), zap.String("key", key), zap.Error(err))
+			other = append(other, &exportOther{Key: key, Value: append([]byte(nil), entry.Value...)})
+			return true
+		}
+		if section.redact != nil {
+			section.redact(entity)
+		}
+
+		data, err := marshaler.Marshal(entity)
+		if err != nil {
+			lastErr = err
+			return false
+		}
+
+		if section.prefix == "user" {
+			sections[section.name] = json.RawMessage(data)
+		} else {
+			sections[section.name] = append(sections[section.name].([]json.RawMessage), data)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	if len(other) > 0 {
+		sections["other"] = other
+	}
+
+	return sections, nil
+}
+
+func findExportSection(key string) *exportSection {
+	for _, section := range exportSections {
+		if section.prefix == "user" {
+			if key == section.prefix {
+				return section
+			}
+		} else if strings.HasPrefix(key, section.prefix) {
+			return section
+		}
+	}
+	return nil
+}
+
+func zipSections(sections map[string]interface{}) ([]byte, error) {
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data, err := json.MarshalIndent(sections[name], "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		f, err := w.Create(name + ".json")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (t *implExportService) GetExport(ctx context.Context, exportId string) (*pb.ExportEntity, error) {
+
+	exportId = utils.NormalizeField(exportId)
+	if exportId == "" {
+		return nil, ErrExportNotFound
+	}
+
+	entity := new(pb.ExportEntity)
+	err := t.HostStore.Get(ctx).ByKey("export:%s", exportId).ToProto(entity)
+	if err != nil {
+		return nil, err
+	}
+	if entity.ExportId != exportId || entity.ExpireTimestamp < time.Now().Unix() {
+		return nil, ErrExportNotFound
+	}
+
+	return entity, nil
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/codeallergy/glue"
+	"github.com/keyvalstore/badgerstore"
+	"github.com/sprintframework/sprintframework/sprintcore"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"testing"
+)
+
+func TestExportUser(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-store", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	userService := service.UserService()
+	sessionService := service.SessionService()
+	securityLogService := service.SecurityLogService()
+	exportService := service.ExportService()
+
+	ctx, err := glue.New(log, configStore, sprintcore.ConfigRepository(1000), hostStore, userService, sessionService, securityLogService, exportService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	user, err := userService.CreateUser(bg, &pb.RegisterRequest{
+		Username: "exported",
+		Email: "exported@test.com",
+		Password: "test",
+	})
+	require.NoError(t, err)
+	userId := user.UserId
+
+	_, err = sessionService.CreateSession(bg, userId, &pb.SessionEntity{Device: "laptop"}, 3600)
+	require.NoError(t, err)
+
+	err = securityLogService.LogEvent(bg, userId, "Login", "127.0.0.1", "test")
+	require.NoError(t, err)
+
+	_, err = exportService.ExportUser(bg, userId, "xml")
+	require.Equal(t, service.ErrUnknownExportFormat, err)
+
+	entity, err := exportService.ExportUser(bg, userId, service.ExportFormatJson)
+	require.NoError(t, err)
+	require.Equal(t, "application/json", entity.ContentType)
+
+	var doc struct {
+		UserId      string                   `json:"user_id"`
+		Profile     map[string]interface{}   `json:"profile"`
+		SecurityLog []map[string]interface{} `json:"security_log"`
+		Sessions    []map[string]interface{} `json:"sessions"`
+	}
+	err = json.Unmarshal(entity.Content, &doc)
+	require.NoError(t, err)
+	require.Equal(t, userId, doc.UserId)
+	require.Equal(t, "exported@test.com", doc.Profile["email"])
+	require.NotContains(t, doc.Profile, "password_hash")
+	require.Equal(t, 1, len(doc.SecurityLog))
+	require.Equal(t, "Login", doc.SecurityLog[0]["event_name"])
+	require.Equal(t, 1, len(doc.Sessions))
+	require.Equal(t, "laptop", doc.Sessions[0]["device"])
+	require.NotContains(t, doc.Sessions[0], "refresh_id")
+
+	stored, err := exportService.GetExport(bg, entity.ExportId)
+	require.NoError(t, err)
+	require.Equal(t, entity.Content, stored.Content)
+
+	_, err = exportService.GetExport(bg, "unknown")
+	require.Equal(t, service.ErrExportNotFound, err)
+
+	entity, err = exportService.ExportUser(bg, userId, service.ExportFormatZip)
+	require.NoError(t, err)
+	require.Equal(t, "application/zip", entity.ContentType)
+
+	archive, err := zip.NewReader(bytes.NewReader(entity.Content), int64(len(entity.Content)))
+	require.NoError(t, err)
+
+	var files []string
+	for _, f := range archive.File {
+		files = append(files, f.Name)
+	}
+	require.Equal(t, []string{"api_tokens.json", "profile.json", "security_log.json", "sessions.json", "webauthn_credentials.json"}, files)
+
+}
@@ -0,0 +1,237 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"context"
+	"github.com/keyvalstore/store"
+	"github.com/sprintframework/template/pkg/api"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/utils"
+	"go.uber.org/zap"
+	"time"
+)
+
+type implLockoutService struct {
+	Log                  *zap.Logger                `inject`
+	HostStore            store.DataStore            `inject:"bean=host-store"`
+	TransactionalManager store.TransactionalManager `inject:"bean=host-store"`
+
+	MaxFailures    int   `value:"lockout.max-failures,default=10"`
+	IPMaxFailures  int   `value:"lockout.ip-max-failures,default=100"`
+	BaseDelay      int   `value:"lockout.base-delay,default=1"`     // seconds, doubles on each failure
+	MaxDelay       int   `value:"lockout.max-delay,default=60"`     // seconds
+	LockMinutes    int   `value:"lockout.lock-minutes,default=15"`
+	FailureTtl     int   `value:"lockout.failure-ttl,default=3600"` // seconds to remember the last failure
+	ReserveSeconds int   `value:"lockout.reserve-seconds,default=10"` // longest time of one attempt
+}
+
+func LockoutService() api.LockoutService {
+	return &implLockoutService{}
+}
+
+func (t *implLockoutService) CheckLogin(ctx context.Context, userId, remoteIP string) (time.Duration, error) {
+
+	var wait time.Duration
+	now := time.Now()
+
+	if userId = utils.NormalizeUserId(userId); userId != "" {
+		entity, err := t.getFailure(ctx, "user", userId)
+		if err != nil {
+			return 0, err
+		}
+		wait = t.waitTime(entity, now)
+	}
+
+	if remoteIP != "" {
+		entity, err := t.getFailure(ctx, "ip", remoteIP)
+		if err != nil {
+			return 0, err
+		}
+		if ipWait := t.waitTime(entity, now); ipWait > wait {
+			wait = ipWait
+		}
+	}
+
+	return wait, nil
+}
+
+/**
+The check and the reservation are in one transaction, so parallel attempts on the account do not pass the check
+before the failure of the first one is counted. The conflicting transaction fails and the attempt is refused.
+ */
+func (t *implLockoutService) ReserveLogin(ctx context.Context, userId, remoteIP string) (wait time.Duration, err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	wait, err = t.CheckLogin(ctx, userId, remoteIP)
+	if err != nil || wait > 0 {
+		return
+	}
+
+	if userId = utils.NormalizeUserId(userId); userId != "" {
+		entity, err := t.getFailure(ctx, "user", userId)
+		if err != nil {
+			return 0, err
+		}
+		now := time.Now()
+		entity.ReservedUntil = now.Add(time.Second * time.Duration(t.ReserveSeconds)).Unix()
+		err = t.saveFailure(ctx, "user", userId, entity, now)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return 0, nil
+}
+
+func (t *implLockoutService) ReleaseLogin(ctx context.Context, userId string) (err error) {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return nil
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	entity, err := t.getFailure(ctx, "user", userId)
+	if err != nil || entity.ReservedUntil == 0 {
+		return err
+	}
+
+	if entity.Failures == 0 {
+		return t.HostStore.Remove(ctx).ByKey("login-failure:user:%s", userId).Do()
+	}
+
+	entity.ReservedUntil = 0
+	return t.saveFailure(ctx, "user", userId, entity, time.Now())
+}
+
+func (t *implLockoutService) LoginFailed(ctx context.Context, userId, remoteIP string) (accountLocked, ipLocked bool, err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	if userId = utils.NormalizeUserId(userId); userId != "" {
+		accountLocked, err = t.countFailure(ctx, "user", userId, t.MaxFailures)
+		if err != nil {
+			return
+		}
+	}
+
+	if remoteIP != "" {
+		ipLocked, err = t.countFailure(ctx, "ip", remoteIP, t.IPMaxFailures)
+	}
+
+	return
+}
+
+func (t *implLockoutService) LoginSucceeded(ctx context.Context, userId string) error {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return nil
+	}
+
+	return t.HostStore.Remove(ctx).ByKey("login-failure:user:%s", userId).Do()
+}
+
+func (t *implLockoutService) Unlock(ctx context.Context, userId string) error {
+	return t.LoginSucceeded(ctx, userId)
+}
+
+func (t *implLockoutService) getFailure(ctx context.Context, kind, id string) (*pb.LoginFailureEntity, error) {
+	entity := new(pb.LoginFailureEntity)
+	err := t.HostStore.Get(ctx).ByKey("login-failure:%s:%s", kind, id).ToProto(entity)
+	return entity, err
+}
+
+// keeps the entity for the failure ttl, or longer until the lock expires
+func (t *implLockoutService) saveFailure(ctx context.Context, kind, id string, entity *pb.LoginFailureEntity, now time.Time) error {
+
+	ttl := t.FailureTtl
+	if locked := int(entity.LockedUntil - now.Unix()); locked > ttl {
+		ttl = locked
+	}
+	if reserved := int(entity.ReservedUntil - now.Unix()); reserved > ttl {
+		ttl = reserved
+	}
+
+	return t.HostStore.Set(ctx).ByKey("login-failure:%s:%s", kind, id).WithTtl(ttl).Proto(entity)
+}
+
+func (t *implLockoutService) countFailure(ctx context.Context, kind, id string, maxFailures int) (locked bool, err error) {
+
+	entity, err := t.getFailure(ctx, kind, id)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	if entity.LockedUntil != 0 && entity.LockedUntil <= now.Unix() {
+		// lock expired, start counting from scratch
+		entity.Failures = 0
+		entity.LockedUntil = 0
+	}
+	entity.Failures++
+	entity.LastTimestamp = now.Unix()
+	entity.ReservedUntil = 0 // the outcome of the reserved attempt
+
+	ttl := t.FailureTtl
+	if maxFailures > 0 && int(entity.Failures) == maxFailures {
+		entity.LockedUntil = now.Add(time.Minute * time.Duration(t.LockMinutes)).Unix()
+		if lockTtl := t.LockMinutes * 60; lockTtl > ttl {
+			ttl = lockTtl
+		}
+		locked = true
+		t.Log.Warn("LoginLocked", zap.String("kind", kind), zap.String("id", id), zap.Int32("failures", entity.Failures))
+	}
+
+	err = t.HostStore.Set(ctx).ByKey("login-failure:%s:%s", kind, id).WithTtl(ttl).Proto(entity)
+	return
+}
+
+func (t *implLockoutService) waitTime(entity *pb.LoginFailureEntity, now time.Time) time.Duration {
+
+	wait := t.failureWait(entity, now)
+	if reservedUntil := time.Unix(entity.ReservedUntil, 0); reservedUntil.After(now) && reservedUntil.Sub(now) > wait {
+		wait = reservedUntil.Sub(now)
+	}
+	return wait
+}
+
+func (t *implLockoutService) failureWait(entity *pb.LoginFailureEntity, now time.Time) time.Duration {
+
+	if entity.Failures == 0 {
+		return 0
+	}
+
+	if lockedUntil := time.Unix(entity.LockedUntil, 0); lockedUntil.After(now) {
+		return lockedUntil.Sub(now)
+	}
+
+	delay := time.Duration(t.BaseDelay) * time.Second
+	maxDelay := time.Duration(t.MaxDelay) * time.Second
+	for i := int32(1); i < entity.Failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	if next := time.Unix(entity.LastTimestamp, 0).Add(delay); next.After(now) {
+		return next.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service_test
+
+import (
+	"context"
+	"github.com/codeallergy/glue"
+	"github.com/keyvalstore/badgerstore"
+	"github.com/sprintframework/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockout(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	lockoutService := service.LockoutService()
+
+	ctx, err := glue.New(log, hostStore, lockoutService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	wait, err := lockoutService.CheckLogin(bg, "u1", "127.0.0.1")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+
+	accountLocked, ipLocked, err := lockoutService.LoginFailed(bg, "u1", "127.0.0.1")
+	require.NoError(t, err)
+	require.False(t, accountLocked)
+	require.False(t, ipLocked)
+
+	wait, err = lockoutService.CheckLogin(bg, "u1", "")
+	require.NoError(t, err)
+	require.True(t, wait > 0)
+
+	wait, err = lockoutService.CheckLogin(bg, "u2", "127.0.0.2")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+
+	for i := 2; i <= 10; i++ {
+		accountLocked, _, err = lockoutService.LoginFailed(bg, "u1", "")
+		require.NoError(t, err)
+	}
+	require.True(t, accountLocked)
+
+	wait, err = lockoutService.CheckLogin(bg, "u1", "")
+	require.NoError(t, err)
+	require.True(t, wait.Minutes() > 10)
+
+	err = lockoutService.Unlock(bg, "u1")
+	require.NoError(t, err)
+
+	wait, err = lockoutService.CheckLogin(bg, "u1", "")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+
+}
+
+func TestLockoutReserve(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	lockoutService := service.LockoutService()
+
+	ctx, err := glue.New(log, hostStore, lockoutService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	// parallel guesses do not pass the check before the outcome of the first one
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, err := lockoutService.ReserveLogin(bg, "u1", "127.0.0.1")
+			if err == nil && wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, allowed)
+
+	wait, err := lockoutService.CheckLogin(bg, "u1", "")
+	require.NoError(t, err)
+	require.True(t, wait > 0)
+
+	// the other accounts are not affected
+	wait, err = lockoutService.ReserveLogin(bg, "u2", "127.0.0.1")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+
+	err = lockoutService.ReleaseLogin(bg, "u2")
+	require.NoError(t, err)
+
+	// the failure ends the reservation and starts the backoff
+	accountLocked, _, err := lockoutService.LoginFailed(bg, "u1", "")
+	require.NoError(t, err)
+	require.False(t, accountLocked)
+
+	wait, err = lockoutService.CheckLogin(bg, "u1", "")
+	require.NoError(t, err)
+	require.True(t, wait > 0 && wait <= time.Second)
+
+	// the release without the failure leaves nothing behind
+	err = lockoutService.Unlock(bg, "u1")
+	require.NoError(t, err)
+
+	wait, err = lockoutService.ReserveLogin(bg, "u1", "")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+
+	err = lockoutService.ReleaseLogin(bg, "u1")
+	require.NoError(t, err)
+
+	wait, err = lockoutService.ReserveLogin(bg, "u1", "")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/codeallergy/glue"
+	"github.com/keyvalstore/store"
+	"github.com/pkg/errors"
+	"github.com/sprintframework/template/pkg/api"
+	"github.com/sprintframework/template/pkg/oidc"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/utils"
+	"go.uber.org/zap"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	oidcStateLength = 32
+)
+
+/**
+Providers are configured by properties, for example:
+
+	oidc.providers = google
+	oidc.redirect-url = https://example.com/oidc/callback
+	oidc.google.issuer = https://accounts.google.com
+	oidc.google.client-id = ...
+	oidc.google.client-secret = ...
+
+Providers without discovery document could set oidc.<name>.authorization-endpoint, token-endpoint and jwks-uri.
+ */
+
+type implOidcService struct {
+	Log                  *zap.Logger                `inject`
+	Properties           glue.Properties            `inject`
+	HostStore            store.DataStore            `inject:"bean=host-store"`
+	TransactionalManager store.TransactionalManager `inject:"bean=host-store"`
+
+	ProviderNames string `value:"oidc.providers,default="`
+	RedirectURL   string `value:"oidc.redirect-url,default="`
+	StateMinutes  int    `value:"oidc.state-minutes,default=10"`
+
+	providers map[string]*oidc.Provider
+}
+
+func OidcService() api.OidcService {
+	return &implOidcService{}
+}
+
+func (t *implOidcService) PostConstruct() error {
+
+	t.providers = make(map[string]*oidc.Provider)
+
+	for _, name := range strings.Split(t.ProviderNames, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		config := oidc.Config{
+			Name:                  name,
+			Issuer:                t.Properties.GetString("oidc."+name+".issuer", ""),
+			ClientID:              t.Properties.GetString("oidc."+name+".client-id", ""),
+			ClientSecret:          t.Properties.GetString("oidc."+name+".client-secret", ""),
+			Scopes:                strings.Fields(t.Properties.GetString("oidc."+name+".scopes", "")),
+			AuthorizationEndpoint: t.Properties.GetString("oidc."+name+".authorization-endpoint", ""),
+			TokenEndpoint:         t.Properties.GetString("oidc."+name+".token-endpoint", ""),
+			JwksURI:               t.Properties.GetString("oidc."+name+".jwks-uri", ""),
+		}
+
+		if config.Issuer == "" || config.ClientID == "" {
+			return errors.Errorf("oidc provider '%s' needs issuer and client-id", name)
+		}
+
+		t.providers[name] = oidc.NewProvider(config, nil)
+	}
+
+	if len(t.providers) > 0 && t.RedirectURL == "" {
+		return errors.New("oidc.redirect-url is empty")
+	}
+
+	return nil
+}
+
+func (t *implOidcService) Providers() []string {
+	var list []string
+	for name := range t.providers {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+/**
+The state goes through the provider and could be replayed by anybody who gets the callback URL, so it is bound
+to the browser by the random value returned to the client and required by the callback. Otherwise the attacker
+could send own state and code to the victim and log the victim into the attacker's account.
+ */
+func (t *implOidcService) StartLogin(ctx context.Context, providerName, linkUserId string) (string, string, error) {
+
+	provider, ok := t.providers[providerName]
+	if !ok {
+		return "", "", ErrOidcProviderNotFound
+	}
+
+	state, err := utils.RandomString(utils.AlphaNumericAlphabet, oidcStateLength)
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := utils.RandomString(utils.AlphaNumericAlphabet, oidcStateLength)
+	if err != nil {
+		return "", "", err
+	}
+
+	binding, err := utils.RandomString(utils.AlphaNumericAlphabet, oidcStateLength)
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier, err := oidc.GenerateCodeVerifier()
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, t.RedirectURL, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = t.HostStore.Set(ctx).ByKey("oidc-state:%s", state).WithTtl(t.StateMinutes * 60).Proto(&pb.OidcStateEntity{
+		Provider:     providerName,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		RedirectUri:  t.RedirectURL,
+		LinkUserId:   linkUserId,
+		CreTimestamp: time.Now().Unix(),
+		BindingHash:  utils.HashCode(binding),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return authURL, binding, nil
+}
+
+func (t *implOidcService) FinishLogin(ctx context.Context, state, code, binding string) (*pb.ExternalIdentity, string, error) {
+
+	entity, err := t.consumeState(ctx, state)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if binding == "" || subtle.ConstantTimeCompare([]byte(entity.BindingHash), []byte(utils.HashCode(binding))) != 1 {
+		t.Log.Warn("OidcBinding", zap.String("provider", entity.Provider))
+		return nil, "", ErrOidcInvalidState
+	}
+
+	if time.Now().Unix() - entity.CreTimestamp > int64(t.StateMinutes * 60) {
+		return nil, "", ErrOidcInvalidState
+	}
+
+	provider, ok := t.providers[entity.Provider]
+	if !ok {
+		return nil, "", ErrOidcProviderNotFound
+	}
+
+	claims, err := provider.Exchange(ctx, entity.RedirectUri, code, entity.CodeVerifier, entity.Nonce)
+	if err != nil {
+		t.Log.Warn("OidcExchange", zap.String("provider", entity.Provider), zap.Error(err))
+		return nil, "", ErrOidcInvalidState
+	}
+
+	return &pb.ExternalIdentity{
+		Provider:          entity.Provider,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, entity.LinkUserId, nil
+}
+
+// state is single use, removed before the code exchange
+func (t *implOidcService) consumeState(ctx context.Context, state string) (entity *pb.OidcStateEntity, err error) {
+
+	state = utils.NormalizeCode(state)
+	if state == "" {
+		return nil, ErrOidcInvalidState
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	entity = new(pb.OidcStateEntity)
+	err = t.HostStore.Get(ctx).ByKey("oidc-state:%s", state).ToProto(entity)
+	if err != nil {
+		return nil, err
+	}
+	if entity.Provider == "" {
+		return nil, ErrOidcInvalidState
+	}
+
+	err = t.HostStore.Remove(ctx).ByKey("oidc-state:%s", state).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return entity, nil
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/sprintframework/template/pkg/api"
+	"github.com/sprintframework/template/pkg/pb"
+	"go.uber.org/atomic"
+	"strconv"
+	"sync"
+)
+
+/**
+In-process LRU of the rendered pages. The entry is keyed by the name, the published revision and the trust level,
+so the caller reads the page entity and the stale entry is never returned even if an invalidation is missed.
+There is one entry per name, the page service drops it on every change to free the memory early.
+ */
+
+type implPageCache struct {
+	Size int `value:"page-cache.size,default=1000"` // zero disables the cache
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+
+	hitCnt  atomic.Int64
+	missCnt atomic.Int64
+}
+
+type pageCacheEntry struct {
+	name     string
+	revision int32
+	trust    pb.PageTrust
+	content  *pb.PageContent
+	etag     string
+	modified int64
+}
+
+func PageCache() api.PageCache {
+	return &implPageCache{}
+}
+
+func (t *implPageCache) PostConstruct() error {
+	t.lru = list.New()
+	t.items = make(map[string]*list.Element)
+	return nil
+}
+
+func (t *implPageCache) Load(page *pb.PageEntity, rev *pb.PageRevisionEntity, render func() *pb.PageContent) (*pb.PageContent, string, int64) {
+
+	trust := RenderTrust(page, rev)
+
+	t.mu.Lock()
+	if el, ok := t.items[page.Name]; ok {
+		entry := el.Value.(*pageCacheEntry)
+		if entry.revision == rev.Revision && entry.trust == trust {
+			t.lru.MoveToFront(el)
+			t.mu.Unlock()
+			t.hitCnt.Inc()
+			return entry.content, entry.etag, entry.modified
+		}
+	}
+	t.mu.Unlock()
+
+	t.missCnt.Inc()
+
+	content := render()
+	entry := &pageCacheEntry{
+		name:     page.Name,
+		revision: rev.Revision,
+		trust:    trust,
+		content:  content,
+		etag:     pageETag(content),
+		modified: pageModified(page),
+	}
+
+	if t.Size > 0 {
+		t.mu.Lock()
+		if el, ok := t.items[page.Name]; ok {
+			el.Value = entry
+			t.lru.MoveToFront(el)
+		} else {
+			t.items[page.Name] = t.lru.PushFront(entry)
+		}
+		for t.lru.Len() > t.Size {
+			el := t.lru.Back()
+			t.lru.Remove(el)
+			delete(t.items, el.Value.(*pageCacheEntry).name)
+		}
+		t.mu.Unlock()
+	}
+
+	return entry.content, entry.etag, entry.modified
+}
+
+func (t *implPageCache) Invalidate(name string) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if el, ok := t.items[name]; ok {
+		t.lru.Remove(el)
+		delete(t.items, name)
+	}
+}
+
+func (t *implPageCache) GetStats(cb func(name, value string) bool) error {
+
+	t.mu.Lock()
+	size := t.lru.Len()
+	t.mu.Unlock()
+
+	cb("page-cache.hit", strconv.FormatInt(t.hitCnt.Load(), 10))
+	cb("page-cache.miss", strconv.FormatInt(t.missCnt.Load(), 10))
+	cb("page-cache.size", strconv.Itoa(size))
+
+	return nil
+}
+
+// publishing of the revision and the trust level both change the rendered page
+func pageModified(page *pb.PageEntity) int64 {
+	if page.TrustTimestamp > page.PubTimestamp {
+		return page.TrustTimestamp
+	}
+	return page.PubTimestamp
+}
+
+// strong validator of the response body, the same content gives the same tag after restart
+func pageETag(content *pb.PageContent) string {
+	h := sha256.New()
+	h.Write([]byte(content.Title))
+	h.Write([]byte{0})
+	h.Write([]byte(content.Content))
+	return `"` + hex.EncodeToString(h.Sum(nil)[:12]) + `"`
+}
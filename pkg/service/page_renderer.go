@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"fmt"
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+	"github.com/sprintframework/template/pkg/api"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/utils"
+	"io"
+	"strings"
+)
+
+/**
+Renders the page content to HTML by the trust level of the page. STANDARD passes the result through the allowlist,
+STRICT also drops raw HTML of markdown and keeps only text formatting, TRUSTED is rendered verbatim.
+Lists of the allowlist are separated by spaces.
+ */
+
+type implPageRenderer struct {
+	AllowedTags       string `value:"page.sanitizer.tags,default=a abbr b blockquote br code dd del div dl dt em h1 h2 h3 h4 h5 h6 hr i img ins kbd li ol p pre q s small span strong sub sup table tbody td tfoot th thead tr u ul"`
+	AllowedAttributes string `value:"page.sanitizer.attributes,default=href src alt title class id width height colspan rowspan align start"`
+	AllowedSchemes    string `value:"page.sanitizer.url-schemes,default=http https mailto"`
+	StrictTags        string `value:"page.sanitizer.strict-tags,default=b blockquote br code em i li ol p pre strong ul"`
+	Embeds            bool   `value:"page.render.embeds,default=true"` // code highlighting, tables and heading anchors in markdown
+
+	policy *utils.HTMLPolicy
+	strict *utils.HTMLPolicy
+}
+
+func PageRenderer() api.PageRenderer {
+	return &implPageRenderer{}
+}
+
+func (t *implPageRenderer) PostConstruct() error {
+	t.policy = utils.NewHTMLPolicy(t.AllowedTags, t.AllowedAttributes, t.AllowedSchemes)
+	t.strict = utils.NewHTMLPolicy(t.StrictTags, "class", "")
+	return nil
+}
+
+func (t *implPageRenderer) Render(content string, contentType pb.ContentType, trust pb.PageTrust) string {
+
+	if contentType == pb.ContentType_MARKDOWN {
+		content = t.renderMarkdown(content, trust)
+	}
+
+	switch trust {
+	case pb.PageTrust_TRUSTED:
+		return content
+	case pb.PageTrust_STRICT:
+		return t.strict.Sanitize(content)
+	default:
+		return t.policy.Sanitize(content)
+	}
+}
+
+func (t *implPageRenderer) renderMarkdown(content string, trust pb.PageTrust) string {
+
+	extensions := parser.CommonExtensions
+	if t.Embeds {
+		extensions |= parser.AutoHeadingIDs
+	}
+
+	opts := html.RendererOptions{
+		Flags: html.CommonFlags | html.Safelink | html.NofollowLinks,
+	}
+	if trust == pb.PageTrust_STRICT {
+		opts.Flags |= html.SkipHTML
+	}
+	if t.Embeds {
+		opts.RenderNodeHook = renderEmbed
+	}
+
+	return string(markdown.ToHTML([]byte(content), parser.NewWithExtensions(extensions), html.NewRenderer(opts)))
+}
+
+func renderEmbed(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+
+	switch n := node.(type) {
+
+	case *ast.CodeBlock:
+		lang := strings.Fields(string(n.Info))
+		if len(lang) == 0 {
+			return ast.GoToNext, false
+		}
+		fmt.Fprintf(w, "<pre><code class=\"language-%s\">%s</code></pre>\n",
+			utils.NormalizeLowerUnreservedCharacters(lang[0]), utils.HighlightCode(lang[0], string(n.Literal)))
+		return ast.GoToNext, true
+
+	case *ast.Heading:
+		if n.HeadingID == "" || n.IsTitleblock {
+			return ast.GoToNext, false
+		}
+		id := utils.NormalizeUnreservedCharacters(n.HeadingID)
+		if entering {
+			fmt.Fprintf(w, "<h%d id=\"%s\">", n.Level, id)
+		} else {
+			fmt.Fprintf(w, " <a class=\"anchor\" href=\"#%s\">#</a></h%d>\n", id, n.Level)
+		}
+		return ast.GoToNext, true
+	}
+
+	return ast.GoToNext, false
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"context"
+	"github.com/sprintframework/template/pkg/api"
+	"github.com/sprintframework/template/pkg/pb"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+/**
+Background job that publishes and archives pages at the scheduled time.
+ */
+
+type implPageScheduler struct {
+	Log         *zap.Logger     `inject`
+	PageService api.PageService `inject`
+
+	IntervalSeconds int `value:"page-scheduler.interval-seconds,default=60"` // zero disables the background job
+
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+}
+
+func PageScheduler() api.PageScheduler {
+	return &implPageScheduler{shutdownCh: make(chan struct{})}
+}
+
+func (t *implPageScheduler) PostConstruct() error {
+	if t.IntervalSeconds > 0 {
+		go t.run(time.Duration(t.IntervalSeconds) * time.Second)
+	}
+	return nil
+}
+
+func (t *implPageScheduler) Destroy() error {
+	t.shutdownOnce.Do(func() {
+		close(t.shutdownCh)
+	})
+	return nil
+}
+
+func (t *implPageScheduler) run(interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.shutdownCh:
+			return
+		case now := <-ticker.C:
+			cnt, err := t.ApplySchedule(context.Background(), now)
+			if err != nil {
+				t.Log.Error("ApplySchedule", zap.Error(err))
+			} else if cnt > 0 {
+				t.Log.Info("ApplySchedule", zap.Int("pages", cnt))
+			}
+		}
+	}
+}
+
+func (t *implPageScheduler) ApplySchedule(ctx context.Context, now time.Time) (int, error) {
+
+	var list []*pb.PageEntity
+	err := t.PageService.EnumScheduledPages(ctx, now, func(page *pb.PageEntity) bool {
+		list = append(list, page)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// one broken page does not stop the schedule of the others
+	var cnt int
+	for _, page := range list {
+		if err := t.applyPage(ctx, page, now); err != nil {
+			t.Log.Error("ApplySchedulePage", zap.String("name", page.Name), zap.Error(err))
+			continue
+		}
+		cnt++
+	}
+
+	return cnt, nil
+}
+
+func (t *implPageScheduler) applyPage(ctx context.Context, page *pb.PageEntity, now time.Time) (err error) {
+
+	if page.PublishAt > 0 && page.PublishAt <= now.Unix() {
+		page, err = t.PageService.SetPageState(ctx, page.Name, pb.PageState_PUBLISHED)
+		if err != nil {
+			return err
+		}
+		t.Log.Info("PagePublished", zap.String("name", page.Name), zap.Int32("revision", page.PublishedRevision))
+	}
+
+	if page.UnpublishAt > 0 && page.UnpublishAt <= now.Unix() {
+		page, err = t.PageService.SetPageState(ctx, page.Name, pb.PageState_ARCHIVED)
+		if err != nil {
+			return err
+		}
+		t.Log.Info("PageArchived", zap.String("name", page.Name))
+	}
+
+	return nil
+}
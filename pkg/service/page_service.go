@@ -7,6 +7,7 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"github.com/keyvalstore/store"
 	"github.com/pkg/errors"
 	"github.com/sprintframework/template/pkg/api"
@@ -18,16 +19,72 @@ import (
 	"time"
 )
 
+const pageIndexVersion = "1"
+
 type implPageService struct {
 	Log                  *zap.Logger                `inject`
 	HostStore            store.DataStore            `inject:"bean=host-store"`
 	TransactionalManager store.TransactionalManager `inject:"bean=host-store"`
+	PageCache            api.PageCache              `inject:"optional"`
 }
 
 func PageService() api.PageService {
 	return &implPageService{}
 }
 
+/**
+Pages created before the workflow were visible on the site, they become published once.
+ */
+func (t *implPageService) PostConstruct() (err error) {
+
+	ctx := t.TransactionalManager.BeginTransaction(context.Background(), false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	version, err := t.HostStore.Get(ctx).ByKey("page-index:version").ToString()
+	if err != nil {
+		return err
+	}
+	if version == pageIndexVersion {
+		return nil
+	}
+
+	var list []*pb.PageEntity
+	err = t.EnumPages(ctx, func(page *pb.PageEntity) bool {
+		if page.State == pb.PageState_DRAFT && page.PublishedRevision == 0 {
+			list = append(list, page)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, page := range list {
+		if page.Revision == 0 {
+			page.Revision = 1
+			if page.ModTimestamp == 0 {
+				page.ModTimestamp = page.CreTimestamp
+			}
+			err = t.saveRevision(ctx, page, "", "", "", pb.PageTrust_STANDARD)
+			if err != nil {
+				return err
+			}
+		}
+		page.State = pb.PageState_PUBLISHED
+		page.PublishedRevision = page.Revision
+		page.PubTimestamp = page.ModTimestamp
+		err = t.HostStore.Set(ctx).ByKey("page:%s", page.Name).Proto(page)
+		if err != nil {
+			return err
+		}
+	}
+
+	t.Log.Info("PageIndex", zap.Int("published", len(list)), zap.String("version", pageIndexVersion))
+	return t.HostStore.Set(ctx).ByKey("page-index:version").String(pageIndexVersion)
+}
+
 func (t *implPageService) GetPage(ctx context.Context, name string) (*pb.PageEntity, error) {
 
 	name = utils.NormalizePageId(name)
@@ -54,7 +111,7 @@ func (t *implPageService) GetPage(ctx context.Context, name string) (*pb.PageEnt
 
 }
 
-func (t *implPageService) CreatePage(ctx context.Context, newPage *pb.AdminPage) (err error) {
+func (t *implPageService) CreatePage(ctx context.Context, newPage *pb.AdminPage, authorId, authorName string, authorTrusted bool) (err error) {
 
 	newPage.Name = utils.NormalizePageId(newPage.Name)
 	if newPage.Name == "" {
@@ -64,6 +121,7 @@ func (t *implPageService) CreatePage(ctx context.Context, newPage *pb.AdminPage)
 	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
 	defer func() {
 		err = t.TransactionalManager.EndTransaction(ctx, err)
+		t.invalidate(newPage.Name)
 	}()
 
 	entity := new(pb.PageEntity)
@@ -80,34 +138,66 @@ func (t *implPageService) CreatePage(ctx context.Context, newPage *pb.AdminPage)
 	contentType, err := t.parseContentType(newPage.ContentType)
 	if err != nil {
 		err = errors.Errorf("nowrap: invalid content type '%s'", newPage.ContentType)
+		return
 	}
 
+	now := time.Now().Unix()
 	entity = &pb.PageEntity{
 		Name:         newPage.Name,
 		Title:        newPage.Title,
 		Content:      newPage.Content,
 		ContentType:  contentType,
-		CreTimestamp: time.Now().Unix(),
+		CreTimestamp: now,
+		ModTimestamp: now,
+		Revision:     1,
 	}
 
-	err = t.HostStore.Set(ctx).ByKey("page:%s", newPage.Name).Proto(entity)
+	err = t.savePage(ctx, entity, authorId, authorName, newPage.Note, authorTrust(authorTrusted))
 	return
 
 }
 
-func (t *implPageService) UpdatePage(ctx context.Context, updatingPage *pb.AdminPage) (err error) {
+/**
+Every update is the new revision of the page, the page entity keeps the last one.
+ */
+func (t *implPageService) UpdatePage(ctx context.Context, updatingPage *pb.AdminPage, authorId, authorName string, authorTrusted bool) (err error) {
 
 	updatingPage.Name = utils.NormalizePageId(updatingPage.Name)
 	if updatingPage.Name == "" {
 		return errors.New("updating page name is empty")
 	}
+	updatingPage.Prev = utils.NormalizePageId(updatingPage.Prev)
+
+	prevName := updatingPage.Name
+	if updatingPage.Prev != "" {
+		prevName = updatingPage.Prev
+	}
 
 	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
 	defer func() {
 		err = t.TransactionalManager.EndTransaction(ctx, err)
+		t.invalidate(prevName, updatingPage.Name)
 	}()
 
-	if updatingPage.Name != updatingPage.Prev && updatingPage.Prev != "" {
+	prev := new(pb.PageEntity)
+	err = t.HostStore.Get(ctx).ByKey("page:%s", prevName).ToProto(prev)
+	if err != nil {
+		return
+	}
+
+	if prev.Name != "" && prev.Revision == 0 {
+		// page from the time before the history
+		prev.Revision = 1
+		if prev.ModTimestamp == 0 {
+			prev.ModTimestamp = prev.CreTimestamp
+		}
+		err = t.saveRevision(ctx, prev, "", "", "", pb.PageTrust_STANDARD)
+		if err != nil {
+			return
+		}
+	}
+
+	if updatingPage.Name != prevName {
 
 		entity := new(pb.PageEntity)
 		err = t.HostStore.Get(ctx).ByKey("page:%s", updatingPage.Name).ToProto(entity)
@@ -120,41 +210,532 @@ func (t *implPageService) UpdatePage(ctx context.Context, updatingPage *pb.Admin
 			return
 		}
 
-		err = t.HostStore.Remove(ctx).ByKey("page:%s", updatingPage.Prev).Do()
+		err = t.HostStore.Remove(ctx).ByKey("page:%s", prevName).Do()
 		if err != nil {
 			return
 		}
 
+		err = t.moveRevisions(ctx, prevName, updatingPage.Name)
+		if err != nil {
+			return
+		}
+
+	}
+
+	err = t.setScheduleIndex(ctx, prev, false)
+	if err != nil {
+		return
 	}
 
 	contentType, err := t.parseContentType(updatingPage.ContentType)
 	if err != nil {
 		err = errors.Errorf("nowrap: invalid content type '%s'", updatingPage.ContentType)
+		return
 	}
 
+	now := time.Now().Unix()
 	entity := &pb.PageEntity{
 		Name:         updatingPage.Name,
 		Title:        updatingPage.Title,
 		Content:      updatingPage.Content,
 		ContentType:  contentType,
-		CreTimestamp: time.Now().Unix(),
+		CreTimestamp: prev.CreTimestamp,
+		ModTimestamp: now,
+		Revision:     prev.Revision + 1,
+		State:        pb.PageState_DRAFT,
+		PublishedRevision: prev.PublishedRevision,
+		PubTimestamp: prev.PubTimestamp,
+		// PublishAt is not copied, the publishing was scheduled for the reviewed revision, not for this one
+		UnpublishAt:  prev.UnpublishAt,
+		Trust:        prev.Trust,
+		TrustTimestamp: prev.TrustTimestamp,
+	}
+	if entity.CreTimestamp == 0 {
+		entity.CreTimestamp = now
 	}
+	setTrust(entity, revisionTrust(prev.Trust, authorTrusted))
 
-	err = t.HostStore.Set(ctx).ByKey("page:%s", updatingPage.Name).Proto(entity)
+	err = t.setScheduleIndex(ctx, entity, true)
+	if err != nil {
+		return
+	}
+
+	err = t.savePage(ctx, entity, authorId, authorName, updatingPage.Note, authorTrust(authorTrusted))
 	return
 
 }
 
-func (t *implPageService) RemovePage(ctx context.Context, name string) error {
+func (t *implPageService) RemovePage(ctx context.Context, name string) (err error) {
 
 	name = utils.NormalizePageId(name)
 	if name == "" {
 		return errors.New("page name is empty")
 	}
 
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+		t.invalidate(name)
+	}()
+
+	page := new(pb.PageEntity)
+	err = t.HostStore.Get(ctx).ByKey("page:%s", name).ToProto(page)
+	if err != nil {
+		return err
+	}
+
+	if page.Name != "" {
+		err = t.setScheduleIndex(ctx, page, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	list, err := t.listRevisions(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	for _, rev := range list {
+		err = t.HostStore.Remove(ctx).ByKey("page-revision:%s:%010d", name, rev.Revision).Do()
+		if err != nil {
+			return err
+		}
+	}
+
 	return t.HostStore.Remove(ctx).ByKey("page:%s", name).Do()
 }
 
+func (t *implPageService) GetRevision(ctx context.Context, name string, revision int32) (*pb.PageRevisionEntity, error) {
+
+	name = utils.NormalizePageId(name)
+	if name == "" {
+		return nil, errors.New("page name is empty")
+	}
+
+	rev := new(pb.PageRevisionEntity)
+	err := t.HostStore.Get(ctx).ByKey("page-revision:%s:%010d", name, revision).ToProto(rev)
+	if err != nil {
+		return nil, err
+	}
+	if rev.Name != name || rev.Revision != revision {
+		return nil, ErrRevisionNotFound
+	}
+	return rev, nil
+}
+
+func (t *implPageService) ScanRevisions(ctx context.Context, name, cursor string, reverse bool, limit int, cb func(rev *pb.PageRevisionEntity)) (string, error) {
+
+	name = utils.NormalizePageId(name)
+	if name == "" {
+		return "", errors.New("page name is empty")
+	}
+
+	// the newest first by default
+	prefix := fmt.Sprintf("page-revision:%s:", name)
+	return scanPrefix(ctx, t.HostStore, prefix, cursor, !reverse, 0, limit, func(entry *store.RawEntry, position int) bool {
+		rev := new(pb.PageRevisionEntity)
+		if err := proto.Unmarshal(entry.Value, rev); err != nil {
+			t.Log.Warn("ScanRevisions", zap.String("key", string(entry.Key)), zap.Error(err))
+			return false
+		}
+		cb(rev)
+		return true
+	})
+
+}
+
+func (t *implPageService) DiffRevisions(ctx context.Context, name string, from, to int32) (*pb.PageRevisionEntity, *pb.PageRevisionEntity, []utils.DiffLine, error) {
+
+	if to == 0 {
+		page, err := t.GetPage(ctx, name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		to = page.Revision
+	}
+	if from == 0 {
+		from = to - 1
+	}
+
+	fromRev, err := t.GetRevision(ctx, name, from)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	toRev, err := t.GetRevision(ctx, name, to)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return fromRev, toRev, utils.DiffText(fromRev.Content, toRev.Content), nil
+}
+
+func (t *implPageService) RollbackPage(ctx context.Context, name string, revision int32, authorId, authorName, note string, authorTrusted bool) (newRevision int32, err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+		t.invalidate(name)
+	}()
+
+	page, err := t.GetPage(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	rev, err := t.GetRevision(ctx, name, revision)
+	if err != nil {
+		return 0, err
+	}
+
+	if note == "" {
+		note = fmt.Sprintf("rollback to revision %d", revision)
+	}
+
+	err = t.setScheduleIndex(ctx, page, false)
+	if err != nil {
+		return 0, err
+	}
+
+	page.Title = rev.Title
+	page.Content = rev.Content
+	page.ContentType = rev.ContentType
+	page.ModTimestamp = time.Now().Unix()
+	page.Revision++
+	page.State = pb.PageState_DRAFT
+	page.PublishAt = 0
+	setTrust(page, revisionTrust(page.Trust, authorTrusted))
+
+	err = t.setScheduleIndex(ctx, page, true)
+	if err != nil {
+		return 0, err
+	}
+
+	// the author vouches for the content by the rollback only up to the own level
+	err = t.savePage(ctx, page, authorId, authorName, note, LowerTrust(rev.Trust, authorTrust(authorTrusted)))
+	return page.Revision, err
+}
+
+/**
+Raw HTML is trusted for the content written by the users with pages.trust permission only, the new revision
+of any other author is sanitized. STRICT level stays, it is stricter than the default one.
+ */
+func revisionTrust(trust pb.PageTrust, authorTrusted bool) pb.PageTrust {
+	if trust == pb.PageTrust_TRUSTED && !authorTrusted {
+		return pb.PageTrust_STANDARD
+	}
+	return trust
+}
+
+// the level changes the rendering of the published revision, so Last-Modified follows the change too
+func setTrust(page *pb.PageEntity, trust pb.PageTrust) {
+	if page.Trust != trust {
+		page.Trust = trust
+		page.TrustTimestamp = time.Now().Unix()
+	}
+}
+
+// level of the content written by the author
+func authorTrust(authorTrusted bool) pb.PageTrust {
+	if authorTrusted {
+		return pb.PageTrust_TRUSTED
+	}
+	return pb.PageTrust_STANDARD
+}
+
+var trustRank = map[pb.PageTrust]int{
+	pb.PageTrust_STRICT:   0,
+	pb.PageTrust_STANDARD: 1,
+	pb.PageTrust_TRUSTED:  2,
+}
+
+/**
+Returns the stricter one of the levels.
+ */
+func LowerTrust(a, b pb.PageTrust) pb.PageTrust {
+	if trustRank[a] < trustRank[b] {
+		return a
+	}
+	return b
+}
+
+/**
+The page level is set by the users with pages.trust permission, the revision keeps the level of the author,
+so promoting the page never trusts the content of the other authors.
+ */
+func RenderTrust(page *pb.PageEntity, rev *pb.PageRevisionEntity) pb.PageTrust {
+	return LowerTrust(page.Trust, rev.Trust)
+}
+
+/**
+The state belongs to the last revision, the published revision stays visible on the site until the next publishing
+or archiving. Editing of the page returns it to the draft.
+ */
+func (t *implPageService) SetPageState(ctx context.Context, name string, state pb.PageState) (page *pb.PageEntity, err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+		t.invalidate(name)
+	}()
+
+	page, err = t.GetPage(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.setScheduleIndex(ctx, page, false)
+	if err != nil {
+		return nil, err
+	}
+
+	switch state {
+	case pb.PageState_DRAFT:
+	case pb.PageState_IN_REVIEW:
+		if page.State != pb.PageState_DRAFT {
+			return nil, ErrInvalidPageState
+		}
+	case pb.PageState_PUBLISHED:
+		if page.Revision == 0 {
+			return nil, ErrInvalidPageState
+		}
+		page.PublishedRevision = page.Revision
+		page.PubTimestamp = time.Now().Unix()
+		page.PublishAt = 0
+	case pb.PageState_ARCHIVED:
+		page.PublishedRevision = 0
+		page.PublishAt = 0
+		page.UnpublishAt = 0
+	default:
+		return nil, ErrInvalidPageState
+	}
+	page.State = state
+
+	err = t.setScheduleIndex(ctx, page, true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.HostStore.Set(ctx).ByKey("page:%s", page.Name).Proto(page)
+	return page, err
+}
+
+func (t *implPageService) SetPageTrust(ctx context.Context, name string, trust pb.PageTrust) (err error) {
+
+	if _, ok := pb.PageTrust_name[int32(trust)]; !ok {
+		return errors.Errorf("unknown trust level %d", trust)
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+		t.invalidate(name)
+	}()
+
+	page, err := t.GetPage(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	setTrust(page, trust)
+	return t.HostStore.Set(ctx).ByKey("page:%s", page.Name).Proto(page)
+}
+
+func (t *implPageService) SchedulePage(ctx context.Context, name string, publishAt, unpublishAt int64) (err error) {
+
+	if publishAt < 0 || unpublishAt < 0 || publishAt > 0 && unpublishAt > 0 && unpublishAt <= publishAt {
+		return ErrInvalidSchedule
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+		t.invalidate(name)
+	}()
+
+	page, err := t.GetPage(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	err = t.setScheduleIndex(ctx, page, false)
+	if err != nil {
+		return err
+	}
+
+	page.PublishAt = publishAt
+	page.UnpublishAt = unpublishAt
+
+	err = t.setScheduleIndex(ctx, page, true)
+	if err != nil {
+		return err
+	}
+
+	return t.HostStore.Set(ctx).ByKey("page:%s", page.Name).Proto(page)
+}
+
+func (t *implPageService) EnumScheduledPages(ctx context.Context, dueBefore time.Time, cb func(page *pb.PageEntity) bool) error {
+
+	stop := fmt.Sprintf("page-schedule:%012d:", dueBefore.Unix() + 1)
+	seen := make(map[string]bool)
+
+	var names []string
+	err := t.HostStore.Enumerate(ctx).
+		ByPrefix("page-schedule:").
+		WithBatchSize(BatchSize).
+		Do(func(entry *store.RawEntry) bool {
+			if string(entry.Key) >= stop {
+				return false
+			}
+			name := string(entry.Value)
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+			return true
+		})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		page, err := t.GetPage(ctx, name)
+		if err == ErrPageNotFound {
+			t.Log.Warn("EnumScheduledPages", zap.String("name", name), zap.Error(err))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !cb(page) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (t *implPageService) GetPublishedPage(ctx context.Context, name string) (*pb.PageEntity, *pb.PageRevisionEntity, error) {
+
+	page, err := t.GetPage(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case page.PublishedRevision == 0:
+		return nil, nil, ErrPageNotPublished
+	case page.PublishedRevision == page.Revision:
+		return page, &pb.PageRevisionEntity{
+			Name:        page.Name,
+			Revision:    page.Revision,
+			Title:       page.Title,
+			Content:     page.Content,
+			ContentType: page.ContentType,
+			Timestamp:   page.ModTimestamp,
+		}, nil
+	default:
+		rev, err := t.GetRevision(ctx, page.Name, page.PublishedRevision)
+		return page, rev, err
+	}
+}
+
+// drops rendered pages after the transaction, the committed or the rolled back one
+func (t *implPageService) invalidate(names ...string) {
+	if t.PageCache != nil {
+		for _, name := range names {
+			t.PageCache.Invalidate(utils.NormalizePageId(name))
+		}
+	}
+}
+
+func (t *implPageService) setScheduleIndex(ctx context.Context, page *pb.PageEntity, add bool) error {
+
+	for _, at := range []int64{page.PublishAt, page.UnpublishAt} {
+		if at == 0 {
+			continue
+		}
+		var err error
+		if add {
+			err = t.HostStore.Set(ctx).ByKey("page-schedule:%012d:%s", at, page.Name).String(page.Name)
+		} else {
+			err = t.HostStore.Remove(ctx).ByKey("page-schedule:%012d:%s", at, page.Name).Do()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *implPageService) savePage(ctx context.Context, page *pb.PageEntity, authorId, authorName, note string, trust pb.PageTrust) error {
+
+	err := t.saveRevision(ctx, page, authorId, authorName, note, trust)
+	if err != nil {
+		return err
+	}
+
+	return t.HostStore.Set(ctx).ByKey("page:%s", page.Name).Proto(page)
+}
+
+func (t *implPageService) saveRevision(ctx context.Context, page *pb.PageEntity, authorId, authorName, note string, trust pb.PageTrust) error {
+
+	return t.HostStore.Set(ctx).ByKey("page-revision:%s:%010d", page.Name, page.Revision).Proto(&pb.PageRevisionEntity{
+		Name:        page.Name,
+		Revision:    page.Revision,
+		Title:       page.Title,
+		Content:     page.Content,
+		ContentType: page.ContentType,
+		AuthorId:    authorId,
+		AuthorName:  authorName,
+		Timestamp:   page.ModTimestamp,
+		Note:        note,
+		Trust:       trust,
+	})
+}
+
+func (t *implPageService) listRevisions(ctx context.Context, name string) ([]*pb.PageRevisionEntity, error) {
+
+	var list []*pb.PageRevisionEntity
+	err := t.HostStore.Enumerate(ctx).
+		ByPrefix("page-revision:%s:", name).
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.PageRevisionEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.PageRevisionEntity); ok {
+				list = append(list, v)
+			}
+			return true
+		})
+
+	return list, err
+}
+
+func (t *implPageService) moveRevisions(ctx context.Context, from, to string) error {
+
+	list, err := t.listRevisions(ctx, from)
+	if err != nil {
+		return err
+	}
+
+	for _, rev := range list {
+		err = t.HostStore.Remove(ctx).ByKey("page-revision:%s:%010d", from, rev.Revision).Do()
+		if err != nil {
+			return err
+		}
+		rev.Name = to
+		err = t.HostStore.Set(ctx).ByKey("page-revision:%s:%010d", to, rev.Revision).Proto(rev)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (t *implPageService) EnumPages(ctx context.Context, cb func(page *pb.PageEntity) bool) error {
 
 	return t.HostStore.Enumerate(ctx).
@@ -171,6 +752,24 @@ func (t *implPageService) EnumPages(ctx context.Context, cb func(page *pb.PageEn
 
 }
 
+func (t *implPageService) ScanPages(ctx context.Context, cursor string, reverse bool, offset, limit int, cb func(page *pb.PageEntity, position int)) (string, error) {
+
+	return scanPrefix(ctx, t.HostStore, "page:", cursor, reverse, offset, limit, func(entry *store.RawEntry, position int) bool {
+		page := new(pb.PageEntity)
+		if err := proto.Unmarshal(entry.Value, page); err != nil {
+			t.Log.Warn("ScanPages", zap.String("key", string(entry.Key)), zap.Error(err))
+			return false
+		}
+		cb(page, position)
+		return true
+	})
+
+}
+
+func (t *implPageService) EstimatePages(ctx context.Context) (int, bool, error) {
+	return estimatePrefix(ctx, t.HostStore, "page:")
+}
+
 func (t *implPageService) parseContentType(ct string) (pb.ContentType, error) {
 	contentType := pb.ContentType_MARKDOWN
 	switch strings.ToUpper(strings.TrimSpace(ct)) {
@@ -0,0 +1,577 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service_test
+
+import (
+	"context"
+	"github.com/codeallergy/glue"
+	"github.com/keyvalstore/badgerstore"
+	"github.com/pkg/errors"
+	"github.com/sprintframework/template/pkg/api"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"github.com/sprintframework/template/pkg/utils"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPageRevisions(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	pageService := service.PageService()
+
+	ctx, err := glue.New(log, hostStore, pageService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	err = pageService.CreatePage(bg, &pb.AdminPage{
+		Name:        "about",
+		Title:       "About",
+		Content:     "one\ntwo\nthree\n",
+		ContentType: "MARKDOWN",
+	}, "u1", "alice", false)
+	require.NoError(t, err)
+
+	page, err := pageService.GetPage(bg, "about")
+	require.NoError(t, err)
+	require.Equal(t, int32(1), page.Revision)
+	created := page.CreTimestamp
+
+	err = pageService.UpdatePage(bg, &pb.AdminPage{
+		Name:        "about-us",
+		Prev:        "about",
+		Title:       "About Us",
+		Content:     "one\n2\nthree\nfour\n",
+		ContentType: "MARKDOWN",
+		Note:        "rename",
+	}, "u2", "bob", false)
+	require.NoError(t, err)
+
+	_, err = pageService.GetPage(bg, "about")
+	require.Equal(t, service.ErrPageNotFound, err)
+
+	page, err = pageService.GetPage(bg, "about-us")
+	require.NoError(t, err)
+	require.Equal(t, int32(2), page.Revision)
+	require.Equal(t, created, page.CreTimestamp)
+	require.True(t, page.ModTimestamp >= created)
+
+	var revisions []*pb.PageRevisionEntity
+	_, err = pageService.ScanRevisions(bg, "about-us", "", false, 10, func(rev *pb.PageRevisionEntity) {
+		revisions = append(revisions, rev)
+	})
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	require.Equal(t, int32(2), revisions[0].Revision)
+	require.Equal(t, "bob", revisions[0].AuthorName)
+	require.Equal(t, "rename", revisions[0].Note)
+	require.Equal(t, "about-us", revisions[1].Name)
+	require.Equal(t, "u1", revisions[1].AuthorId)
+
+	fromRev, toRev, lines, err := pageService.DiffRevisions(bg, "about-us", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), fromRev.Revision)
+	require.Equal(t, int32(2), toRev.Revision)
+	require.Equal(t, []utils.DiffLine{
+		{Op: utils.DiffEqual, Text: "one", FromLine: 1, ToLine: 1},
+		{Op: utils.DiffRemoved, Text: "two", FromLine: 2},
+		{Op: utils.DiffAdded, Text: "2", ToLine: 2},
+		{Op: utils.DiffEqual, Text: "three", FromLine: 3, ToLine: 3},
+		{Op: utils.DiffAdded, Text: "four", ToLine: 4},
+	}, lines)
+
+	revision, err := pageService.RollbackPage(bg, "about-us", 1, "u1", "alice", "", false)
+	require.NoError(t, err)
+	require.Equal(t, int32(3), revision)
+
+	page, err = pageService.GetPage(bg, "about-us")
+	require.NoError(t, err)
+	require.Equal(t, "one\ntwo\nthree\n", page.Content)
+	require.Equal(t, "About", page.Title)
+
+	rev, err := pageService.GetRevision(bg, "about-us", 3)
+	require.NoError(t, err)
+	require.Equal(t, "rollback to revision 1", rev.Note)
+
+	_, err = pageService.GetRevision(bg, "about-us", 7)
+	require.Equal(t, service.ErrRevisionNotFound, err)
+
+	_, err = pageService.RollbackPage(bg, "about-us", 7, "u1", "alice", "", false)
+	require.Equal(t, service.ErrRevisionNotFound, err)
+
+	err = pageService.RemovePage(bg, "about-us")
+	require.NoError(t, err)
+
+	_, err = pageService.GetRevision(bg, "about-us", 1)
+	require.Equal(t, service.ErrRevisionNotFound, err)
+
+}
+
+func TestPageWorkflow(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	pageService := service.PageService()
+	pageScheduler := service.PageScheduler()
+
+	ctx, err := glue.New(log, hostStore, pageService, pageScheduler)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	err = pageService.CreatePage(bg, &pb.AdminPage{Name: "news", Title: "News", Content: "v1", ContentType: "HTML"}, "u1", "alice", false)
+	require.NoError(t, err)
+
+	_, _, err = pageService.GetPublishedPage(bg, "news")
+	require.Equal(t, service.ErrPageNotPublished, err)
+
+	page, err := pageService.SetPageState(bg, "news", pb.PageState_IN_REVIEW)
+	require.NoError(t, err)
+	require.Equal(t, pb.PageState_IN_REVIEW, page.State)
+
+	_, err = pageService.SetPageState(bg, "news", pb.PageState_IN_REVIEW)
+	require.Equal(t, service.ErrInvalidPageState, err)
+
+	page, err = pageService.SetPageState(bg, "news", pb.PageState_PUBLISHED)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), page.PublishedRevision)
+
+	// the published revision stays live while the draft is edited
+	err = pageService.UpdatePage(bg, &pb.AdminPage{Name: "news", Title: "News", Content: "v2", ContentType: "HTML"}, "u1", "alice", false)
+	require.NoError(t, err)
+
+	page, err = pageService.GetPage(bg, "news")
+	require.NoError(t, err)
+	require.Equal(t, pb.PageState_DRAFT, page.State)
+
+	_, published, err := pageService.GetPublishedPage(bg, "news")
+	require.NoError(t, err)
+	require.Equal(t, "v1", published.Content)
+
+	err = pageService.SchedulePage(bg, "news", 2000, 1000)
+	require.Equal(t, service.ErrInvalidSchedule, err)
+
+	now := time.Now()
+	err = pageService.SchedulePage(bg, "news", now.Unix() + 60, now.Unix() + 3600)
+	require.NoError(t, err)
+
+	cnt, err := pageScheduler.ApplySchedule(bg, now)
+	require.NoError(t, err)
+	require.Equal(t, 0, cnt)
+
+	cnt, err = pageScheduler.ApplySchedule(bg, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, cnt)
+
+	_, published, err = pageService.GetPublishedPage(bg, "news")
+	require.NoError(t, err)
+	require.Equal(t, "v2", published.Content)
+
+	page, err = pageService.GetPage(bg, "news")
+	require.NoError(t, err)
+	require.Equal(t, pb.PageState_PUBLISHED, page.State)
+	require.Equal(t, int64(0), page.PublishAt)
+
+	cnt, err = pageScheduler.ApplySchedule(bg, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, cnt)
+
+	_, _, err = pageService.GetPublishedPage(bg, "news")
+	require.Equal(t, service.ErrPageNotPublished, err)
+
+	page, err = pageService.GetPage(bg, "news")
+	require.NoError(t, err)
+	require.Equal(t, pb.PageState_ARCHIVED, page.State)
+
+	cnt, err = pageScheduler.ApplySchedule(bg, now.Add(24 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 0, cnt)
+
+	// the trust level stays with the page through edits
+	err = pageService.SetPageTrust(bg, "news", pb.PageTrust_STRICT)
+	require.NoError(t, err)
+
+	err = pageService.UpdatePage(bg, &pb.AdminPage{Name: "news", Title: "News", Content: "v3", ContentType: "HTML"}, "u1", "alice", false)
+	require.NoError(t, err)
+
+	page, err = pageService.GetPage(bg, "news")
+	require.NoError(t, err)
+	require.Equal(t, pb.PageTrust_STRICT, page.Trust)
+
+	// raw HTML stays trusted only for the authors with the trust permission
+	err = pageService.SetPageTrust(bg, "news", pb.PageTrust_TRUSTED)
+	require.NoError(t, err)
+
+	err = pageService.UpdatePage(bg, &pb.AdminPage{Name: "news", Title: "News", Content: "v4", ContentType: "HTML"}, "u1", "alice", true)
+	require.NoError(t, err)
+
+	page, err = pageService.GetPage(bg, "news")
+	require.NoError(t, err)
+	require.Equal(t, pb.PageTrust_TRUSTED, page.Trust)
+
+	err = pageService.UpdatePage(bg, &pb.AdminPage{Name: "news", Title: "News", Content: "v5", ContentType: "HTML"}, "u2", "bob", false)
+	require.NoError(t, err)
+
+	page, err = pageService.GetPage(bg, "news")
+	require.NoError(t, err)
+	require.Equal(t, pb.PageTrust_STANDARD, page.Trust)
+
+	err = pageService.SetPageTrust(bg, "news", pb.PageTrust_TRUSTED)
+	require.NoError(t, err)
+
+	_, err = pageService.RollbackPage(bg, "news", 1, "u2", "bob", "", false)
+	require.NoError(t, err)
+
+	page, err = pageService.GetPage(bg, "news")
+	require.NoError(t, err)
+	require.Equal(t, pb.PageTrust_STANDARD, page.Trust)
+
+	// the edit after the scheduling cancels the publishing, the new revision is not reviewed
+	err = pageService.SchedulePage(bg, "news", now.Unix() + 60, 0)
+	require.NoError(t, err)
+
+	err = pageService.UpdatePage(bg, &pb.AdminPage{Name: "news", Title: "News", Content: "unreviewed", ContentType: "HTML"}, "u2", "bob", false)
+	require.NoError(t, err)
+
+	page, err = pageService.GetPage(bg, "news")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), page.PublishAt)
+
+	cnt, err = pageScheduler.ApplySchedule(bg, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 0, cnt)
+
+	_, _, err = pageService.GetPublishedPage(bg, "news")
+	require.Equal(t, service.ErrPageNotPublished, err)
+
+}
+
+type brokenPageService struct {
+	api.PageService
+	broken string
+}
+
+func (t *brokenPageService) PostConstruct() error {
+	return nil
+}
+
+func (t *brokenPageService) SetPageState(ctx context.Context, name string, state pb.PageState) (*pb.PageEntity, error) {
+	if name == t.broken {
+		return nil, errors.Errorf("page '%s' is broken", name)
+	}
+	return t.PageService.SetPageState(ctx, name, state)
+}
+
+func TestPageSchedulerFailure(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	pageService := service.PageService()
+
+	ctx, err := glue.New(log, hostStore, pageService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	pageScheduler := service.PageScheduler()
+
+	schedulerCtx, err := glue.New(log, &brokenPageService{PageService: pageService, broken: "a"}, pageScheduler)
+	require.NoError(t, err)
+	defer schedulerCtx.Close()
+
+	bg := context.Background()
+	now := time.Now()
+
+	for _, name := range []string{"a", "b"} {
+		err = pageService.CreatePage(bg, &pb.AdminPage{Name: name, Title: name, Content: "v1", ContentType: "HTML"}, "u1", "alice", false)
+		require.NoError(t, err)
+
+		err = pageService.SchedulePage(bg, name, now.Unix() + 60, 0)
+		require.NoError(t, err)
+	}
+
+	// the page after the broken one is published anyway
+	cnt, err := pageScheduler.ApplySchedule(bg, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, cnt)
+
+	_, _, err = pageService.GetPublishedPage(bg, "b")
+	require.NoError(t, err)
+
+	_, _, err = pageService.GetPublishedPage(bg, "a")
+	require.Equal(t, service.ErrPageNotPublished, err)
+
+}
+
+func TestPageRenderer(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	pageRenderer := service.PageRenderer()
+
+	ctx, err := glue.New(log, pageRenderer)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	content := "# Title\n\n<script>alert(1)</script>\n\n[x](javascript:alert(1))\n\n```go\nfunc main() {}\n```\n\n| a | b |\n|---|---|\n| 1 | 2 |\n"
+
+	standard := pageRenderer.Render(content, pb.ContentType_MARKDOWN, pb.PageTrust_STANDARD)
+	require.NotContains(t, standard, "<script")
+	require.NotContains(t, standard, "javascript:")
+	require.Contains(t, standard, `<h1 id="title">Title <a class="anchor" href="#title">#</a></h1>`)
+	require.Contains(t, standard, `<span class="hl-kw">func</span>`)
+	require.Contains(t, standard, "<table>")
+
+	strict := pageRenderer.Render(content, pb.ContentType_MARKDOWN, pb.PageTrust_STRICT)
+	require.NotContains(t, strict, "<script")
+	require.NotContains(t, strict, "<table>")
+	require.NotContains(t, strict, "<h1")
+
+	html := pageRenderer.Render(`<p onmouseover="x()">hi</p><script>x()</script>`, pb.ContentType_HTML, pb.PageTrust_STANDARD)
+	require.Equal(t, `<p>hi</p>`, html)
+
+	trusted := pageRenderer.Render(`<script>x()</script>`, pb.ContentType_HTML, pb.PageTrust_TRUSTED)
+	require.Equal(t, `<script>x()</script>`, trusted)
+
+}
+
+func TestPageTrust(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	pageService := service.PageService()
+
+	ctx, err := glue.New(log, hostStore, pageService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	publishedTrust := func() pb.PageTrust {
+		page, rev, err := pageService.GetPublishedPage(bg, "home")
+		require.NoError(t, err)
+		return service.RenderTrust(page, rev)
+	}
+
+	revisionTrust := func(revision int32) pb.PageTrust {
+		page, err := pageService.GetPage(bg, "home")
+		require.NoError(t, err)
+		rev, err := pageService.GetRevision(bg, "home", revision)
+		require.NoError(t, err)
+		return service.RenderTrust(page, rev)
+	}
+
+	// alice holds pages.trust, bob does not
+	err = pageService.CreatePage(bg, &pb.AdminPage{Name: "home", Title: "Home", Content: "<script>a()</script>", ContentType: "HTML"}, "u1", "alice", true)
+	require.NoError(t, err)
+
+	err = pageService.SetPageTrust(bg, "home", pb.PageTrust_TRUSTED)
+	require.NoError(t, err)
+
+	_, err = pageService.SetPageState(bg, "home", pb.PageState_PUBLISHED)
+	require.NoError(t, err)
+	require.Equal(t, pb.PageTrust_TRUSTED, publishedTrust())
+
+	// promoting the page again does not trust the revision of bob on publish
+	err = pageService.UpdatePage(bg, &pb.AdminPage{Name: "home", Title: "Home", Content: "<script>b()</script>", ContentType: "HTML"}, "u2", "bob", false)
+	require.NoError(t, err)
+
+	err = pageService.SetPageTrust(bg, "home", pb.PageTrust_TRUSTED)
+	require.NoError(t, err)
+
+	_, err = pageService.SetPageState(bg, "home", pb.PageState_PUBLISHED)
+	require.NoError(t, err)
+	require.Equal(t, pb.PageTrust_STANDARD, publishedTrust())
+
+	// preview renders every revision with the level of its author
+	require.Equal(t, pb.PageTrust_TRUSTED, revisionTrust(1))
+	require.Equal(t, pb.PageTrust_STANDARD, revisionTrust(2))
+
+	// rollback keeps the lower of the levels of the source revision and the author
+	revision, err := pageService.RollbackPage(bg, "home", 1, "u2", "bob", "", false)
+	require.NoError(t, err)
+
+	err = pageService.SetPageTrust(bg, "home", pb.PageTrust_TRUSTED)
+	require.NoError(t, err)
+	require.Equal(t, pb.PageTrust_STANDARD, revisionTrust(revision))
+
+	revision, err = pageService.RollbackPage(bg, "home", 2, "u1", "alice", "", true)
+	require.NoError(t, err)
+	require.Equal(t, pb.PageTrust_STANDARD, revisionTrust(revision))
+
+	revision, err = pageService.RollbackPage(bg, "home", 1, "u1", "alice", "", true)
+	require.NoError(t, err)
+	require.Equal(t, pb.PageTrust_TRUSTED, revisionTrust(revision))
+
+	_, err = pageService.SetPageState(bg, "home", pb.PageState_PUBLISHED)
+	require.NoError(t, err)
+	require.Equal(t, pb.PageTrust_TRUSTED, publishedTrust())
+
+	// the stricter page level wins over the trusted revision
+	err = pageService.SetPageTrust(bg, "home", pb.PageTrust_STRICT)
+	require.NoError(t, err)
+	require.Equal(t, pb.PageTrust_STRICT, publishedTrust())
+
+}
+
+func TestPageCache(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	pageService := service.PageService()
+	pageScheduler := service.PageScheduler()
+	pageCache := service.PageCache()
+
+	ctx, err := glue.New(log, hostStore, pageService, pageScheduler, pageCache)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	renders := 0
+	load := func() (*pb.PageContent, string) {
+		page, rev, err := pageService.GetPublishedPage(bg, "faq")
+		require.NoError(t, err)
+		content, etag, _ := pageCache.Load(page, rev, func() *pb.PageContent {
+			renders++
+			return &pb.PageContent{Title: rev.Title, Content: page.Trust.String() + ":" + rev.Content}
+		})
+		return content, etag
+	}
+
+	stats := func() map[string]string {
+		m := make(map[string]string)
+		err := pageCache.GetStats(func(name, value string) bool {
+			m[name] = value
+			return true
+		})
+		require.NoError(t, err)
+		return m
+	}
+
+	err = pageService.CreatePage(bg, &pb.AdminPage{Name: "faq", Title: "FAQ", Content: "v1", ContentType: "HTML"}, "u1", "alice", false)
+	require.NoError(t, err)
+
+	_, err = pageService.SetPageState(bg, "faq", pb.PageState_PUBLISHED)
+	require.NoError(t, err)
+
+	content, etag := load()
+	require.Equal(t, "STANDARD:v1", content.Content)
+	require.NotEmpty(t, etag)
+
+	_, cached := load()
+	require.Equal(t, etag, cached)
+	require.Equal(t, 1, renders)
+	require.Equal(t, "1", stats()["page-cache.size"])
+
+	// the trust level changes the output
+	err = pageService.SetPageTrust(bg, "faq", pb.PageTrust_STRICT)
+	require.NoError(t, err)
+	require.Equal(t, "0", stats()["page-cache.size"])
+
+	content, cached = load()
+	require.Equal(t, "STRICT:v1", content.Content)
+	require.NotEqual(t, etag, cached)
+	require.Equal(t, 2, renders)
+	etag = cached
+
+	// Last-Modified follows the trust change, not only the publishing
+	page, rev, err := pageService.GetPublishedPage(bg, "faq")
+	require.NoError(t, err)
+	require.NotEqual(t, int64(0), page.TrustTimestamp)
+	require.True(t, page.TrustTimestamp >= page.PubTimestamp)
+	_, _, modified := pageCache.Load(page, rev, nil)
+	require.Equal(t, page.TrustTimestamp, modified)
+
+	// the draft does not change the published content
+	err = pageService.UpdatePage(bg, &pb.AdminPage{Name: "faq", Title: "FAQ", Content: "v2", ContentType: "HTML"}, "u1", "alice", false)
+	require.NoError(t, err)
+
+	_, cached = load()
+	require.Equal(t, etag, cached)
+
+	now := time.Now()
+	err = pageService.SchedulePage(bg, "faq", now.Unix() + 60, 0)
+	require.NoError(t, err)
+
+	_, _ = load()
+	require.Equal(t, "1", stats()["page-cache.size"])
+
+	cnt, err := pageScheduler.ApplySchedule(bg, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, cnt)
+	require.Equal(t, "0", stats()["page-cache.size"])
+
+	content, _ = load()
+	require.Equal(t, "STRICT:v2", content.Content)
+
+	// the key does not depend on the invalidation, the other revision is a miss
+	page, rev, err = pageService.GetPublishedPage(bg, "faq")
+	require.NoError(t, err)
+	rev.Revision--
+	content, _, _ = pageCache.Load(page, rev, func() *pb.PageContent {
+		return &pb.PageContent{Content: "old"}
+	})
+	require.Equal(t, "old", content.Content)
+
+	m := stats()
+	require.Equal(t, "1", m["page-cache.hit"])
+	require.Equal(t, "6", m["page-cache.miss"])
+	require.Equal(t, "1", m["page-cache.size"])
+
+}
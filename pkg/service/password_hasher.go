@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sprintframework/template/pkg/api"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+/**
+Supported hash formats:
+
+	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>   argon2id of HMAC-SHA256(pepper, password), PHC string format
+	$bcrypt-sha256$<bcrypt hash>                    bcrypt of base64 HMAC-SHA256(pepper, password), no 72 bytes truncation
+	$2a$<cost>$...                                  legacy bcrypt of pepper+password, always rehashed
+
+The pepper is the user-service.salt-key, it never goes to the store.
+ */
+
+const (
+	PasswordAlgorithmArgon2id     = "argon2id"
+	PasswordAlgorithmBcryptSha256 = "bcrypt-sha256"
+
+	argon2idPrefix     = "$argon2id$"
+	bcryptSha256Prefix = "$bcrypt-sha256$"
+
+	argon2SaltSize = 16
+	argon2KeySize  = 32
+)
+
+type implPasswordHasher struct {
+	pepper        string
+	algorithm     string
+	argon2Time    uint32
+	argon2Memory  uint32 // KiB
+	argon2Threads uint8
+	bcryptCost    int
+}
+
+func newPasswordHasher(pepper, algorithm string, argon2Time, argon2Memory, argon2Threads, bcryptCost int) (api.PasswordHasher, error) {
+
+	switch algorithm {
+	case PasswordAlgorithmArgon2id, PasswordAlgorithmBcryptSha256:
+	default:
+		return nil, errors.Errorf("unknown password algorithm '%s'", algorithm)
+	}
+
+	if argon2Time <= 0 || argon2Memory <= 0 || argon2Threads <= 0 || argon2Threads > 255 {
+		return nil, errors.Errorf("invalid argon2 parameters t=%d, m=%d, p=%d", argon2Time, argon2Memory, argon2Threads)
+	}
+
+	if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+		return nil, errors.Errorf("invalid bcrypt cost %d", bcryptCost)
+	}
+
+	return &implPasswordHasher{
+		pepper:        pepper,
+		algorithm:     algorithm,
+		argon2Time:    uint32(argon2Time),
+		argon2Memory:  uint32(argon2Memory),
+		argon2Threads: uint8(argon2Threads),
+		bcryptCost:    bcryptCost,
+	}, nil
+}
+
+func (t *implPasswordHasher) HashPassword(password string) ([]byte, error) {
+
+	switch t.algorithm {
+	case PasswordAlgorithmArgon2id:
+		salt := make([]byte, argon2SaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		key := argon2.IDKey(t.peppered(password), salt, t.argon2Time, t.argon2Memory, t.argon2Threads, argon2KeySize)
+		return []byte(fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, t.argon2Memory, t.argon2Time, t.argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))), nil
+
+	case PasswordAlgorithmBcryptSha256:
+		hash, err := bcrypt.GenerateFromPassword(t.pepperedBase64(password), t.bcryptCost)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(bcryptSha256Prefix), hash...), nil
+
+	default:
+		return nil, errors.Errorf("unknown password algorithm '%s'", t.algorithm)
+	}
+}
+
+func (t *implPasswordHasher) VerifyPassword(hash []byte, password string) (ok bool, needsRehash bool, err error) {
+
+	switch {
+	case len(hash) == 0:
+		// user without password
+		return false, false, nil
+
+	case bytes.HasPrefix(hash, []byte(argon2idPrefix)):
+		return t.verifyArgon2id(string(hash), password)
+
+	case bytes.HasPrefix(hash, []byte(bcryptSha256Prefix)):
+		bcryptHash := hash[len(bcryptSha256Prefix):]
+		if bcrypt.CompareHashAndPassword(bcryptHash, t.pepperedBase64(password)) != nil {
+			return false, false, nil
+		}
+		cost, err := bcrypt.Cost(bcryptHash)
+		if err != nil {
+			return false, false, err
+		}
+		return true, t.algorithm != PasswordAlgorithmBcryptSha256 || cost != t.bcryptCost, nil
+
+	case bytes.HasPrefix(hash, []byte("$2")):
+		// legacy format, input truncated by bcrypt after 72 bytes
+		if bcrypt.CompareHashAndPassword(hash, []byte(t.pepper+password)) != nil {
+			return false, false, nil
+		}
+		return true, true, nil
+
+	default:
+		return false, false, errors.New("unknown password hash format")
+	}
+}
+
+func (t *implPasswordHasher) verifyArgon2id(hash string, password string) (bool, bool, error) {
+
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, false, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, false, errors.Errorf("invalid argon2id version, %v", err)
+	}
+	if version != argon2.Version {
+		return false, false, errors.Errorf("unsupported argon2id version %d", version)
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, false, errors.Errorf("invalid argon2id parameters, %v", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, errors.Errorf("invalid argon2id salt, %v", err)
+	}
+
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, errors.Errorf("invalid argon2id key, %v", err)
+	}
+
+	actual := argon2.IDKey(t.peppered(password), salt, time, memory, threads, uint32(len(expected)))
+	if subtle.ConstantTimeCompare(expected, actual) != 1 {
+		return false, false, nil
+	}
+
+	needsRehash := t.algorithm != PasswordAlgorithmArgon2id || memory != t.argon2Memory || time != t.argon2Time || threads != t.argon2Threads
+	return true, needsRehash, nil
+}
+
+func (t *implPasswordHasher) peppered(password string) []byte {
+	mac := hmac.New(sha256.New, []byte(t.pepper))
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+func (t *implPasswordHasher) pepperedBase64(password string) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(t.peppered(password)))
+}
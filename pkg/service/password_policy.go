@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sprintframework/sprint"
+	"github.com/sprintframework/template/pkg/api"
+	"go.uber.org/zap"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	breachedPrefixLength = 5
+	minIdentityLength    = 3
+)
+
+type implPasswordPolicy struct {
+	Log             *zap.Logger            `inject`
+	ResourceService sprint.ResourceService `inject`
+
+	MinLength      int    `value:"password-policy.min-length,default=10"`
+	MinClasses     int    `value:"password-policy.min-classes,default=2"`  // of lower, upper, digit and symbol
+	RejectIdentity bool   `value:"password-policy.reject-identity,default=true"`
+	BreachedCheck  bool   `value:"password-policy.breached-check,default=true"`
+	BreachedFile   string `value:"password-policy.breached-file,default=resources:breached/passwords.txt"`
+
+	breached map[string][]string // key is the SHA-1 prefix, value is sorted list of suffixes
+}
+
+func PasswordPolicy() api.PasswordPolicy {
+	return &implPasswordPolicy{}
+}
+
+func (t *implPasswordPolicy) PostConstruct() error {
+
+	if !t.BreachedCheck {
+		return nil
+	}
+
+	content, err := t.ResourceService.GetResource(t.BreachedFile)
+	if err != nil {
+		return errors.Errorf("load breached passwords '%s', %v", t.BreachedFile, err)
+	}
+
+	t.breached = make(map[string][]string)
+	cnt := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.ToUpper(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if len(line) != sha1.Size * 2 {
+			return errors.Errorf("invalid SHA-1 hash '%s' in '%s'", line, t.BreachedFile)
+		}
+		prefix, suffix := line[:breachedPrefixLength], line[breachedPrefixLength:]
+		t.breached[prefix] = append(t.breached[prefix], suffix)
+		cnt++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, list := range t.breached {
+		sort.Strings(list)
+	}
+
+	t.Log.Info("BreachedPasswords", zap.Int("loaded", cnt))
+	return nil
+}
+
+func (t *implPasswordPolicy) Validate(password string, identities ...string) []string {
+
+	var violations []string
+
+	if len([]rune(password)) < t.MinLength {
+		violations = append(violations, fmt.Sprintf("password must be at least %d characters long", t.MinLength))
+	}
+
+	if classes := countClasses(password); classes < t.MinClasses {
+		violations = append(violations, fmt.Sprintf("password must contain at least %d of lowercase letters, uppercase letters, digits and symbols", t.MinClasses))
+	}
+
+	if t.RejectIdentity && containsIdentity(password, identities) {
+		violations = append(violations, "password must not contain the username or email")
+	}
+
+	if t.BreachedCheck && t.isBreached(password) {
+		violations = append(violations, "password is known from data breaches, please choose another one")
+	}
+
+	return violations
+}
+
+func (t *implPasswordPolicy) isBreached(password string) bool {
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	list := t.breached[hash[:breachedPrefixLength]]
+	suffix := hash[breachedPrefixLength:]
+
+	i := sort.SearchStrings(list, suffix)
+	return i < len(list) && list[i] == suffix
+}
+
+func countClasses(password string) int {
+
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+
+	return lower + upper + digit + symbol
+}
+
+func containsIdentity(password string, identities []string) bool {
+
+	lower := strings.ToLower(password)
+	for _, identity := range identities {
+		identity = strings.ToLower(strings.TrimSpace(identity))
+		if i := strings.IndexByte(identity, '@'); i >= 0 {
+			// only the local part of the email
+			identity = identity[:i]
+		}
+		if len(identity) >= minIdentityLength && strings.Contains(lower, identity) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service_test
+
+import (
+	"github.com/codeallergy/glue"
+	"github.com/sprintframework/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	htmlTemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	textTemplate "text/template"
+)
+
+type testResourceService struct {
+}
+
+func (t *testResourceService) GetResource(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join("..", "..", "resources", strings.TrimPrefix(name, "resources:")))
+}
+
+func (t *testResourceService) TextTemplate(name string) (*textTemplate.Template, error) {
+	panic("not supported")
+}
+
+func (t *testResourceService) HtmlTemplate(name string) (*htmlTemplate.Template, error) {
+	panic("not supported")
+}
+
+func (t *testResourceService) GetLicenses(name string) (string, error) {
+	panic("not supported")
+}
+
+func (t *testResourceService) GetOpenAPI(source string) string {
+	panic("not supported")
+}
+
+func TestPasswordPolicy(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	passwordPolicy := service.PasswordPolicy()
+
+	ctx, err := glue.New(log, &testResourceService{}, passwordPolicy)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	require.Empty(t, passwordPolicy.Validate("correct-Horse-battery", "tester", "tester@test.com"))
+
+	require.Len(t, passwordPolicy.Validate("short1"), 1)
+	require.Len(t, passwordPolicy.Validate("onlylowercaseletters"), 1)
+	require.Len(t, passwordPolicy.Validate("my-tester-password", "tester"), 1)
+	require.Len(t, passwordPolicy.Validate("my-mailbox-password", "mailbox@test.com"), 1)
+
+	// from the breached list
+	require.Len(t, passwordPolicy.Validate("password1234"), 1)
+	require.Len(t, passwordPolicy.Validate("qwerty"), 3)
+
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"context"
+	"github.com/keyvalstore/store"
+	"github.com/pkg/errors"
+	"github.com/sprintframework/template/pkg/api"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/utils"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"time"
+)
+
+type implRoleService struct {
+	Log                  *zap.Logger                `inject`
+	UserService          api.UserService            `inject`
+	HostStore            store.DataStore            `inject:"bean=host-store"`
+	TransactionalManager store.TransactionalManager `inject:"bean=host-store"`
+}
+
+func RoleService() api.RoleService {
+	return &implRoleService{}
+}
+
+func adminRole() *pb.RoleEntity {
+	return &pb.RoleEntity{
+		Name:        AdminRoleName,
+		Description: "Built-in role with all permissions",
+		Permissions: append([]string(nil), Permissions...),
+	}
+}
+
+func (t *implRoleService) GetRole(ctx context.Context, name string) (*pb.RoleEntity, error) {
+
+	name = utils.NormalizeLowerUnreservedCharacters(name)
+	if name == "" {
+		return nil, ErrRoleNotFound
+	}
+
+	if name == AdminRoleName {
+		return adminRole(), nil
+	}
+
+	role := new(pb.RoleEntity)
+	err := t.HostStore.Get(ctx).ByKey("role:%s", name).ToProto(role)
+	if err != nil {
+		return nil, err
+	}
+	if role.Name != name {
+		return nil, ErrRoleNotFound
+	}
+	return role, nil
+}
+
+func (t *implRoleService) SaveRole(ctx context.Context, role *pb.RoleEntity) (err error) {
+
+	role.Name = utils.NormalizeLowerUnreservedCharacters(role.Name)
+	if role.Name == "" {
+		return errors.New("role name is empty")
+	}
+
+	if role.Name == AdminRoleName {
+		return ErrRoleBuiltIn
+	}
+
+	known := make(map[string]bool)
+	for _, p := range Permissions {
+		known[p] = true
+	}
+
+	var permissions []string
+	seen := make(map[string]bool)
+	for _, p := range role.Permissions {
+		if !known[p] {
+			return errors.Wrapf(ErrUnknownPermission, "'%s'", p)
+		}
+		if !seen[p] {
+			seen[p] = true
+			permissions = append(permissions, p)
+		}
+	}
+	role.Permissions = permissions
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	existing, err := t.GetRole(ctx, role.Name)
+	if err == nil {
+		role.CreTimestamp = existing.CreTimestamp
+	} else if err == ErrRoleNotFound {
+		role.CreTimestamp = time.Now().Unix()
+	} else {
+		return err
+	}
+
+	return t.HostStore.Set(ctx).ByKey("role:%s", role.Name).Proto(role)
+}
+
+func (t *implRoleService) RemoveRole(ctx context.Context, name string) (err error) {
+
+	name = utils.NormalizeLowerUnreservedCharacters(name)
+	if name == AdminRoleName {
+		return ErrRoleBuiltIn
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	_, err = t.GetRole(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	var holders []string
+	err = t.UserService.EnumUsers(ctx, func(user *pb.UserEntity) bool {
+		for _, r := range user.Roles {
+			if r == name {
+				holders = append(holders, user.UserId)
+				break
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	// a new role with the same name must not grant anything to the old holders
+	for _, userId := range holders {
+		err = t.UserService.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+			user.Roles = removeString(user.Roles, name)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.HostStore.Remove(ctx).ByKey("role:%s", name).Do()
+}
+
+func (t *implRoleService) EnumRoles(ctx context.Context, cb func(role *pb.RoleEntity) bool) error {
+
+	if !cb(adminRole()) {
+		return nil
+	}
+
+	return t.HostStore.Enumerate(ctx).
+		ByPrefix("role:").
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.RoleEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.RoleEntity); ok {
+				return cb(v)
+			}
+			return true
+		})
+
+}
+
+func (t *implRoleService) GetPermissions(ctx context.Context, user *pb.UserEntity) (map[string]bool, error) {
+
+	permissions := make(map[string]bool)
+
+	roles := user.Roles
+	if user.Role == pb.UserRole_ADMIN {
+		roles = append([]string{AdminRoleName}, roles...)
+	}
+
+	for _, name := range roles {
+		role, err := t.GetRole(ctx, name)
+		if err == ErrRoleNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range role.Permissions {
+			permissions[p] = true
+		}
+	}
+
+	return permissions, nil
+}
+
+func removeString(list []string, value string) []string {
+	var out []string
+	for _, s := range list {
+		if s != value {
+			out = append(out, s)
+		}
+	}
+	return out
+}
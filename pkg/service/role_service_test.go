@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service_test
+
+import (
+	"context"
+	"github.com/codeallergy/glue"
+	"github.com/keyvalstore/badgerstore"
+	"github.com/pkg/errors"
+	"github.com/sprintframework/sprintframework/sprintcore"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-store", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	userService := service.UserService()
+	roleService := service.RoleService()
+
+	ctx, err := glue.New(log, configStore, sprintcore.ConfigRepository(1000), hostStore, userService, roleService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	err = roleService.SaveRole(bg, &pb.RoleEntity{Name: service.AdminRoleName})
+	require.Equal(t, service.ErrRoleBuiltIn, err)
+
+	err = roleService.SaveRole(bg, &pb.RoleEntity{Name: "editor", Permissions: []string{"pages.fly"}})
+	require.Equal(t, service.ErrUnknownPermission, errors.Cause(err))
+
+	err = roleService.SaveRole(bg, &pb.RoleEntity{Name: "editor", Permissions: []string{service.PermissionPagesRead, service.PermissionPagesWrite}})
+	require.NoError(t, err)
+
+	var names []string
+	err = roleService.EnumRoles(bg, func(role *pb.RoleEntity) bool {
+		names = append(names, role.Name)
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{service.AdminRoleName, "editor"}, names)
+
+	user, err := userService.CreateUser(bg, &pb.RegisterRequest{
+		Username: "editor",
+		Email:    "editor@test.com",
+		Password: "test",
+	})
+	require.NoError(t, err)
+
+	err = userService.DoWithUser(bg, user.UserId, func(user *pb.UserEntity) error {
+		user.Role = pb.UserRole_USER
+		user.Roles = []string{"editor"}
+		return nil
+	})
+	require.NoError(t, err)
+
+	user, err = userService.GetUser(bg, user.UserId)
+	require.NoError(t, err)
+
+	permissions, err := roleService.GetPermissions(bg, user)
+	require.NoError(t, err)
+	require.True(t, permissions[service.PermissionPagesWrite])
+	require.False(t, permissions[service.PermissionUsersRead])
+
+	// removed role must be stripped from the holders
+	err = roleService.RemoveRole(bg, "editor")
+	require.NoError(t, err)
+
+	user, err = userService.GetUser(bg, user.UserId)
+	require.NoError(t, err)
+	require.Empty(t, user.Roles)
+
+	_, err = roleService.GetRole(bg, "editor")
+	require.Equal(t, service.ErrRoleNotFound, err)
+
+	permissions, err = roleService.GetPermissions(bg, &pb.UserEntity{Role: pb.UserRole_ADMIN})
+	require.NoError(t, err)
+	require.Len(t, permissions, len(service.Permissions))
+
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github.com/keyvalstore/store"
+	"github.com/sprintframework/template/pkg/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	scanDefaultLimit = 20
+	scanMaxLimit     = 100
+	scanCountLimit   = 10000 // cap of the counted total, the total is the lower bound above it
+)
+
+/**
+List calls page through the keys of the prefix. The cursor is the opaque token with the last returned key
+and its position, the next page seeks right after the key, so the cost does not depend on the page number.
+The offset is still supported for old clients, the skipped keys are enumerated without loading the values
+and the page starts right after the last skipped one, the same way as with the cursor.
+ */
+
+func scanPrefix(ctx context.Context, ds store.DataStore, prefix, cursor string, reverse bool, offset, limit int, cb func(entry *store.RawEntry, position int) bool) (nextCursor string, err error) {
+
+	seek := prefix
+	if reverse {
+		seek = prefix + "\xff"
+	}
+
+	position := 0
+	var cursorKey []byte
+	if cursor != "" {
+		c, err := decodeScanCursor(cursor)
+		if err != nil || !bytes.HasPrefix(c.Key, []byte(prefix)) {
+			return "", ErrInvalidCursor
+		}
+		cursorKey = c.Key
+		seek = string(c.Key)
+		position = int(c.Position)
+		offset = 0
+	}
+
+	if offset > 0 {
+		skipped := 0
+		var skippedKey []byte
+		op := ds.Enumerate(ctx).
+			ByPrefix(prefix).
+			Seek(seek).
+			OnlyKeys().
+			WithBatchSize(BatchSize)
+		if reverse {
+			op = op.Reverse()
+		}
+		err = op.Do(func(entry *store.RawEntry) bool {
+			skippedKey = append(skippedKey[:0], entry.Key...)
+			skipped++
+			return skipped < offset
+		})
+		if err != nil {
+			return "", err
+		}
+		if skipped < offset {
+			// the offset is past the end of the list
+			return "", nil
+		}
+		cursorKey = skippedKey
+		seek = string(skippedKey)
+		position = offset
+	}
+
+	if limit <= 0 {
+		limit = scanDefaultLimit
+	}
+	if limit > scanMaxLimit {
+		limit = scanMaxLimit
+	}
+
+	op := ds.Enumerate(ctx).
+		ByPrefix(prefix).
+		Seek(seek).
+		WithBatchSize(BatchSize)
+	if reverse {
+		op = op.Reverse()
+	}
+
+	var lastKey []byte
+	count := 0
+	more := false
+	err = op.Do(func(entry *store.RawEntry) bool {
+
+		if cursorKey != nil && bytes.Equal(entry.Key, cursorKey) {
+			return true
+		}
+		if count == limit {
+			more = true
+			return false
+		}
+		if cb(entry, position + 1) {
+			position++
+			count++
+			lastKey = append(lastKey[:0], entry.Key...)
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if more && lastKey != nil {
+		return encodeScanCursor(&pb.ScanCursor{Key: lastKey, Position: int32(position)})
+	}
+	return "", nil
+}
+
+/**
+Counts the keys of the prefix without loading the values, stops at the count limit. The total is capped and
+estimated is true if the limit was reached, keys of broken or expiring entities are counted as well.
+ */
+func estimatePrefix(ctx context.Context, ds store.DataStore, prefix string) (total int, estimated bool, err error) {
+
+	err = ds.Enumerate(ctx).
+		ByPrefix(prefix).
+		OnlyKeys().
+		WithBatchSize(BatchSize).
+		Do(func(entry *store.RawEntry) bool {
+			if total == scanCountLimit {
+				estimated = true
+				return false
+			}
+			total++
+			return true
+		})
+
+	return total, estimated, err
+}
+
+func encodeScanCursor(c *pb.ScanCursor) (string, error) {
+	data, err := proto.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeScanCursor(cursor string) (*pb.ScanCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	c := new(pb.ScanCursor)
+	if err := proto.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if len(c.Key) == 0 || c.Position < 0 {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
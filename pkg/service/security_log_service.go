@@ -7,6 +7,7 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"github.com/pkg/errors"
 	"github.com/keyvalstore/store"
 	"github.com/sprintframework/template/pkg/api"
@@ -36,16 +37,52 @@ func SecurityLogService() api.SecurityLogService {
 
 func (t *implSecurityLogService) LogEvent(ctx context.Context, userId, eventName, remoteIP, userAgent string) (err error) {
 
-	userId = utils.NormalizeUserId(userId)
-	if userId == "" {
-		return errors.New("userId is empty")
-	}
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	return t.doLogEvent(ctx, userId, &pb.SecurityLogEntity{
+		EventName: eventName,
+		RemoteIp:  remoteIP,
+		UserAgent: userAgent,
+	})
+}
+
+func (t *implSecurityLogService) LogImpersonation(ctx context.Context, adminId, userId, details, remoteIP, userAgent string) (err error) {
 
 	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
 	defer func() {
 		err = t.TransactionalManager.EndTransaction(ctx, err)
 	}()
 
+	err = t.doLogEvent(ctx, userId, &pb.SecurityLogEntity{
+		EventName: "Impersonated",
+		RemoteIp:  remoteIP,
+		UserAgent: userAgent,
+		Actor:     adminId,
+		Details:   details,
+	})
+	if err != nil {
+		return err
+	}
+
+	return t.doLogEvent(ctx, adminId, &pb.SecurityLogEntity{
+		EventName: "Impersonating",
+		RemoteIp:  remoteIP,
+		UserAgent: userAgent,
+		Actor:     userId,
+		Details:   details,
+	})
+}
+
+func (t *implSecurityLogService) doLogEvent(ctx context.Context, userId string, event *pb.SecurityLogEntity) (err error) {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return errors.New("userId is empty")
+	}
+
 	current := time.Now()
 tryAgain:
 	utc := current.UTC()
@@ -58,12 +95,7 @@ tryAgain:
 		goto tryAgain
 	}
 
-	event := &pb.SecurityLogEntity{
-		EventName: eventName,
-		EventTime: current.Unix(),
-		RemoteIp:  remoteIP,
-		UserAgent: userAgent,
-	}
+	event.EventTime = current.Unix()
 
 	err = t.HostStorage.Set(ctx).ByKey("%s:user:security-log:%s", userId, utc.Format(DDMMYYYYhhmmss)).WithTtl(t.LogTtl).Proto(event)
 	return
@@ -98,3 +130,34 @@ func (t *implSecurityLogService) EnumEvents(ctx context.Context, userId string,
 
 }
 
+
+func (t *implSecurityLogService) ScanEvents(ctx context.Context, userId, cursor string, reverse bool, offset, limit int, cb func(item *pb.SecurityLogEntity, position int)) (string, error) {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return "", errors.New("userId is empty")
+	}
+
+	// keys are ordered by time, the newest first is the reverse order of the store
+	prefix := fmt.Sprintf("%s:user:security-log:", userId)
+	return scanPrefix(ctx, t.HostStorage, prefix, cursor, !reverse, offset, limit, func(entry *store.RawEntry, position int) bool {
+		event := new(pb.SecurityLogEntity)
+		if err := proto.Unmarshal(entry.Value, event); err != nil {
+			t.Log.Warn("ScanEvents", zap.String("key", string(entry.Key)), zap.Error(err))
+			return false
+		}
+		cb(event, position)
+		return true
+	})
+
+}
+
+func (t *implSecurityLogService) EstimateEvents(ctx context.Context, userId string) (int, bool, error) {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return 0, false, errors.New("userId is empty")
+	}
+
+	return estimatePrefix(ctx, t.HostStorage, fmt.Sprintf("%s:user:security-log:", userId))
+}
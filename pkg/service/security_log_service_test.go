@@ -6,8 +6,14 @@
 package service_test
 
 import (
+	"context"
+	"github.com/codeallergy/glue"
+	"github.com/keyvalstore/badgerstore"
 	"github.com/stretchr/testify/require"
+	"github.com/sprintframework/template/pkg/pb"
 	"github.com/sprintframework/template/pkg/service"
+	"go.uber.org/zap"
+	"os"
 	"sync"
 	"testing"
 	"time"
@@ -32,6 +38,134 @@ func TestDateFormatter(t *testing.T) {
 
 }
 
+func TestImpersonationLog(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	securityLogService := service.SecurityLogService()
+
+	ctx, err := glue.New(log, hostStore, securityLogService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	err = securityLogService.LogImpersonation(bg, "admin", "u1", "/lighttemplate.AuthService/User", "127.0.0.1", "test")
+	require.NoError(t, err)
+
+	var events []*pb.SecurityLogEntity
+	err = securityLogService.EnumEvents(bg, "u1", func(item *pb.SecurityLogEntity) bool {
+		events = append(events, item)
+		return true
+	})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "Impersonated", events[0].EventName)
+	require.Equal(t, "admin", events[0].Actor)
+	require.Equal(t, "/lighttemplate.AuthService/User", events[0].Details)
+
+	events = nil
+	err = securityLogService.EnumEvents(bg, "admin", func(item *pb.SecurityLogEntity) bool {
+		events = append(events, item)
+		return true
+	})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "Impersonating", events[0].EventName)
+	require.Equal(t, "u1", events[0].Actor)
+
+}
+
+func TestSecurityLogScan(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	securityLogService := service.SecurityLogService()
+
+	ctx, err := glue.New(log, hostStore, securityLogService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	names := []string{"e1", "e2", "e3", "e4", "e5"}
+	for _, name := range names {
+		err = securityLogService.LogEvent(bg, "u1", name, "127.0.0.1", "test")
+		require.NoError(t, err)
+	}
+
+	total, estimated, err := securityLogService.EstimateEvents(bg, "u1")
+	require.NoError(t, err)
+	require.Equal(t, 5, total)
+	require.False(t, estimated)
+
+	scan := func(cursor string, reverse bool, offset, limit int) ([]string, []int, string) {
+		var list []string
+		var positions []int
+		next, err := securityLogService.ScanEvents(bg, "u1", cursor, reverse, offset, limit, func(item *pb.SecurityLogEntity, position int) {
+			list = append(list, item.EventName)
+			positions = append(positions, position)
+		})
+		require.NoError(t, err)
+		return list, positions, next
+	}
+
+	// newest first
+	list, positions, cursor := scan("", false, 0, 2)
+	require.Equal(t, []string{"e5", "e4"}, list)
+	require.Equal(t, []int{1, 2}, positions)
+	require.NotEmpty(t, cursor)
+
+	list, positions, cursor = scan(cursor, false, 0, 2)
+	require.Equal(t, []string{"e3", "e2"}, list)
+	require.Equal(t, []int{3, 4}, positions)
+
+	list, _, next := scan(cursor, false, 0, 2)
+	require.Equal(t, []string{"e1"}, list)
+	require.Empty(t, next)
+
+	// the same cursor in the other direction goes back
+	list, _, _ = scan(cursor, true, 0, 2)
+	require.Equal(t, []string{"e3", "e4"}, list)
+
+	list, _, _ = scan("", true, 0, 3)
+	require.Equal(t, []string{"e1", "e2", "e3"}, list)
+
+	// offset of old clients
+	list, positions, _ = scan("", false, 3, 10)
+	require.Equal(t, []string{"e2", "e1"}, list)
+	require.Equal(t, []int{4, 5}, positions)
+
+	list, _, next = scan("", false, 7, 10)
+	require.Empty(t, list)
+	require.Empty(t, next)
+
+	_, err = securityLogService.ScanEvents(bg, "u2", cursor, false, 0, 2, func(item *pb.SecurityLogEntity, position int) {})
+	require.Equal(t, service.ErrInvalidCursor, err)
+
+	_, err = securityLogService.ScanEvents(bg, "u1", "garbage", false, 0, 2, func(item *pb.SecurityLogEntity, position int) {})
+	require.Equal(t, service.ErrInvalidCursor, err)
+
+}
+
 type eventList struct {
 	eventMap sync.Map
 }
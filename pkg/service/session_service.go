@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"context"
+	"github.com/keyvalstore/store"
+	"github.com/pkg/errors"
+	"github.com/sprintframework/template/pkg/api"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/utils"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"time"
+)
+
+const (
+	sessionIdLength = 20
+)
+
+type implSessionService struct {
+	Log                  *zap.Logger                `inject`
+	HostStore            store.DataStore            `inject:"bean=host-store"`
+	TransactionalManager store.TransactionalManager `inject:"bean=host-store"`
+}
+
+func SessionService() api.SessionService {
+	return &implSessionService{}
+}
+
+func (t *implSessionService) CreateSession(ctx context.Context, userId string, session *pb.SessionEntity, ttlSeconds int) (*pb.SessionEntity, error) {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return nil, errors.New("user id is empty")
+	}
+
+	sessionId, err := utils.RandomString(utils.AlphaNumericAlphabet, sessionIdLength)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshId, err := utils.RandomString(utils.AlphaNumericAlphabet, sessionIdLength)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	session.SessionId = sessionId
+	session.RefreshId = refreshId
+	session.CreTimestamp = now
+	session.LastTimestamp = now
+
+	err = t.HostStore.Set(ctx).ByKey("%s:user:session:%s", userId, sessionId).WithTtl(ttlSeconds).Proto(session)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (t *implSessionService) GetSession(ctx context.Context, userId, sessionId string) (*pb.SessionEntity, error) {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return nil, errors.New("user id is empty")
+	}
+
+	sessionId = utils.NormalizeUserId(sessionId)
+	if sessionId == "" {
+		return nil, ErrSessionNotFound
+	}
+
+	session := new(pb.SessionEntity)
+	err := t.HostStore.Get(ctx).ByKey("%s:user:session:%s", userId, sessionId).ToProto(session)
+	if err != nil {
+		return nil, err
+	}
+	if session.SessionId != sessionId {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (t *implSessionService) RotateRefreshToken(ctx context.Context, userId, sessionId, refreshId, remoteIP, userAgent string, ttlSeconds int) (*pb.SessionEntity, error) {
+
+	session, reused, err := t.doRotateRefreshToken(ctx, userId, sessionId, refreshId, remoteIP, userAgent, ttlSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	if reused {
+		// the token was already rotated, somebody else holds a copy of it, revoke the whole family
+		if err = t.RemoveSession(ctx, userId, sessionId); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReuse
+	}
+
+	return session, nil
+}
+
+func (t *implSessionService) doRotateRefreshToken(ctx context.Context, userId, sessionId, refreshId, remoteIP, userAgent string, ttlSeconds int) (session *pb.SessionEntity, reused bool, err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	session, err = t.GetSession(ctx, userId, sessionId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if refreshId == "" || session.RefreshId != refreshId {
+		return nil, true, nil
+	}
+
+	session.RefreshId, err = utils.RandomString(utils.AlphaNumericAlphabet, sessionIdLength)
+	if err != nil {
+		return nil, false, err
+	}
+
+	session.LastTimestamp = time.Now().Unix()
+	if remoteIP != "" {
+		session.RemoteIp = remoteIP
+	}
+	if userAgent != "" {
+		session.UserAgent = userAgent
+	}
+
+	err = t.HostStore.Set(ctx).ByKey("%s:user:session:%s", utils.NormalizeUserId(userId), session.SessionId).WithTtl(ttlSeconds).Proto(session)
+	if err != nil {
+		return nil, false, err
+	}
+	return session, false, nil
+}
+
+func (t *implSessionService) RemoveSession(ctx context.Context, userId, sessionId string) error {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return errors.New("user id is empty")
+	}
+
+	sessionId = utils.NormalizeUserId(sessionId)
+	if sessionId == "" {
+		return errors.New("session id is empty")
+	}
+
+	return t.HostStore.Remove(ctx).ByKey("%s:user:session:%s", userId, sessionId).Do()
+}
+
+func (t *implSessionService) RemoveOtherSessions(ctx context.Context, userId, keepSessionId string) (cnt int, err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	var list []string
+	err = t.EnumSessions(ctx, userId, func(session *pb.SessionEntity) bool {
+		if session.SessionId != keepSessionId {
+			list = append(list, session.SessionId)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, sessionId := range list {
+		if err = t.RemoveSession(ctx, userId, sessionId); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+
+	return cnt, nil
+}
+
+func (t *implSessionService) EnumSessions(ctx context.Context, userId string, cb func(session *pb.SessionEntity) bool) error {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return errors.New("user id is empty")
+	}
+
+	return t.HostStore.Enumerate(ctx).
+		ByPrefix("%s:user:session:", userId).
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.SessionEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.SessionEntity); ok {
+				return cb(v)
+			}
+			return true
+		})
+
+}
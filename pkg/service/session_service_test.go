@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service_test
+
+import (
+	"context"
+	"github.com/codeallergy/glue"
+	"github.com/keyvalstore/badgerstore"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"testing"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	sessionService := service.SessionService()
+
+	ctx, err := glue.New(log, hostStore, sessionService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	session, err := sessionService.CreateSession(bg, "u1", &pb.SessionEntity{Device: "test"}, 3600)
+	require.NoError(t, err)
+	require.NotEmpty(t, session.SessionId)
+	require.NotEmpty(t, session.RefreshId)
+
+	firstId := session.RefreshId
+
+	rotated, err := sessionService.RotateRefreshToken(bg, "u1", session.SessionId, firstId, "127.0.0.1", "", 3600)
+	require.NoError(t, err)
+	require.NotEqual(t, firstId, rotated.RefreshId)
+
+	// replay of the used token revokes the whole family
+	_, err = sessionService.RotateRefreshToken(bg, "u1", session.SessionId, firstId, "127.0.0.2", "", 3600)
+	require.Equal(t, service.ErrRefreshTokenReuse, err)
+
+	_, err = sessionService.RotateRefreshToken(bg, "u1", session.SessionId, rotated.RefreshId, "127.0.0.1", "", 3600)
+	require.Equal(t, service.ErrSessionNotFound, err)
+
+}
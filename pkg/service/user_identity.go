@@ -0,0 +1,200 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/utils"
+	"strings"
+	"time"
+)
+
+const (
+	usernameSuffixLength = 4
+	usernameAttempts     = 10
+)
+
+func identityKey(provider, subject string) string {
+	return fmt.Sprintf("%s:%s", utils.NormalizeField(provider), utils.NormalizeField(subject))
+}
+
+func (t *implUserService) GetUserIdByIdentity(ctx context.Context, provider, subject string) (string, error) {
+
+	if provider == "" || subject == "" {
+		return "", errors.New("empty identity")
+	}
+
+	userId, err := t.HostStore.Get(ctx).ByKey("identity:%s", identityKey(provider, subject)).ToString()
+	if err != nil {
+		return "", err
+	}
+	if userId == "" {
+		return "", ErrUserNotFound
+	}
+	return userId, nil
+}
+
+func (t *implUserService) LinkIdentity(ctx context.Context, userId, provider, subject string) (err error) {
+
+	if provider == "" || subject == "" {
+		return errors.New("empty identity")
+	}
+	key := identityKey(provider, subject)
+
+	// the index and the user are updated in the same transaction
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	return t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+
+		usedUserId, err := t.HostStore.Get(ctx).ByKey("identity:%s", key).ToString()
+		if err != nil {
+			return err
+		}
+		if usedUserId == user.UserId {
+			return nil
+		}
+		if usedUserId != "" {
+			return ErrIdentityAlreadyLinked
+		}
+
+		user.Identities = append(user.Identities, key)
+		return t.HostStore.Set(ctx).ByKey("identity:%s", key).String(user.UserId)
+	})
+
+}
+
+func (t *implUserService) CreateExternalUser(ctx context.Context, identity *pb.ExternalIdentity) (user *pb.UserEntity, err error) {
+
+	email := utils.NormalizeEmail(identity.Email)
+	if email == "" {
+		return nil, errors.New("user email is empty")
+	}
+
+	if identity.Provider == "" || identity.Subject == "" {
+		return nil, errors.New("empty identity")
+	}
+	key := identityKey(identity.Provider, identity.Subject)
+
+	// unverified email of the provider could belong to somebody else, it would take the address before the owner registers
+	if !identity.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	usedUserId, err := t.HostStore.Get(ctx).ByKey("email:%s", email).ToString()
+	if err != nil {
+		return nil, err
+	}
+	if usedUserId != "" {
+		return nil, ErrEmailAlreadyUsed
+	}
+
+	usedUserId, err = t.HostStore.Get(ctx).ByKey("identity:%s", key).ToString()
+	if err != nil {
+		return nil, err
+	}
+	if usedUserId != "" {
+		return nil, ErrIdentityAlreadyLinked
+	}
+
+	username, err := t.pickUsername(ctx, identity.PreferredUsername, email)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := t.GenerateUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	firstName, lastName := identity.Name, ""
+	if i := strings.LastIndexByte(identity.Name, ' '); i > 0 {
+		firstName, lastName = identity.Name[:i], identity.Name[i+1:]
+	}
+
+	user = &pb.UserEntity{
+		UserId:       userId,
+		Username:     username,
+		FirstName:    firstName,
+		LastName:     lastName,
+		Email:        email,
+		CreTimestamp: time.Now().Unix(),
+		Role:         pb.UserRole_USER,
+		Verified:     true,
+		Identities:   []string{key},
+	}
+
+	err = t.HostStore.Set(ctx).ByKey("%s:user", userId).Proto(user)
+	if err != nil {
+		return nil, err
+	}
+
+	// back reference
+	err = t.HostStore.Set(ctx).ByKey("user:%s", userId).String(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.HostStore.Set(ctx).ByKey("username:%s", username).String(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.HostStore.Set(ctx).ByKey("email:%s", email).String(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.HostStore.Set(ctx).ByKey(createdIndexFormat, user.CreTimestamp, userId).String(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.HostStore.Set(ctx).ByKey("identity:%s", key).String(userId)
+	return user, err
+}
+
+/**
+Takes preferred username or the local part of the email, adds random digits if it is already taken.
+ */
+func (t *implUserService) pickUsername(ctx context.Context, preferred, email string) (string, error) {
+
+	base := utils.NormalizeUsername(preferred)
+	if base == "" {
+		base = utils.NormalizeUsername(email[:strings.IndexByte(email + "@", '@')])
+	}
+	for len(base) < minUsernameLength {
+		base += "0"
+	}
+
+	candidate := base
+	for i := 0; i < usernameAttempts; i++ {
+		available, normName, err := t.IsUsernameAvailable(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if available {
+			return normName, nil
+		}
+		suffix, err := utils.RandomString(utils.NumericAlphabet, usernameSuffixLength)
+		if err != nil {
+			return "", err
+		}
+		candidate = base + suffix
+	}
+
+	return "", errors.Errorf("can not pick username for '%s'", email)
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/utils"
+	"time"
+)
+
+func (t *implUserService) ChangePassword(ctx context.Context, userId, currentPassword, newPassword string) (email string, err error) {
+
+	if newPassword == "" {
+		return "", errors.New("new password is empty")
+	}
+
+	err = t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+
+		ok, _, err := t.PasswordHasher.VerifyPassword(user.PasswordHash, currentPassword)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrUserInvalidPassword
+		}
+
+		hashedPassword, err := t.PasswordHasher.HashPassword(newPassword)
+		if err != nil {
+			return err
+		}
+
+		user.PasswordHash = hashedPassword
+		email = user.Email
+		return nil
+	})
+
+	return
+}
+
+func (t *implUserService) ChangeEmail(ctx context.Context, userId, oldEmail, newEmail string) error {
+
+	newEmail = utils.NormalizeEmail(newEmail)
+	if newEmail == "" {
+		return errors.New("new email is empty")
+	}
+
+	return t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+
+		if utils.NormalizeEmail(user.Email) != utils.NormalizeEmail(oldEmail) {
+			// email was changed after the confirmation was requested
+			return ErrInvalidVerifyToken
+		}
+
+		usedUserId, err := t.HostStore.Get(ctx).ByKey("email:%s", newEmail).ToString()
+		if err != nil {
+			return err
+		}
+		if usedUserId != "" {
+			return ErrEmailAlreadyUsed
+		}
+
+		user.Email = newEmail
+		user.Verified = true
+		if user.Status == pb.UserStatus_PENDING_VERIFICATION {
+			user.Status = pb.UserStatus_ACTIVE
+			user.StatusTimestamp = time.Now().Unix()
+		}
+		return nil
+	})
+
+}
+
+func (t *implUserService) UpdateProfile(ctx context.Context, userId string, req *pb.UpdateProfileRequest) (entity *pb.UserEntity, err error) {
+
+	err = t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+
+		if req.Username != "" && utils.NormalizeUsername(req.Username) != user.Username {
+			available, normName, err := t.IsUsernameAvailable(ctx, req.Username)
+			if err != nil {
+				return err
+			}
+			if !available {
+				return ErrUsernameNotAvailable
+			}
+			user.Username = normName
+		}
+
+		user.FirstName = req.FirstName
+		user.MiddleName = req.MiddleName
+		user.LastName = req.LastName
+
+		entity = user
+		return nil
+	})
+
+	return
+}
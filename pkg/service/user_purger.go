@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/sprintframework/sprint"
+	"github.com/sprintframework/template/pkg/api"
+	"github.com/sprintframework/template/pkg/pb"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+/**
+Background job that removes soft deleted users after the grace period.
+ */
+
+type implUserPurger struct {
+	Log         *zap.Logger        `inject`
+	UserService api.UserService    `inject`
+	MailService sprint.MailService `inject:"optional"`
+
+	WebappName      string `value:"webapp.name,default=Light-Template"`
+	MailSender      string `value:"mail.sender,default=noreply@localhost"`
+	GraceDays       int    `value:"user-purger.grace-days,default=30"`
+	IntervalMinutes int    `value:"user-purger.interval-minutes,default=60"` // zero disables the background job
+
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+}
+
+func UserPurger() api.UserPurger {
+	return &implUserPurger{shutdownCh: make(chan struct{})}
+}
+
+func (t *implUserPurger) PostConstruct() error {
+	if t.IntervalMinutes > 0 {
+		go t.run(time.Duration(t.IntervalMinutes) * time.Minute)
+	}
+	return nil
+}
+
+func (t *implUserPurger) Destroy() error {
+	t.shutdownOnce.Do(func() {
+		close(t.shutdownCh)
+	})
+	return nil
+}
+
+func (t *implUserPurger) run(interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.shutdownCh:
+			return
+		case now := <-ticker.C:
+			cnt, err := t.PurgeDeletedUsers(context.Background(), now.Add(-t.gracePeriod()))
+			if err != nil {
+				t.Log.Error("PurgeDeletedUsers", zap.Error(err))
+			} else if cnt > 0 {
+				t.Log.Info("PurgeDeletedUsers", zap.Int("purged", cnt))
+			}
+		}
+	}
+}
+
+func (t *implUserPurger) gracePeriod() time.Duration {
+	return time.Duration(t.GraceDays) * 24 * time.Hour
+}
+
+func (t *implUserPurger) PurgeTime(user *pb.UserEntity) time.Time {
+	return time.Unix(user.StatusTimestamp, 0).Add(t.gracePeriod())
+}
+
+func (t *implUserPurger) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int, error) {
+
+	var list []*pb.UserEntity
+	err := t.UserService.EnumDeletedUsers(ctx, func(user *pb.UserEntity) bool {
+		if user.StatusTimestamp <= deletedBefore.Unix() {
+			list = append(list, user)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// one failed user does not block the purge of the others, it is retried on the next run
+	var cnt int
+	for _, user := range list {
+		purged, err := t.purgeUser(ctx, user.UserId, deletedBefore)
+		if err != nil {
+			t.Log.Error("PurgeUser", zap.String("userId", user.UserId), zap.Error(err))
+			continue
+		}
+		if purged {
+			cnt++
+		}
+	}
+
+	return cnt, nil
+}
+
+func (t *implUserPurger) purgeUser(ctx context.Context, userId string, deletedBefore time.Time) (bool, error) {
+
+	// the user could be restored after the enumeration
+	entity, err := t.UserService.GetUser(ctx, userId)
+	if err == ErrUserNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if entity.Status != pb.UserStatus_DELETED || entity.StatusTimestamp > deletedBefore.Unix() {
+		return false, nil
+	}
+
+	err = t.UserService.RemoveUser(ctx, entity.UserId)
+	if err != nil {
+		return false, err
+	}
+
+	err = t.UserService.DropUserContent(ctx, entity.UserId)
+	if err != nil {
+		return false, err
+	}
+
+	t.sendGoodbyeMail(entity)
+	return true, nil
+}
+
+func (t *implUserPurger) sendGoodbyeMail(user *pb.UserEntity) {
+
+	if t.MailService == nil {
+		return
+	}
+
+	mail := sprint.Mail{
+		Sender:      t.MailSender,
+		Recipients:   []string{user.Email},
+		Subject:      fmt.Sprintf("Goodbye %s.", user.FirstName),
+		TextTemplate: "resources:mail/deleted_user_text.tmpl",
+		HtmlTemplate: "resources:mail/deleted_user_html.tmpl",
+		Data:         map[string]interface{} {
+			"FirstName": user.FirstName,
+			"Project": t.WebappName,
+		},
+	}
+
+	if err := t.MailService.SendMail(&mail, time.Minute, false); err != nil {
+		t.Log.Warn("SendGoodbyeMail", zap.String("userId", user.UserId), zap.Error(err))
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service_test
+
+import (
+	"context"
+	"github.com/codeallergy/glue"
+	"github.com/keyvalstore/badgerstore"
+	"github.com/sprintframework/sprintframework/sprintcore"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUserPurger(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-store", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	userService := service.UserService()
+	userPurger := service.UserPurger()
+
+	ctx, err := glue.New(log, configStore, sprintcore.ConfigRepository(1000), hostStore, userService, userPurger)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	user, err := userService.CreateUser(bg, &pb.RegisterRequest{
+		Username: "purged",
+		Email: "purged@test.com",
+		Password: "test",
+	})
+	require.NoError(t, err)
+	userId := user.UserId
+
+	other, err := userService.CreateUser(bg, &pb.RegisterRequest{
+		Username: "restored",
+		Email: "restored@test.com",
+		Password: "test",
+	})
+	require.NoError(t, err)
+
+	err = userService.SoftDeleteUser(bg, userId)
+	require.NoError(t, err)
+	err = userService.SoftDeleteUser(bg, userId)
+	require.Equal(t, service.ErrUserDeleted, err)
+
+	err = userService.SoftDeleteUser(bg, other.UserId)
+	require.NoError(t, err)
+	err = userService.RestoreUser(bg, other.UserId)
+	require.NoError(t, err)
+
+	user, err = userService.GetUser(bg, userId)
+	require.NoError(t, err)
+	require.True(t, userPurger.PurgeTime(user).After(time.Now()))
+
+	// still in the grace period
+	cnt, err := userPurger.PurgeDeletedUsers(bg, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 0, cnt)
+
+	// email is still taken
+	usedUserId, err := userService.GetUserIdByEmail(bg, "purged@test.com")
+	require.NoError(t, err)
+	require.Equal(t, userId, usedUserId)
+
+	cnt, err = userPurger.PurgeDeletedUsers(bg, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 1, cnt)
+
+	_, err = userService.GetUser(bg, userId)
+	require.Equal(t, service.ErrUserNotFound, err)
+
+	_, err = userService.GetUserIdByEmail(bg, "purged@test.com")
+	require.Equal(t, service.ErrUserNotFound, err)
+
+	_, err = userService.GetUser(bg, other.UserId)
+	require.NoError(t, err)
+
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/keyvalstore/store"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/utils"
+	"go.uber.org/zap"
+	"strings"
+)
+
+const (
+	UserSortCreated  = "created"
+	UserSortUsername = "username"
+	UserSortEmail    = "email"
+
+	userSearchMaxLimit  = 100
+	userSearchScanLimit = 5000 // index entries scanned per request, the cursor continues the search
+
+	userIndexVersion   = "1"
+	createdIndexFormat = "user-created:%012d:%s"
+)
+
+/**
+Search walks one of the secondary indexes, username:%s, email:%s or user-created:%012d:%s, all of them
+point to the user id. Prefix filters select the index, other filters are applied to the loaded user.
+The cursor is the last scanned index key, so the next page seeks right after it.
+ */
+
+func (t *implUserService) SearchUsers(ctx context.Context, req *pb.AdminUserSearchRequest) (items []*pb.UserEntity, nextCursor string, err error) {
+
+	prefix, seek, stop, err := userSearchRange(req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var cursorKey []byte
+	if req.Cursor != "" {
+		cursorKey, err = base64.RawURLEncoding.DecodeString(req.Cursor)
+		if err != nil || !bytes.HasPrefix(cursorKey, []byte(prefix)) {
+			return nil, "", ErrInvalidCursor
+		}
+		seek = string(cursorKey)
+	}
+
+	limit := int(req.Limit)
+	if limit <= 0 || limit > userSearchMaxLimit {
+		limit = userSearchMaxLimit
+	}
+
+	op := t.HostStore.Enumerate(ctx).
+		ByPrefix(prefix).
+		Seek(seek).
+		WithBatchSize(BatchSize)
+	if req.Descending {
+		op = op.Reverse()
+	}
+
+	var lastKey []byte
+	scanned := 0
+	more := false
+	err = op.Do(func(entry *store.RawEntry) bool {
+
+		if cursorKey != nil && bytes.Equal(entry.Key, cursorKey) {
+			return true
+		}
+		if stop != "" && beyondStop(string(entry.Key), stop, req.Descending) {
+			return false
+		}
+		if len(items) == limit || scanned == userSearchScanLimit {
+			more = true
+			return false
+		}
+		scanned++
+		lastKey = append(lastKey[:0], entry.Key...)
+
+		user, err := t.GetUser(ctx, string(entry.Value))
+		if err != nil {
+			t.Log.Warn("SearchUsers", zap.String("key", string(entry.Key)), zap.Error(err))
+			return true
+		}
+
+		if matchUser(user, req) {
+			items = append(items, user)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if more && lastKey != nil {
+		nextCursor = base64.RawURLEncoding.EncodeToString(lastKey)
+	}
+
+	return items, nextCursor, nil
+}
+
+/**
+Returns the index prefix, the start key and the exclusive stop key of the created range.
+ */
+func userSearchRange(req *pb.AdminUserSearchRequest) (prefix, seek, stop string, err error) {
+
+	switch {
+	case req.EmailPrefix != "":
+		prefix = "email:" + utils.NormalizeEmail(req.EmailPrefix)
+	case req.UsernamePrefix != "":
+		prefix = "username:" + utils.NormalizeUsername(req.UsernamePrefix)
+	default:
+		switch req.Sort {
+		case "", UserSortCreated:
+			prefix = "user-created:"
+		case UserSortUsername:
+			prefix = "username:"
+		case UserSortEmail:
+			prefix = "email:"
+		default:
+			return "", "", "", ErrUnknownSort
+		}
+	}
+
+	seek = prefix
+	if req.Descending {
+		seek = prefix + "\xff"
+	}
+
+	if prefix == "user-created:" {
+		from := fmt.Sprintf("user-created:%012d:", req.CreatedFrom)
+		var to string
+		if req.CreatedTo > 0 {
+			to = fmt.Sprintf("user-created:%012d:", req.CreatedTo)
+		}
+		if req.Descending {
+			if to != "" {
+				seek = to
+			}
+			if req.CreatedFrom > 0 {
+				stop = from
+			}
+		} else {
+			seek = from
+			stop = to
+		}
+	}
+
+	return prefix, seek, stop, nil
+}
+
+func beyondStop(key, stop string, descending bool) bool {
+	if descending {
+		return key < stop
+	}
+	return key >= stop
+}
+
+func matchUser(user *pb.UserEntity, req *pb.AdminUserSearchRequest) bool {
+
+	if req.EmailPrefix != "" && !strings.HasPrefix(user.Email, utils.NormalizeEmail(req.EmailPrefix)) {
+		return false
+	}
+	if req.UsernamePrefix != "" && !strings.HasPrefix(user.Username, utils.NormalizeUsername(req.UsernamePrefix)) {
+		return false
+	}
+	if req.Name != "" {
+		fullName := strings.ToLower(strings.Join(strings.Fields(user.FirstName+" "+user.MiddleName+" "+user.LastName), " "))
+		if !strings.Contains(fullName, strings.ToLower(strings.TrimSpace(req.Name))) {
+			return false
+		}
+	}
+	if req.Role != "" && user.Role.String() != req.Role && !hasRole(user, req.Role) {
+		return false
+	}
+	if req.CreatedFrom > 0 && user.CreTimestamp < req.CreatedFrom {
+		return false
+	}
+	if req.CreatedTo > 0 && user.CreTimestamp >= req.CreatedTo {
+		return false
+	}
+	return true
+}
+
+func hasRole(user *pb.UserEntity, role string) bool {
+	for _, name := range user.Roles {
+		if name == role {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+Builds the creation index for users registered before it, runs once per index version.
+ */
+func (t *implUserService) ensureUserIndex(ctx context.Context) error {
+
+	version, err := t.HostStore.Get(ctx).ByKey("user-index:version").ToString()
+	if err != nil {
+		return err
+	}
+	if version == userIndexVersion {
+		return nil
+	}
+
+	var list []*pb.UserEntity
+	err = t.EnumUsers(ctx, func(user *pb.UserEntity) bool {
+		list = append(list, user)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, user := range list {
+		err = t.HostStore.Set(ctx).ByKey(createdIndexFormat, user.CreTimestamp, user.UserId).String(user.UserId)
+		if err != nil {
+			return err
+		}
+	}
+
+	t.Log.Info("UserIndex", zap.Int("users", len(list)), zap.String("version", userIndexVersion))
+	return t.HostStore.Set(ctx).ByKey("user-index:version").String(userIndexVersion)
+}
@@ -6,7 +6,9 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"github.com/keyvalstore/store"
 	"github.com/pkg/errors"
@@ -16,7 +18,6 @@ import (
 	"github.com/sprintframework/template/pkg/pb"
 	"github.com/sprintframework/template/pkg/utils"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 	"time"
 )
 
@@ -32,6 +33,14 @@ type implUserService struct {
 
 	UserSaltKey   string `value:"user-service.salt-key,default="`
 	InitialUserId int    `value:"user-service.initial-id,default=27483984961"` // u00001
+	RecoverCodeAttempts int `value:"user-service.recover-code-attempts,default=5"`
+
+	PasswordHasher    api.PasswordHasher `inject:"optional"` // custom hasher replaces the configured one
+	PasswordAlgorithm string `value:"user-service.password-algorithm,default=argon2id"` // argon2id or bcrypt-sha256
+	Argon2Time        int    `value:"user-service.argon2-time,default=3"`
+	Argon2Memory      int    `value:"user-service.argon2-memory,default=65536"` // KiB
+	Argon2Threads     int    `value:"user-service.argon2-threads,default=2"`
+	BcryptCost        int    `value:"user-service.bcrypt-cost,default=10"`
 }
 
 func UserService() api.UserService {
@@ -45,9 +54,17 @@ func (t *implUserService) PostConstruct() (err error) {
 			return errors.Errorf("generate token error, %v", err)
 		}
 		err = t.ConfigRepository.Set("user-service.salt-key", t.UserSaltKey)
-		return err
+		if err != nil {
+			return err
+		}
 	}
-	return nil
+	if t.PasswordHasher == nil {
+		t.PasswordHasher, err = newPasswordHasher(t.UserSaltKey, t.PasswordAlgorithm, t.Argon2Time, t.Argon2Memory, t.Argon2Threads, t.BcryptCost)
+		if err != nil {
+			return err
+		}
+	}
+	return t.ensureUserIndex(context.Background())
 }
 
 func (t *implUserService) CreateUser(ctx context.Context, req *pb.RegisterRequest) (user *pb.UserEntity, err error) {
@@ -80,7 +97,7 @@ func (t *implUserService) CreateUser(ctx context.Context, req *pb.RegisterReques
 		return nil, errors.New("user password is empty")
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(t.UserSaltKey+req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := t.PasswordHasher.HashPassword(req.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -107,6 +124,7 @@ func (t *implUserService) CreateUser(ctx context.Context, req *pb.RegisterReques
 		PasswordHash: hashedPassword,
 		CreTimestamp: time.Now().Unix(),
 		Role:         role,
+		Status:       pb.UserStatus_PENDING_VERIFICATION,
 	}
 
 	err = t.HostStore.Set(ctx).ByKey("%s:user", userId).Proto(user)
@@ -125,6 +143,12 @@ func (t *implUserService) CreateUser(ctx context.Context, req *pb.RegisterReques
 
 	// email index
 	err = t.HostStore.Set(ctx).ByKey("email:%s", req.Email).String(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	// creation index
+	err = t.HostStore.Set(ctx).ByKey(createdIndexFormat, user.CreTimestamp, userId).String(userId)
 
 	return user, err
 }
@@ -162,12 +186,13 @@ func (t *implUserService) ResetPassword(ctx context.Context, userId string, newP
 
 	err = t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(t.UserSaltKey+newPassword), bcrypt.DefaultCost)
+		hashedPassword, err := t.PasswordHasher.HashPassword(newPassword)
 		if err != nil {
 			return err
 		}
 
 		user.PasswordHash = hashedPassword
+		user.PasswordResetRequired = false
 
 		email = user.Email
 		return nil
@@ -205,13 +230,56 @@ func (t *implUserService) AuthenticateUser(ctx context.Context, login, password
 	if user.UserId != userId {
 		return nil, ErrUserNotFound
 	}
-	err = bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(t.UserSaltKey+password))
+	ok, needsRehash, err := t.PasswordHasher.VerifyPassword(user.PasswordHash, password)
 	if err != nil {
+		return nil, errors.Errorf("verify password of user '%s', %v", userId, err)
+	}
+	if !ok {
 		return user, ErrUserInvalidPassword
 	}
+	// the status is visible only to the owner of the password
+	if user.Status == pb.UserStatus_DELETED {
+		return user, ErrUserDeleted
+	}
+	if user.Status == pb.UserStatus_SUSPENDED {
+		return user, ErrUserSuspended
+	}
+	if user.PasswordResetRequired {
+		return user, ErrPasswordResetRequired
+	}
+	if needsRehash {
+		if err := t.rehashPassword(ctx, user, password); err != nil {
+			// login is still valid, try again next time
+			t.Log.Warn("RehashPassword", zap.String("userId", userId), zap.Error(err))
+		}
+	}
 	return user, nil
 }
 
+func (t *implUserService) rehashPassword(ctx context.Context, user *pb.UserEntity, password string) error {
+
+	hashedPassword, err := t.PasswordHasher.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	savedHash := user.PasswordHash
+	err = t.DoWithUser(ctx, user.UserId, func(entity *pb.UserEntity) error {
+		if !bytes.Equal(entity.PasswordHash, savedHash) {
+			// password was changed concurrently
+			return nil
+		}
+		entity.PasswordHash = hashedPassword
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	user.PasswordHash = hashedPassword
+	return nil
+}
+
 func (t *implUserService) IsUsernameAvailable(ctx context.Context, username string) (bool, string, error) {
 
 	normName := utils.NormalizeUsername(username)
@@ -341,6 +409,12 @@ func (t *implUserService) SaveUser(ctx context.Context, user *pb.UserEntity) (er
 				return err
 			}
 		}
+		if oldUser.CreTimestamp != user.CreTimestamp {
+			err = t.HostStore.Remove(ctx).ByKey(createdIndexFormat, oldUser.CreTimestamp, oldUser.UserId).Do()
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	err = t.HostStore.Set(ctx).ByKey("%s:user", user.UserId).Proto(user)
@@ -378,6 +452,12 @@ func (t *implUserService) SaveUser(ctx context.Context, user *pb.UserEntity) (er
 		return err
 	}
 
+	// creation index
+	err = t.HostStore.Set(ctx).ByKey(createdIndexFormat, user.CreTimestamp, user.UserId).String(user.UserId)
+	if err != nil {
+		return err
+	}
+
 	// back reference
 	err = t.HostStore.Set(ctx).ByKey("user:%s", user.UserId).String(user.UserId)
 	return
@@ -437,7 +517,95 @@ func (t *implUserService) doRemoveUser(ctx context.Context, user *pb.UserEntity)
 		return err
 	}
 
-	return nil
+	err = t.HostStore.Remove(ctx).ByKey(createdIndexFormat, user.CreTimestamp, user.UserId).Do()
+	if err != nil {
+		return err
+	}
+
+	for _, identity := range user.Identities {
+		err = t.HostStore.Remove(ctx).ByKey("identity:%s", identity).Do()
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.HostStore.Remove(ctx).ByKey("deleted:%s", user.UserId).Do()
+}
+
+/**
+Soft deleted user keeps the username and email until the purge, so nobody could take them during the grace period.
+ */
+func (t *implUserService) SoftDeleteUser(ctx context.Context, userId string) (err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	err = t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+
+		if user.Status == pb.UserStatus_DELETED {
+			return ErrUserDeleted
+		}
+
+		user.Status = pb.UserStatus_DELETED
+		user.StatusReason = ""
+		user.StatusTimestamp = time.Now().Unix()
+		userId = user.UserId
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// deleted users index for the purger
+	return t.HostStore.Set(ctx).ByKey("deleted:%s", userId).String(userId)
+}
+
+func (t *implUserService) RestoreUser(ctx context.Context, userId string) (err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	err = t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+
+		if user.Status != pb.UserStatus_DELETED {
+			return ErrUserNotDeleted
+		}
+
+		user.Status = pb.UserStatus_ACTIVE
+		if !user.Verified {
+			user.Status = pb.UserStatus_PENDING_VERIFICATION
+		}
+		user.StatusTimestamp = time.Now().Unix()
+		userId = user.UserId
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return t.HostStore.Remove(ctx).ByKey("deleted:%s", userId).Do()
+}
+
+func (t *implUserService) EnumDeletedUsers(ctx context.Context, cb func(user *pb.UserEntity) bool) error {
+
+	return t.HostStore.Enumerate(ctx).
+		ByPrefix("deleted:").
+		WithBatchSize(BatchSize).
+		Do(func(entry *store.RawEntry) bool {
+			userId := string(entry.Value)
+			user, err := t.GetUser(context.Background(), userId)
+			if err == nil && user.Status == pb.UserStatus_DELETED {
+				return cb(user)
+			} else if err != nil {
+				t.Log.Warn("EnumDeletedUsers", zap.Error(err), zap.String("userId", userId))
+			}
+			return true
+		})
+
 }
 
 func (t *implUserService) DropUserContent(ctx context.Context, userId string) error {
@@ -466,6 +634,26 @@ func (t *implUserService) EnumUsers(ctx context.Context, cb func(user *pb.UserEn
 
 }
 
+func (t *implUserService) ScanUsers(ctx context.Context, cursor string, reverse bool, offset, limit int, cb func(user *pb.UserEntity, position int)) (string, error) {
+
+	return scanPrefix(ctx, t.HostStore, "user:", cursor, reverse, offset, limit, func(entry *store.RawEntry, position int) bool {
+		userId := string(entry.Value)
+		user := new(pb.UserEntity)
+		err := t.HostStore.Get(ctx).ByKey("%s:user", userId).ToProto(user)
+		if err != nil || user.Email == "" {
+			t.Log.Warn("ScanUsers", zap.Error(err), zap.String("backwardKey", string(entry.Key)), zap.String("userId", userId))
+			return false
+		}
+		cb(user, position)
+		return true
+	})
+
+}
+
+func (t *implUserService) EstimateUsers(ctx context.Context) (int, bool, error) {
+	return estimatePrefix(ctx, t.HostStore, "user:")
+}
+
 func (t *implUserService) DoWithUser(ctx context.Context, userId string, cb func(user *pb.UserEntity) error) (err error) {
 
 	userId = utils.NormalizeUserId(userId)
@@ -566,10 +754,13 @@ func (t *implUserService) SaveRecoverCode(ctx context.Context, login string, rc
 		return errors.New("login is empty")
 	}
 
+	rc.Attempts = 0
+	rc.ExpireTimestamp = time.Now().Unix() + int64(ttlSeconds)
+
 	return t.HostStore.Set(ctx).ByKey("recover:login:%s", login).WithTtl(ttlSeconds).Proto(rc)
 }
 
-func (t *implUserService) ValidateRecoverCode(ctx context.Context, login string, code string) error {
+func (t *implUserService) ValidateRecoverCode(ctx context.Context, login, code, remoteIP string) error {
 
 	login = utils.NormalizeLogin(login)
 	if login == "" {
@@ -581,16 +772,104 @@ func (t *implUserService) ValidateRecoverCode(ctx context.Context, login string,
 		return errors.New("user code is empty")
 	}
 
-	rc := new(pb.RecoverCodeEntity)
-	err := t.HostStore.Get(ctx).ByKey("recover:login:%s", login).ToProto(rc)
+	valid, err := t.doValidateRecoverCode(ctx, login, code, remoteIP)
 	if err != nil {
 		return err
 	}
-
-	if rc.Code != code {
+	if !valid {
 		return ErrInvalidRecoverCode
 	}
-
 	return nil
+}
+
+func (t *implUserService) doValidateRecoverCode(ctx context.Context, login, code, remoteIP string) (valid bool, err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	rc := new(pb.RecoverCodeEntity)
+	err = t.HostStore.Get(ctx).ByKey("recover:login:%s", login).ToProto(rc)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().Unix()
+	if rc.Code == "" || rc.ExpireTimestamp <= now {
+		return false, nil
+	}
+
+	if rc.RemoteIp == remoteIP && subtle.ConstantTimeCompare([]byte(rc.Code), []byte(code)) == 1 {
+		// single use
+		err = t.HostStore.Remove(ctx).ByKey("recover:login:%s", login).Do()
+		return err == nil, err
+	}
+
+	rc.Attempts++
+	if int(rc.Attempts) >= t.RecoverCodeAttempts {
+		t.Log.Warn("RecoverCodeAttemptsExceeded", zap.String("login", login), zap.String("remoteIP", remoteIP))
+		err = t.HostStore.Remove(ctx).ByKey("recover:login:%s", login).Do()
+	} else {
+		err = t.HostStore.Set(ctx).ByKey("recover:login:%s", login).WithTtl(int(rc.ExpireTimestamp - now)).Proto(rc)
+	}
+	return false, err
+}
+
+func (t *implUserService) VerifyEmail(ctx context.Context, userId, email string) error {
+
+	email = utils.NormalizeEmail(email)
+	if email == "" {
+		return ErrInvalidVerifyToken
+	}
+
+	return t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+
+		if utils.NormalizeEmail(user.Email) != email {
+			// email was changed after the token was issued
+			return ErrInvalidVerifyToken
+		}
+
+		user.Verified = true
+		if user.Status == pb.UserStatus_PENDING_VERIFICATION {
+			user.Status = pb.UserStatus_ACTIVE
+			user.StatusTimestamp = time.Now().Unix()
+		}
+		return nil
+	})
+
+}
+
+func (t *implUserService) SetStatus(ctx context.Context, userId string, status pb.UserStatus, reason string) error {
+
+	if status == pb.UserStatus_DELETED {
+		return errors.New("deleted status is set by SoftDeleteUser")
+	}
+
+	return t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+
+		if user.Status == pb.UserStatus_DELETED {
+			return ErrUserDeleted
+		}
+
+		if status == pb.UserStatus_ACTIVE && !user.Verified {
+			// activation does not confirm the email
+			status = pb.UserStatus_PENDING_VERIFICATION
+		}
+
+		user.Status = status
+		user.StatusReason = reason
+		user.StatusTimestamp = time.Now().Unix()
+		return nil
+	})
+
+}
+
+func (t *implUserService) SetPasswordResetRequired(ctx context.Context, userId string, required bool) error {
+
+	return t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+		user.PasswordResetRequired = required
+		return nil
+	})
 
 }
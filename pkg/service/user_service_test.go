@@ -17,6 +17,7 @@ import (
 	"github.com/sprintframework/sprintframework/sprintcore"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 	"os"
 	"strings"
 	"testing"
@@ -51,6 +52,73 @@ func TestUserCRUID(t *testing.T) {
 
 	verifyUserCRUID(t, userService)
 	verifyUserTransactional(t, userService, hostStore)
+	verifyRecoverCode(t, userService)
+	verifyExternalUser(t, userService)
+	verifyUserStatus(t, userService)
+
+}
+
+func TestPasswordRehash(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-store", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	configRepository := sprintcore.ConfigRepository(1000)
+	userService := service.UserService()
+
+	ctx, err := glue.New(log, configStore, configRepository, hostStore, userService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	user, err := userService.CreateUser(bg, &pb.RegisterRequest{
+		Username: "test",
+		FirstName: "Test",
+		Email: "test@test.com",
+		Password: "test",
+	})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(user.PasswordHash), "$argon2id$v=19$"))
+
+	// legacy hash made by the previous versions
+	saltKey, err := configRepository.Get("user-service.salt-key")
+	require.NoError(t, err)
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte(saltKey+"test"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	user.PasswordHash = legacyHash
+	err = userService.SaveUser(bg, user)
+	require.NoError(t, err)
+
+	_, err = userService.AuthenticateUser(bg, "test", "wrong")
+	require.Equal(t, service.ErrUserInvalidPassword, err)
+
+	_, err = userService.AuthenticateUser(bg, "test", "test")
+	require.NoError(t, err)
+
+	user, err = userService.GetUser(bg, user.UserId)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(user.PasswordHash), "$argon2id$"))
+
+	_, err = userService.AuthenticateUser(bg, "test", "test")
+	require.NoError(t, err)
 
 }
 
@@ -141,4 +209,429 @@ func verifyUserTransactional(t *testing.T, userService api.UserService, transact
 
 	require.NoError(t, err)
 
-}
\ No newline at end of file
+}
+
+func verifyRecoverCode(t *testing.T, userService api.UserService) {
+
+	ctx := context.Background()
+
+	err := userService.SaveRecoverCode(ctx, "test@test.com", &pb.RecoverCodeEntity{
+		Code:     "12345678",
+		RemoteIp: "127.0.0.1",
+	}, 60)
+	require.NoError(t, err)
+
+	// bound to the requester IP
+	err = userService.ValidateRecoverCode(ctx, "test@test.com", "12345678", "127.0.0.2")
+	require.Equal(t, service.ErrInvalidRecoverCode, err)
+
+	err = userService.ValidateRecoverCode(ctx, "test@test.com", "12345678", "127.0.0.1")
+	require.NoError(t, err)
+
+	// single use
+	err = userService.ValidateRecoverCode(ctx, "test@test.com", "12345678", "127.0.0.1")
+	require.Equal(t, service.ErrInvalidRecoverCode, err)
+
+	err = userService.SaveRecoverCode(ctx, "test@test.com", &pb.RecoverCodeEntity{
+		Code:     "12345678",
+		RemoteIp: "127.0.0.1",
+	}, 60)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		err = userService.ValidateRecoverCode(ctx, "test@test.com", "00000000", "127.0.0.1")
+		require.Equal(t, service.ErrInvalidRecoverCode, err)
+	}
+
+	// invalidated after too many attempts
+	err = userService.ValidateRecoverCode(ctx, "test@test.com", "12345678", "127.0.0.1")
+	require.Equal(t, service.ErrInvalidRecoverCode, err)
+
+}
+
+func verifyExternalUser(t *testing.T, userService api.UserService) {
+
+	ctx := context.Background()
+
+	user, err := userService.CreateExternalUser(ctx, &pb.ExternalIdentity{
+		Provider:      "google",
+		Subject:       "12345",
+		Email:         "external@test.com",
+		EmailVerified: true,
+		Name:          "External User",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "external", user.Username)
+	require.True(t, user.Verified)
+
+	userId, err := userService.GetUserIdByIdentity(ctx, "google", "12345")
+	require.NoError(t, err)
+	require.Equal(t, user.UserId, userId)
+
+	_, err = userService.CreateExternalUser(ctx, &pb.ExternalIdentity{
+		Provider: "github",
+		Subject:  "3",
+		Email:    "unverified@test.com",
+	})
+	require.Equal(t, service.ErrEmailNotVerified, err)
+
+	_, err = userService.GetUserIdByLogin(ctx, "unverified@test.com")
+	require.Equal(t, service.ErrUserNotFound, err)
+
+	_, err = userService.CreateExternalUser(ctx, &pb.ExternalIdentity{
+		Provider:      "github",
+		Subject:       "1",
+		Email:         "external@test.com",
+		EmailVerified: true,
+	})
+	require.Equal(t, service.ErrEmailAlreadyUsed, err)
+
+	err = userService.LinkIdentity(ctx, userId, "github", "1")
+	require.NoError(t, err)
+
+	other, err := userService.CreateExternalUser(ctx, &pb.ExternalIdentity{
+		Provider:          "github",
+		Subject:           "2",
+		Email:             "other@test.com",
+		EmailVerified:     true,
+		PreferredUsername: "external",
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, "external", other.Username)
+
+	err = userService.LinkIdentity(ctx, other.UserId, "github", "1")
+	require.Equal(t, service.ErrIdentityAlreadyLinked, err)
+
+	err = userService.RemoveUser(ctx, userId)
+	require.NoError(t, err)
+
+	_, err = userService.GetUserIdByIdentity(ctx, "github", "1")
+	require.Equal(t, service.ErrUserNotFound, err)
+
+}
+
+func verifyUserStatus(t *testing.T, userService api.UserService) {
+
+	ctx := context.Background()
+
+	user, err := userService.CreateUser(ctx, &pb.RegisterRequest{
+		Username: "status",
+		Email: "status@test.com",
+		Password: "test",
+	})
+	require.NoError(t, err)
+	require.Equal(t, pb.UserStatus_PENDING_VERIFICATION, user.Status)
+	userId := user.UserId
+
+	err = userService.SetStatus(ctx, userId, pb.UserStatus_SUSPENDED, "spam")
+	require.NoError(t, err)
+
+	_, err = userService.AuthenticateUser(ctx, userId, "wrong")
+	require.Equal(t, service.ErrUserInvalidPassword, err)
+	user, err = userService.AuthenticateUser(ctx, userId, "test")
+	require.Equal(t, service.ErrUserSuspended, err)
+	require.Equal(t, "spam", user.StatusReason)
+
+	// activation keeps the pending verification
+	err = userService.SetStatus(ctx, userId, pb.UserStatus_ACTIVE, "")
+	require.NoError(t, err)
+	user, err = userService.AuthenticateUser(ctx, userId, "test")
+	require.NoError(t, err)
+	require.Equal(t, pb.UserStatus_PENDING_VERIFICATION, user.Status)
+
+	err = userService.VerifyEmail(ctx, userId, "status@test.com")
+	require.NoError(t, err)
+	user, err = userService.GetUser(ctx, userId)
+	require.NoError(t, err)
+	require.Equal(t, pb.UserStatus_ACTIVE, user.Status)
+
+	err = userService.SetPasswordResetRequired(ctx, userId, true)
+	require.NoError(t, err)
+	_, err = userService.AuthenticateUser(ctx, userId, "test")
+	require.Equal(t, service.ErrPasswordResetRequired, err)
+
+	_, err = userService.ResetPassword(ctx, userId, "test2")
+	require.NoError(t, err)
+	_, err = userService.AuthenticateUser(ctx, userId, "test2")
+	require.NoError(t, err)
+
+	err = userService.SoftDeleteUser(ctx, userId)
+	require.NoError(t, err)
+	_, err = userService.AuthenticateUser(ctx, userId, "wrong")
+	require.Equal(t, service.ErrUserInvalidPassword, err)
+	_, err = userService.AuthenticateUser(ctx, userId, "test2")
+	require.Equal(t, service.ErrUserDeleted, err)
+	err = userService.SetStatus(ctx, userId, pb.UserStatus_ACTIVE, "")
+	require.Equal(t, service.ErrUserDeleted, err)
+
+	var deleted []string
+	err = userService.EnumDeletedUsers(ctx, func(user *pb.UserEntity) bool {
+		deleted = append(deleted, user.UserId)
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{userId}, deleted)
+
+	err = userService.RestoreUser(ctx, userId)
+	require.NoError(t, err)
+	err = userService.RestoreUser(ctx, userId)
+	require.Equal(t, service.ErrUserNotDeleted, err)
+	user, err = userService.AuthenticateUser(ctx, userId, "test2")
+	require.NoError(t, err)
+	require.Equal(t, pb.UserStatus_ACTIVE, user.Status)
+
+	deleted = nil
+	err = userService.EnumDeletedUsers(ctx, func(user *pb.UserEntity) bool {
+		deleted = append(deleted, user.UserId)
+		return true
+	})
+	require.NoError(t, err)
+	require.Empty(t, deleted)
+
+	err = userService.RemoveUser(ctx, userId)
+	require.NoError(t, err)
+
+}
+
+func newTestUserService(t *testing.T) (api.UserService, func()) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-store-test")
+	require.NoError(t, err)
+
+	configStore, err := badgerstore.New("config-store", configDir)
+	require.NoError(t, err)
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+
+	userService := service.UserService()
+
+	ctx, err := glue.New(log, configStore, sprintcore.ConfigRepository(1000), hostStore, userService)
+	require.NoError(t, err)
+
+	return userService, func() {
+		ctx.Close()
+		hostStore.Destroy()
+		os.RemoveAll(hostDir)
+		configStore.Destroy()
+		os.RemoveAll(configDir)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+
+	userService, closer := newTestUserService(t)
+	defer closer()
+
+	ctx := context.Background()
+
+	user, err := userService.CreateUser(ctx, &pb.RegisterRequest{
+		Username: "test",
+		FirstName: "Test",
+		Email: "test@test.com",
+		Password: "test",
+	})
+	require.NoError(t, err)
+	userId := user.UserId
+
+	_, err = userService.ChangePassword(ctx, userId, "wrong", "test2")
+	require.Equal(t, service.ErrUserInvalidPassword, err)
+
+	email, err := userService.ChangePassword(ctx, userId, "test", "test2")
+	require.NoError(t, err)
+	require.Equal(t, "test@test.com", email)
+
+	_, err = userService.AuthenticateUser(ctx, userId, "test")
+	require.Equal(t, service.ErrUserInvalidPassword, err)
+
+	_, err = userService.AuthenticateUser(ctx, userId, "test2")
+	require.NoError(t, err)
+
+}
+
+func TestChangeEmail(t *testing.T) {
+
+	userService, closer := newTestUserService(t)
+	defer closer()
+
+	ctx := context.Background()
+
+	user, err := userService.CreateUser(ctx, &pb.RegisterRequest{
+		Username: "test",
+		FirstName: "Test",
+		Email: "test@test.com",
+		Password: "test",
+	})
+	require.NoError(t, err)
+	userId := user.UserId
+
+	require.False(t, user.Verified)
+	err = userService.VerifyEmail(ctx, userId, "other@test.com")
+	require.Equal(t, service.ErrInvalidVerifyToken, err)
+	err = userService.VerifyEmail(ctx, userId, "Test@Test.com")
+	require.NoError(t, err)
+
+	user, err = userService.GetUser(ctx, userId)
+	require.NoError(t, err)
+	require.True(t, user.Verified)
+
+	err = userService.ChangeEmail(ctx, userId, "test@test.com", "new@test.com")
+	require.NoError(t, err)
+
+	// the confirmation is for the address the change was requested from
+	err = userService.ChangeEmail(ctx, userId, "test@test.com", "other@test.com")
+	require.Equal(t, service.ErrInvalidVerifyToken, err)
+
+	newUserId, err := userService.GetUserIdByEmail(ctx, "new@test.com")
+	require.NoError(t, err)
+	require.Equal(t, userId, newUserId)
+
+	_, err = userService.GetUserIdByEmail(ctx, "test@test.com")
+	require.Equal(t, service.ErrUserNotFound, err)
+
+}
+
+func TestUpdateProfile(t *testing.T) {
+
+	userService, closer := newTestUserService(t)
+	defer closer()
+
+	ctx := context.Background()
+
+	user, err := userService.CreateUser(ctx, &pb.RegisterRequest{
+		Username: "test",
+		FirstName: "Test",
+		Email: "test@test.com",
+		Password: "test",
+	})
+	require.NoError(t, err)
+	userId := user.UserId
+
+	user, err = userService.UpdateProfile(ctx, userId, &pb.UpdateProfileRequest{
+		Username: "tester",
+		FirstName: "Test",
+		LastName: "TT",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "tester", user.Username)
+	require.Equal(t, "TT", user.LastName)
+
+	usernameUserId, err := userService.GetUserIdByUsername(ctx, "tester")
+	require.NoError(t, err)
+	require.Equal(t, userId, usernameUserId)
+
+	_, err = userService.GetUserIdByUsername(ctx, "test")
+	require.Equal(t, service.ErrUserNotFound, err)
+
+}
+
+func TestUserSearch(t *testing.T) {
+
+	log, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	configDir, err := os.MkdirTemp(os.TempDir(), "config-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(configDir)
+
+	configStore, err := badgerstore.New("config-store", configDir)
+	require.NoError(t, err)
+	defer configStore.Destroy()
+
+	hostDir, err := os.MkdirTemp(os.TempDir(), "host-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(hostDir)
+
+	hostStore, err := badgerstore.New("host-store", hostDir)
+	require.NoError(t, err)
+	defer hostStore.Destroy()
+
+	userService := service.UserService()
+
+	ctx, err := glue.New(log, configStore, sprintcore.ConfigRepository(1000), hostStore, userService)
+	require.NoError(t, err)
+	defer ctx.Close()
+
+	bg := context.Background()
+
+	names := []string{"alice", "bobby", "carol", "david", "erin0"}
+	for i, name := range names {
+		user, err := userService.CreateUser(bg, &pb.RegisterRequest{
+			Username: name,
+			FirstName: strings.ToUpper(name[:1]) + name[1:],
+			LastName: "Smith",
+			Email: name + "@test.com",
+			Password: "test",
+		})
+		require.NoError(t, err)
+		user.CreTimestamp = int64(1000 + i*100)
+		if i % 2 == 0 {
+			user.Roles = []string{"editor"}
+		}
+		err = userService.SaveUser(bg, user)
+		require.NoError(t, err)
+	}
+
+	usernames := func(items []*pb.UserEntity) []string {
+		var list []string
+		for _, item := range items {
+			list = append(list, item.Username)
+		}
+		return list
+	}
+
+	// pages by creation time
+	var all []string
+	req := &pb.AdminUserSearchRequest{Limit: 2}
+	for {
+		items, cursor, err := userService.SearchUsers(bg, req)
+		require.NoError(t, err)
+		require.True(t, len(items) <= 2)
+		all = append(all, usernames(items)...)
+		if cursor == "" {
+			break
+		}
+		req.Cursor = cursor
+	}
+	require.Equal(t, names, all)
+
+	items, cursor, err := userService.SearchUsers(bg, &pb.AdminUserSearchRequest{Descending: true, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"erin0", "david"}, usernames(items))
+	items, _, err = userService.SearchUsers(bg, &pb.AdminUserSearchRequest{Descending: true, Limit: 2, Cursor: cursor})
+	require.NoError(t, err)
+	require.Equal(t, []string{"carol", "bobby"}, usernames(items))
+
+	items, _, err = userService.SearchUsers(bg, &pb.AdminUserSearchRequest{CreatedFrom: 1100, CreatedTo: 1300})
+	require.NoError(t, err)
+	require.Equal(t, []string{"bobby", "carol"}, usernames(items))
+
+	items, _, err = userService.SearchUsers(bg, &pb.AdminUserSearchRequest{CreatedFrom: 1100, CreatedTo: 1300, Descending: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"carol", "bobby"}, usernames(items))
+
+	items, _, err = userService.SearchUsers(bg, &pb.AdminUserSearchRequest{EmailPrefix: "Da"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"david"}, usernames(items))
+
+	items, _, err = userService.SearchUsers(bg, &pb.AdminUserSearchRequest{Sort: service.UserSortUsername, Descending: true, Role: "editor"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"erin0", "carol", "alice"}, usernames(items))
+
+	items, _, err = userService.SearchUsers(bg, &pb.AdminUserSearchRequest{Name: "ice smi"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice"}, usernames(items))
+
+	_, _, err = userService.SearchUsers(bg, &pb.AdminUserSearchRequest{Sort: "age"})
+	require.Equal(t, service.ErrUnknownSort, err)
+
+	_, _, err = userService.SearchUsers(bg, &pb.AdminUserSearchRequest{EmailPrefix: "a", Cursor: cursor})
+	require.Equal(t, service.ErrInvalidCursor, err)
+
+}
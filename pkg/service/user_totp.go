@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/utils"
+	"strings"
+	"time"
+)
+
+const (
+	totpSkew = 1
+	backupCodesNum = 10
+	backupCodeLength = 10
+)
+
+func (t *implUserService) EnrollTotp(ctx context.Context, userId, issuer string) (secret string, uri string, err error) {
+
+	err = t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+
+		if user.TotpEnabled {
+			return ErrTotpAlreadyEnabled
+		}
+
+		secret, err = utils.GenerateTotpSecret()
+		if err != nil {
+			return err
+		}
+
+		user.TotpPendingSecret = secret
+		uri = utils.TotpProvisioningURI(issuer, user.Email, secret)
+		return nil
+	})
+
+	return
+}
+
+func (t *implUserService) ConfirmTotp(ctx context.Context, userId, code string) (backupCodes []string, err error) {
+
+	err = t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+
+		if user.TotpEnabled {
+			return ErrTotpAlreadyEnabled
+		}
+
+		if user.TotpPendingSecret == "" {
+			return ErrTotpNotEnrolled
+		}
+
+		step, ok := utils.ValidateTotp(user.TotpPendingSecret, code, time.Now(), totpSkew)
+		if !ok {
+			return ErrTotpInvalidCode
+		}
+
+		backupCodes, err = t.generateBackupCodes()
+		if err != nil {
+			return err
+		}
+
+		user.TotpSecret = user.TotpPendingSecret
+		user.TotpPendingSecret = ""
+		user.TotpEnabled = true
+		user.TotpLastStep = step
+		user.TotpBackupCodes = user.TotpBackupCodes[:0]
+		for _, code := range backupCodes {
+			user.TotpBackupCodes = append(user.TotpBackupCodes, utils.HashCode(code))
+		}
+		return nil
+	})
+
+	return
+}
+
+func (t *implUserService) DisableTotp(ctx context.Context, userId, code string) error {
+
+	return t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+
+		if !user.TotpEnabled {
+			return ErrTotpNotEnrolled
+		}
+
+		if _, err := t.doVerifyTotp(user, code); err != nil {
+			return err
+		}
+
+		user.TotpEnabled = false
+		user.TotpSecret = ""
+		user.TotpPendingSecret = ""
+		user.TotpBackupCodes = nil
+		user.TotpLastStep = 0
+		return nil
+	})
+
+}
+
+func (t *implUserService) VerifyTotp(ctx context.Context, userId, code string) (backupCode bool, err error) {
+
+	err = t.DoWithUser(ctx, userId, func(user *pb.UserEntity) error {
+
+		if !user.TotpEnabled {
+			return ErrTotpNotEnrolled
+		}
+
+		backupCode, err = t.doVerifyTotp(user, code)
+		return err
+	})
+
+	return
+}
+
+func (t *implUserService) doVerifyTotp(user *pb.UserEntity, code string) (bool, error) {
+
+	code = strings.ToLower(utils.NormalizeCode(code))
+
+	if len(code) == utils.TotpDigits {
+
+		step, ok := utils.ValidateTotp(user.TotpSecret, code, time.Now(), totpSkew)
+		if !ok || step <= user.TotpLastStep {
+			return false, ErrTotpInvalidCode
+		}
+
+		user.TotpLastStep = step
+		return false, nil
+	}
+
+	hash := utils.HashCode(code)
+	for i, used := range user.TotpBackupCodes {
+		if subtle.ConstantTimeCompare([]byte(used), []byte(hash)) == 1 {
+			user.TotpBackupCodes = append(user.TotpBackupCodes[:i], user.TotpBackupCodes[i+1:]...)
+			return true, nil
+		}
+	}
+
+	return false, ErrTotpInvalidCode
+}
+
+func (t *implUserService) generateBackupCodes() ([]string, error) {
+	var list []string
+	for i := 0; i < backupCodesNum; i++ {
+		code, err := utils.RandomString(utils.AlphaNumericAlphabet, backupCodeLength)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, code)
+	}
+	return list, nil
+}
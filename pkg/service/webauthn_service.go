@@ -0,0 +1,456 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/keyvalstore/store"
+	"github.com/pkg/errors"
+	"github.com/sprintframework/template/pkg/api"
+	"github.com/sprintframework/template/pkg/pb"
+	"github.com/sprintframework/template/pkg/utils"
+	"github.com/sprintframework/template/pkg/webauthn"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	maxCredentialIdLength = 1023
+	maxCredentialNameLength = 64
+)
+
+/**
+Relying party is derived from webapp.url if not configured, for example:
+
+	webauthn.rp-id = example.com
+	webauthn.origins = https://example.com,https://www.example.com
+	webauthn.user-verification = preferred
+
+Credentials are stored under the user, the assertion finds the owner by the user handle
+(discoverable credentials) or by the user id bound to the challenge.
+ */
+
+type implWebauthnService struct {
+	Log                  *zap.Logger                `inject`
+	HostStore            store.DataStore            `inject:"bean=host-store"`
+	TransactionalManager store.TransactionalManager `inject:"bean=host-store"`
+
+	WebappName       string `value:"webapp.name,default=Light-Template"`
+	WebappURL        string `value:"webapp.url,default="`
+	RPID             string `value:"webauthn.rp-id,default="`
+	Origins          string `value:"webauthn.origins,default="`
+	UserVerification string `value:"webauthn.user-verification,default=preferred"` // required, preferred or discouraged
+	ChallengeMinutes int    `value:"webauthn.challenge-minutes,default=5"`
+
+	rp *webauthn.RelyingParty
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func WebauthnService() api.WebauthnService {
+	return &implWebauthnService{}
+}
+
+func (t *implWebauthnService) PostConstruct() error {
+
+	rp := &webauthn.RelyingParty{
+		ID:               t.RPID,
+		Name:             t.WebappName,
+		UserVerification: t.UserVerification == "required",
+	}
+
+	for _, origin := range strings.Split(t.Origins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			rp.Origins = append(rp.Origins, origin)
+		}
+	}
+
+	if t.WebappURL != "" {
+		u, err := url.Parse(t.WebappURL)
+		if err != nil {
+			return errors.Errorf("invalid webapp.url '%s', %v", t.WebappURL, err)
+		}
+		if rp.ID == "" {
+			rp.ID = u.Hostname()
+		}
+		if len(rp.Origins) == 0 {
+			rp.Origins = []string{u.Scheme + "://" + u.Host}
+		}
+	}
+
+	if rp.ID != "" && len(rp.Origins) > 0 {
+		t.rp = rp
+	}
+
+	return nil
+}
+
+func (t *implWebauthnService) BeginRegistration(ctx context.Context, user *pb.UserEntity) (string, error) {
+
+	if t.rp == nil {
+		return "", ErrWebauthnNotConfigured
+	}
+
+	challenge, err := t.createChallenge(ctx, user.UserId, true)
+	if err != nil {
+		return "", err
+	}
+
+	exclude := make([]credentialDescriptor, 0)
+	err = t.EnumCredentials(ctx, user.UserId, func(cred *pb.WebauthnCredentialEntity) bool {
+		exclude = append(exclude, credentialDescriptor{Type: "public-key", ID: cred.CredentialId})
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var params []map[string]interface{}
+	for _, alg := range webauthn.SupportedAlgorithms {
+		params = append(params, map[string]interface{}{"type": "public-key", "alg": alg})
+	}
+
+	displayName := strings.TrimSpace(strings.Join([]string{user.FirstName, user.LastName}, " "))
+	if displayName == "" {
+		displayName = user.Username
+	}
+
+	options, err := json.Marshal(map[string]interface{}{
+		"rp": map[string]string{
+			"id":   t.rp.ID,
+			"name": t.rp.Name,
+		},
+		"user": map[string]string{
+			"id":          base64.RawURLEncoding.EncodeToString([]byte(user.UserId)),
+			"name":        user.Username,
+			"displayName": displayName,
+		},
+		"challenge":          challenge,
+		"pubKeyCredParams":   params,
+		"timeout":            t.ChallengeMinutes * 60000,
+		"excludeCredentials": exclude,
+		"authenticatorSelection": map[string]string{
+			"residentKey":      "preferred",
+			"userVerification": t.UserVerification,
+		},
+		"attestation": "none",
+	})
+	return string(options), err
+}
+
+func (t *implWebauthnService) FinishRegistration(ctx context.Context, userId, name string, clientDataJSON, attestationObject []byte) (*pb.WebauthnCredentialEntity, error) {
+
+	if t.rp == nil {
+		return nil, ErrWebauthnNotConfigured
+	}
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return nil, errors.New("user id is empty")
+	}
+
+	challenge, err := t.consumeChallenge(ctx, clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	if !challenge.entity.Registration || challenge.entity.UserId != userId {
+		return nil, ErrWebauthnInvalidResponse
+	}
+
+	cred, err := t.rp.VerifyRegistration(challenge.value, clientDataJSON, attestationObject)
+	if err != nil {
+		t.Log.Warn("WebauthnRegistration", zap.String("userId", userId), zap.Error(err))
+		return nil, ErrWebauthnInvalidResponse
+	}
+
+	credentialId := base64.RawURLEncoding.EncodeToString(cred.ID)
+	if len(credentialId) > maxCredentialIdLength {
+		return nil, ErrWebauthnInvalidResponse
+	}
+
+	name = strings.TrimSpace(name)
+	if len(name) > maxCredentialNameLength {
+		name = name[:maxCredentialNameLength]
+	}
+	if name == "" {
+		name = "Passkey"
+	}
+
+	now := time.Now().Unix()
+	entity := &pb.WebauthnCredentialEntity{
+		CredentialId:  credentialId,
+		PublicKey:     cred.PublicKey,
+		Aaguid:        cred.AAGUID,
+		SignCount:     cred.SignCount,
+		Name:          name,
+		CreTimestamp:  now,
+		LastTimestamp: now,
+	}
+
+	err = t.saveNewCredential(ctx, userId, entity)
+	if err != nil {
+		return nil, err
+	}
+
+	return entity, nil
+}
+
+func (t *implWebauthnService) saveNewCredential(ctx context.Context, userId string, entity *pb.WebauthnCredentialEntity) (err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	_, err = t.getCredential(ctx, userId, entity.CredentialId)
+	if err == nil {
+		// excludeCredentials was ignored by the client
+		return ErrWebauthnInvalidResponse
+	}
+	if err != ErrWebauthnCredentialNotFound {
+		return err
+	}
+
+	return t.HostStore.Set(ctx).ByKey("%s:user:webauthn:%s", userId, entity.CredentialId).Proto(entity)
+}
+
+func (t *implWebauthnService) BeginLogin(ctx context.Context, userId string) (string, error) {
+
+	if t.rp == nil {
+		return "", ErrWebauthnNotConfigured
+	}
+
+	userId = utils.NormalizeUserId(userId)
+
+	challenge, err := t.createChallenge(ctx, userId, false)
+	if err != nil {
+		return "", err
+	}
+
+	allow := make([]credentialDescriptor, 0)
+	if userId != "" {
+		err = t.EnumCredentials(ctx, userId, func(cred *pb.WebauthnCredentialEntity) bool {
+			allow = append(allow, credentialDescriptor{Type: "public-key", ID: cred.CredentialId})
+			return true
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	options, err := json.Marshal(map[string]interface{}{
+		"challenge":        challenge,
+		"rpId":             t.rp.ID,
+		"timeout":          t.ChallengeMinutes * 60000,
+		"allowCredentials": allow,
+		"userVerification": t.UserVerification,
+	})
+	return string(options), err
+}
+
+func (t *implWebauthnService) FinishLogin(ctx context.Context, credentialId string, userHandle, clientDataJSON, authenticatorData, signature []byte) (string, bool, error) {
+
+	if t.rp == nil {
+		return "", false, ErrWebauthnNotConfigured
+	}
+
+	challenge, err := t.consumeChallenge(ctx, clientDataJSON)
+	if err != nil {
+		return "", false, err
+	}
+
+	if challenge.entity.Registration {
+		return "", false, ErrWebauthnInvalidResponse
+	}
+
+	userId := challenge.entity.UserId
+	if len(userHandle) > 0 {
+		handle := utils.NormalizeUserId(string(userHandle))
+		if userId != "" && userId != handle {
+			return "", false, ErrWebauthnInvalidResponse
+		}
+		userId = handle
+	}
+
+	if userId == "" {
+		return "", false, ErrWebauthnInvalidResponse
+	}
+
+	userVerified, err := t.doFinishLogin(ctx, userId, credentialId, challenge.value, clientDataJSON, authenticatorData, signature)
+	switch {
+	case err == nil:
+		return userId, userVerified, nil
+	case err == webauthn.ErrSignCount:
+		return userId, false, ErrWebauthnSignCount
+	case err == ErrWebauthnCredentialNotFound || isWebauthnError(err):
+		t.Log.Warn("WebauthnAssertion", zap.String("userId", userId), zap.Error(err))
+		return "", false, ErrWebauthnInvalidResponse
+	default:
+		return "", false, err
+	}
+}
+
+func (t *implWebauthnService) doFinishLogin(ctx context.Context, userId, credentialId, challenge string, clientDataJSON, authenticatorData, signature []byte) (userVerified bool, err error) {
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	entity, err := t.getCredential(ctx, userId, credentialId)
+	if err != nil {
+		return false, err
+	}
+
+	signCount, userVerified, err := t.rp.VerifyAssertion(challenge, &webauthn.Credential{
+		PublicKey: entity.PublicKey,
+		SignCount: entity.SignCount,
+	}, clientDataJSON, authenticatorData, signature)
+	if err != nil {
+		return false, err
+	}
+
+	entity.SignCount = signCount
+	entity.LastTimestamp = time.Now().Unix()
+
+	err = t.HostStore.Set(ctx).ByKey("%s:user:webauthn:%s", userId, entity.CredentialId).Proto(entity)
+	return userVerified, err
+}
+
+func isWebauthnError(err error) bool {
+	switch errors.Cause(err) {
+	case webauthn.ErrInvalidClientData, webauthn.ErrInvalidAuthenticator, webauthn.ErrInvalidSignature, webauthn.ErrUnsupportedKey:
+		return true
+	}
+	return false
+}
+
+func (t *implWebauthnService) getCredential(ctx context.Context, userId, credentialId string) (*pb.WebauthnCredentialEntity, error) {
+
+	credentialId = utils.NormalizeUnreservedCharacters(credentialId)
+	if credentialId == "" || len(credentialId) > maxCredentialIdLength {
+		return nil, ErrWebauthnCredentialNotFound
+	}
+
+	entity := new(pb.WebauthnCredentialEntity)
+	err := t.HostStore.Get(ctx).ByKey("%s:user:webauthn:%s", userId, credentialId).ToProto(entity)
+	if err != nil {
+		return nil, err
+	}
+	if entity.CredentialId != credentialId {
+		return nil, ErrWebauthnCredentialNotFound
+	}
+	return entity, nil
+}
+
+func (t *implWebauthnService) EnumCredentials(ctx context.Context, userId string, cb func(cred *pb.WebauthnCredentialEntity) bool) error {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return errors.New("user id is empty")
+	}
+
+	return t.HostStore.Enumerate(ctx).
+		ByPrefix("%s:user:webauthn:", userId).
+		WithBatchSize(BatchSize).
+		DoProto(func() proto.Message {
+			return new(pb.WebauthnCredentialEntity)
+		}, func(entry *store.ProtoEntry) bool {
+			if v, ok := entry.Value.(*pb.WebauthnCredentialEntity); ok {
+				return cb(v)
+			}
+			return true
+		})
+
+}
+
+func (t *implWebauthnService) RemoveCredential(ctx context.Context, userId, credentialId string) (err error) {
+
+	userId = utils.NormalizeUserId(userId)
+	if userId == "" {
+		return errors.New("user id is empty")
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	entity, err := t.getCredential(ctx, userId, credentialId)
+	if err != nil {
+		return err
+	}
+
+	return t.HostStore.Remove(ctx).ByKey("%s:user:webauthn:%s", userId, entity.CredentialId).Do()
+}
+
+type pendingChallenge struct {
+	value  string
+	entity *pb.WebauthnChallengeEntity
+}
+
+func (t *implWebauthnService) createChallenge(ctx context.Context, userId string, registration bool) (string, error) {
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+
+	err = t.HostStore.Set(ctx).ByKey("webauthn-challenge:%s", challenge).WithTtl(t.ChallengeMinutes * 60).Proto(&pb.WebauthnChallengeEntity{
+		UserId:       userId,
+		Registration: registration,
+		CreTimestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+// challenge is single use, removed before the verification
+func (t *implWebauthnService) consumeChallenge(ctx context.Context, clientDataJSON []byte) (challenge *pendingChallenge, err error) {
+
+	value, err := webauthn.ParseChallenge(clientDataJSON)
+	if err != nil {
+		return nil, ErrWebauthnInvalidResponse
+	}
+
+	value = utils.NormalizeUnreservedCharacters(value)
+	if value == "" {
+		return nil, ErrWebauthnInvalidResponse
+	}
+
+	ctx = t.TransactionalManager.BeginTransaction(ctx, false)
+	defer func() {
+		err = t.TransactionalManager.EndTransaction(ctx, err)
+	}()
+
+	entity := new(pb.WebauthnChallengeEntity)
+	err = t.HostStore.Get(ctx).ByKey("webauthn-challenge:%s", value).ToProto(entity)
+	if err != nil {
+		return nil, err
+	}
+	if entity.CreTimestamp == 0 || time.Now().Unix() - entity.CreTimestamp > int64(t.ChallengeMinutes * 60) {
+		return nil, ErrWebauthnInvalidResponse
+	}
+
+	err = t.HostStore.Remove(ctx).ByKey("webauthn-challenge:%s", value).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pendingChallenge{value: value, entity: entity}, nil
+}
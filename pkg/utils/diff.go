@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package utils
+
+import "strings"
+
+const (
+	DiffEqual   = " "
+	DiffRemoved = "-"
+	DiffAdded   = "+"
+
+	diffMaxCells = 4000000 // table size of the longest common subsequence, bigger texts are replaced as a whole
+)
+
+type DiffLine struct {
+	Op       string
+	Text     string
+	FromLine int  // zero for added lines
+	ToLine   int  // zero for removed lines
+}
+
+/**
+Line based diff of two texts by the longest common subsequence, removed lines go before the added ones.
+ */
+func DiffText(from, to string) []DiffLine {
+	return DiffLines(splitLines(from), splitLines(to))
+}
+
+func DiffLines(a, b []string) []DiffLine {
+
+	// common head and tail do not need the table
+	head := 0
+	for head < len(a) && head < len(b) && a[head] == b[head] {
+		head++
+	}
+	tail := 0
+	for tail < len(a)-head && tail < len(b)-head && a[len(a)-1-tail] == b[len(b)-1-tail] {
+		tail++
+	}
+
+	var out []DiffLine
+	for i := 0; i < head; i++ {
+		out = append(out, DiffLine{Op: DiffEqual, Text: a[i], FromLine: i + 1, ToLine: i + 1})
+	}
+
+	out = append(out, diffMiddle(a[head:len(a)-tail], b[head:len(b)-tail], head)...)
+
+	for i := 0; i < tail; i++ {
+		ai, bi := len(a)-tail+i, len(b)-tail+i
+		out = append(out, DiffLine{Op: DiffEqual, Text: a[ai], FromLine: ai + 1, ToLine: bi + 1})
+	}
+
+	return out
+}
+
+func diffMiddle(a, b []string, offset int) []DiffLine {
+
+	n, m := len(a), len(b)
+	var out []DiffLine
+
+	if n * m > diffMaxCells {
+		for i, line := range a {
+			out = append(out, DiffLine{Op: DiffRemoved, Text: line, FromLine: offset + i + 1})
+		}
+		for j, line := range b {
+			out = append(out, DiffLine{Op: DiffAdded, Text: line, ToLine: offset + j + 1})
+		}
+		return out
+	}
+
+	// lcs[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			out = append(out, DiffLine{Op: DiffEqual, Text: a[i], FromLine: offset + i + 1, ToLine: offset + j + 1})
+			i++
+			j++
+		case j == m || i < n && lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, DiffLine{Op: DiffRemoved, Text: a[i], FromLine: offset + i + 1})
+			i++
+		default:
+			out = append(out, DiffLine{Op: DiffAdded, Text: b[j], ToLine: offset + j + 1})
+			j++
+		}
+	}
+
+	return out
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n"), "\n")
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package utils
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type highlightLang struct {
+	keywords     map[string]bool
+	lineComments []string
+	blockComment bool   // /* */
+	quotes       string
+}
+
+var highlightLangs = map[string]*highlightLang{
+	"go": {
+		keywords: wordSet("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false"),
+		lineComments: []string{"//"}, blockComment: true, quotes: "\"'`",
+	},
+	"js": {
+		keywords: wordSet("async await break case catch class const continue default delete do else export extends false finally for function if import in instanceof let new null return super switch this throw true try typeof undefined var void while yield"),
+		lineComments: []string{"//"}, blockComment: true, quotes: "\"'`",
+	},
+	"java": {
+		keywords: wordSet("abstract boolean break byte case catch char class continue default do double else enum extends final finally float for if implements import instanceof int interface long new null package private protected public return short static super switch this throw throws true false try void while"),
+		lineComments: []string{"//"}, blockComment: true, quotes: "\"'",
+	},
+	"python": {
+		keywords: wordSet("and as assert async await break class continue def del elif else except False finally for from global if import in is lambda None nonlocal not or pass raise return True try while with yield"),
+		lineComments: []string{"#"}, quotes: "\"'",
+	},
+	"sh": {
+		keywords: wordSet("if then else elif fi for while until do done case esac in function return export local"),
+		lineComments: []string{"#"}, quotes: "\"'",
+	},
+	"sql": {
+		keywords: wordSet("select from where and or not insert into values update set delete create table drop alter index join left right inner outer on group by order having limit as null is in like distinct"),
+		lineComments: []string{"--"}, blockComment: true, quotes: "'\"",
+	},
+}
+
+var highlightAliases = map[string]string{
+	"golang":     "go",
+	"javascript": "js",
+	"ts":         "js",
+	"typescript": "js",
+	"json":       "js",
+	"kotlin":     "java",
+	"c":          "java",
+	"cpp":        "java",
+	"py":         "python",
+	"bash":       "sh",
+	"shell":      "sh",
+}
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+/**
+Escapes the code and wraps keywords, strings, numbers and comments to spans with hl-kw, hl-str, hl-num and hl-com classes.
+Unknown languages are escaped only.
+ */
+func HighlightCode(lang, code string) string {
+
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if alias, ok := highlightAliases[lang]; ok {
+		lang = alias
+	}
+	def, ok := highlightLangs[lang]
+	if !ok {
+		return html.EscapeString(code)
+	}
+
+	var out strings.Builder
+	span := func(class, text string) {
+		out.WriteString(`<span class="`)
+		out.WriteString(class)
+		out.WriteString(`">`)
+		out.WriteString(html.EscapeString(text))
+		out.WriteString("</span>")
+	}
+
+	for i := 0; i < len(code); {
+
+		rest := code[i:]
+		c, size := utf8.DecodeRuneInString(rest)
+
+		if lineComment(def, rest) {
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			span("hl-com", rest[:end])
+			i += end
+			continue
+		}
+
+		if def.blockComment && strings.HasPrefix(rest, "/*") {
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += 4
+			}
+			span("hl-com", rest[:end])
+			i += end
+			continue
+		}
+
+		if c < utf8.RuneSelf && strings.IndexByte(def.quotes, byte(c)) >= 0 {
+			q := byte(c)
+			j := 1
+			for j < len(rest) && rest[j] != q && (rest[j] != '\n' || q == '`') {
+				if rest[j] == '\\' && q != '`' {
+					j++
+				}
+				j++
+			}
+			if j < len(rest) {
+				j++
+			}
+			if j > len(rest) {
+				j = len(rest)
+			}
+			span("hl-str", rest[:j])
+			i += j
+			continue
+		}
+
+		if unicode.IsLetter(c) || c == '_' {
+			j := wordEnd(rest, true)
+			word := rest[:j]
+			if def.keywords[word] || lang == "sql" && def.keywords[strings.ToLower(word)] {
+				span("hl-kw", word)
+			} else {
+				out.WriteString(html.EscapeString(word))
+			}
+			i += j
+			continue
+		}
+
+		if unicode.IsDigit(c) {
+			j := wordEnd(rest, false)
+			span("hl-num", rest[:j])
+			i += j
+			continue
+		}
+
+		out.WriteString(html.EscapeString(rest[:size]))
+		i += size
+	}
+
+	return out.String()
+}
+
+func lineComment(def *highlightLang, rest string) bool {
+	for _, prefix := range def.lineComments {
+		if strings.HasPrefix(rest, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// end of the identifier or the number
+func wordEnd(s string, identifier bool) int {
+	for i, r := range s {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || !identifier && r == '.') {
+			return i
+		}
+	}
+	return len(s)
+}
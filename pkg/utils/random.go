@@ -0,0 +1,40 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"strings"
+)
+
+const (
+	AlphaNumericAlphabet = "abcdefghijkmnpqrstuvwxyz23456789" // without look-alike characters
+	NumericAlphabet = "0123456789"
+)
+
+func RandomString(alphabet string, length int) (string, error) {
+
+	var out strings.Builder
+	max := big.NewInt(int64(len(alphabet)))
+
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		out.WriteByte(alphabet[n.Int64()])
+	}
+
+	return out.String(), nil
+}
+
+func HashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,204 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package utils
+
+import (
+	"golang.org/x/net/html"
+	"regexp"
+	"strings"
+)
+
+var (
+	// elements removed together with the content
+	htmlDropContent = map[string]bool{
+		"script":   true,
+		"style":    true,
+		"iframe":   true,
+		"object":   true,
+		"embed":    true,
+		"template": true,
+		"noscript": true,
+		"textarea": true,
+		"select":   true,
+		"svg":      true,
+		"math":     true,
+	}
+
+	// never allowed whatever the configuration says, on* handlers are checked by the prefix
+	htmlDeniedAttributes = map[string]bool{
+		"style":      true,
+		"srcdoc":     true,
+		"formaction": true,
+	}
+
+	htmlUrlAttributes = map[string]bool{
+		"href": true,
+		"src":  true,
+		"cite": true,
+	}
+
+	htmlSafeClass = regexp.MustCompile(`^[A-Za-z0-9_\- ]*$`)
+	htmlSafeId = regexp.MustCompile(`^[A-Za-z0-9_\-]*$`)
+)
+
+/**
+Allowlist of HTML elements, attributes and URL schemes. Elements out of the list are removed with the tags only,
+the text inside stays escaped. Comments, event handlers, inline styles, srcdoc and formaction are removed always.
+ */
+type HTMLPolicy struct {
+	Tags       map[string]bool
+	Attributes map[string]bool
+	URLSchemes map[string]bool
+}
+
+// lists are separated by spaces or commas
+func NewHTMLPolicy(tags, attributes, schemes string) *HTMLPolicy {
+	return &HTMLPolicy{
+		Tags:       splitSet(tags),
+		Attributes: splitSet(attributes),
+		URLSchemes: splitSet(schemes),
+	}
+}
+
+func splitSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range strings.FieldsFunc(strings.ToLower(list), func(r rune) bool {
+		return r == ' ' || r == ','
+	}) {
+		set[item] = true
+	}
+	return set
+}
+
+func (p *HTMLPolicy) Sanitize(content string) string {
+
+	var out strings.Builder
+	z := html.NewTokenizer(strings.NewReader(content))
+
+	drop := ""
+	depth := 0
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			// io.EOF or the unparsable rest, it is dropped
+			return out.String()
+		}
+
+		token := z.Token()
+
+		if drop != "" {
+			switch {
+			case tt == html.StartTagToken && token.Data == drop:
+				depth++
+			case tt == html.EndTagToken && token.Data == drop:
+				depth--
+				if depth == 0 {
+					drop = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			out.WriteString(html.EscapeString(token.Data))
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if htmlDropContent[token.Data] {
+				if tt == html.StartTagToken {
+					drop = token.Data
+					depth = 1
+				}
+				continue
+			}
+			if !p.Tags[token.Data] {
+				continue
+			}
+			out.WriteByte('<')
+			out.WriteString(token.Data)
+			for _, attr := range token.Attr {
+				if p.allowAttribute(attr) {
+					out.WriteByte(' ')
+					out.WriteString(attr.Key)
+					out.WriteString(`="`)
+					out.WriteString(html.EscapeString(attr.Val))
+					out.WriteByte('"')
+				}
+			}
+			if token.Data == "a" && p.externalLink(token.Attr) {
+				out.WriteString(` rel="nofollow noopener"`)
+			}
+			if tt == html.SelfClosingTagToken {
+				out.WriteString(" /")
+			}
+			out.WriteByte('>')
+
+		case html.EndTagToken:
+			if p.Tags[token.Data] {
+				out.WriteString("</")
+				out.WriteString(token.Data)
+				out.WriteByte('>')
+			}
+		}
+	}
+}
+
+func (p *HTMLPolicy) allowAttribute(attr html.Attribute) bool {
+
+	if attr.Namespace != "" || htmlDeniedAttributes[attr.Key] || strings.HasPrefix(attr.Key, "on") {
+		return false
+	}
+
+	if !p.Attributes[attr.Key] {
+		return false
+	}
+
+	switch attr.Key {
+	case "class":
+		return htmlSafeClass.MatchString(attr.Val)
+	case "id":
+		return htmlSafeId.MatchString(attr.Val)
+	case "rel":
+		return false
+	}
+
+	if htmlUrlAttributes[attr.Key] {
+		return p.allowURL(attr.Val)
+	}
+
+	return true
+}
+
+func (p *HTMLPolicy) allowURL(value string) bool {
+
+	// browsers ignore control characters and spaces in the scheme
+	url := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, value)
+
+	i := strings.IndexAny(url, ":/?#")
+	if i < 0 || url[i] != ':' {
+		// relative
+		return true
+	}
+
+	return p.URLSchemes[strings.ToLower(url[:i])]
+}
+
+// absolute link allowed by the policy
+func (p *HTMLPolicy) externalLink(attrs []html.Attribute) bool {
+	for _, attr := range attrs {
+		if attr.Key == "href" && p.allowAttribute(attr) {
+			href := strings.TrimSpace(attr.Val)
+			return strings.HasPrefix(href, "//") || strings.Contains(href, ":")
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package utils_test
+
+import (
+	"github.com/sprintframework/template/pkg/utils"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+
+	policy := utils.NewHTMLPolicy("a p b img code span", "href src alt class", "http https")
+
+	vectors := map[string]string{
+		`<p>Hello <b>world</b></p>`:                         `<p>Hello <b>world</b></p>`,
+		`<script>alert(1)</script><p>x</p>`:                 `<p>x</p>`,
+		`<p onclick="alert(1)">x</p>`:                       `<p>x</p>`,
+		`<a href="javascript:alert(1)">x</a>`:               `<a>x</a>`,
+		`<a href="jav&#x09;ascript:alert(1)">x</a>`:         `<a>x</a>`,
+		`<a href="https://example.com/?a=1&b=2">x</a>`:      `<a href="https://example.com/?a=1&amp;b=2" rel="nofollow noopener">x</a>`,
+		`<a href="/page?x=1">x</a>`:                         `<a href="/page?x=1">x</a>`,
+		`<img src="data:image/png;base64,AAA" alt="a"/>`:    `<img alt="a" />`,
+		`<div><iframe src="https://evil"></iframe>text</div>`: `text`,
+		`<span class="hl-kw">func</span>`:                  `<span class="hl-kw">func</span>`,
+		`<span class="x&quot; onload=&quot;y">z</span>`:     `<span>z</span>`,
+		`<!-- comment --><p>&lt;b&gt;</p>`:                  `<p>&lt;b&gt;</p>`,
+		`<svg><script>alert(1)</script></svg>after`:         `after`,
+	}
+
+	for input, expected := range vectors {
+		require.Equal(t, expected, policy.Sanitize(input), input)
+	}
+
+	// dangerous attributes are removed even if the configuration allows them
+	unsafe := utils.NewHTMLPolicy("p iframe button", "onclick onmouseover style srcdoc formaction title", "http https")
+	require.Equal(t, `<p title="t">x</p>`, unsafe.Sanitize(`<p onclick="alert(1)" ONMOUSEOVER="alert(2)" style="background:url(x)" title="t">x</p>`))
+	require.Equal(t, `<button>x</button>`, utils.NewHTMLPolicy("button", "formaction", "").Sanitize(`<button formaction="https://evil">x</button>`))
+	require.Equal(t, `<p>x</p>`, unsafe.Sanitize(`<p srcdoc="&lt;script&gt;">x</p>`))
+
+}
+
+func TestHighlightCode(t *testing.T) {
+
+	require.Equal(t,
+		`<span class="hl-kw">func</span> main() { x := <span class="hl-str">&#34;&lt;b&gt;&#34;</span> <span class="hl-com">// done</span>`,
+		utils.HighlightCode("go", `func main() { x := "<b>" // done`))
+
+	require.Equal(t, `&lt;script&gt;`, utils.HighlightCode("unknown", `<script>`))
+
+	require.Equal(t, `<span class="hl-kw">SELECT</span> <span class="hl-num">1</span>`, utils.HighlightCode("sql", `SELECT 1`))
+
+}
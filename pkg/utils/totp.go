@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 defaults supported by all authenticator applications
+const (
+	TotpDigits     = 6
+	TotpPeriod     = 30
+	TotpSecretSize = 20
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenerateTotpSecret() (string, error) {
+	secret := make([]byte, TotpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+func TotpStep(t time.Time) int64 {
+	return t.Unix() / TotpPeriod
+}
+
+func TotpCode(secret string, step int64) (string, error) {
+
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// RFC 4226 section 5.3 dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", TotpDigits, value%1000000), nil
+}
+
+/**
+Validates the code within the window of +/- skew time steps.
+Returns the matched time step, the caller must not accept the step twice.
+ */
+func ValidateTotp(secret, code string, t time.Time, skew int) (int64, bool) {
+
+	code = strings.TrimSpace(code)
+	if len(code) != TotpDigits {
+		return 0, false
+	}
+
+	current := TotpStep(t)
+	for i := -skew; i <= skew; i++ {
+		step := current + int64(i)
+		expected, err := TotpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func TotpProvisioningURI(issuer, account, secret string) string {
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", TotpDigits))
+	params.Set("period", fmt.Sprintf("%d", TotpPeriod))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
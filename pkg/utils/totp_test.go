@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package utils_test
+
+import (
+	"github.com/sprintframework/template/pkg/utils"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B secret "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTotpCode(t *testing.T) {
+
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for ts, expected := range vectors {
+		code, err := utils.TotpCode(rfcSecret, utils.TotpStep(time.Unix(ts, 0)))
+		require.NoError(t, err)
+		require.Equal(t, expected, code)
+	}
+
+}
+
+func TestValidateTotp(t *testing.T) {
+
+	secret, err := utils.GenerateTotpSecret()
+	require.NoError(t, err)
+
+	now := time.Now()
+	code, err := utils.TotpCode(secret, utils.TotpStep(now.Add(-utils.TotpPeriod*time.Second)))
+	require.NoError(t, err)
+
+	step, ok := utils.ValidateTotp(secret, code, now, 1)
+	require.True(t, ok)
+	require.Equal(t, utils.TotpStep(now)-1, step)
+
+	_, ok = utils.ValidateTotp(secret, code, now, 0)
+	require.False(t, ok)
+
+	uri := utils.TotpProvisioningURI("Light Template", "test@test.com", secret)
+	require.True(t, strings.HasPrefix(uri, "otpauth://totp/Light%20Template:test@test.com?"))
+	require.True(t, strings.Contains(uri, "secret="+secret))
+
+}
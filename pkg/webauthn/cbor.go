@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package webauthn
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+)
+
+/**
+Minimal CBOR decoder, only definite length items used by CTAP2 canonical encoding.
+Integers are decoded to int64, byte strings to []byte, text to string,
+arrays to []interface{} and maps to map[interface{}]interface{}.
+ */
+
+const (
+	maxCborDepth = 16
+)
+
+var errCborTruncated = errors.New("cbor: truncated input")
+
+func decodeCbor(data []byte) (interface{}, []byte, error) {
+	return decodeCborItem(data, 0)
+}
+
+func decodeCborItem(data []byte, depth int) (interface{}, []byte, error) {
+
+	if depth > maxCborDepth {
+		return nil, nil, errors.New("cbor: too deep")
+	}
+
+	if len(data) == 0 {
+		return nil, nil, errCborTruncated
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data[1:], nil
+		case 21:
+			return true, data[1:], nil
+		case 22, 23:
+			return nil, data[1:], nil
+		default:
+			return nil, nil, errors.Errorf("cbor: unsupported simple value %d", info)
+		}
+	}
+
+	arg, rest, err := decodeCborArgument(info, data[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+		return int64(arg), rest, nil
+
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+		return -1 - int64(arg), rest, nil
+
+	case 2, 3:
+		if uint64(len(rest)) < arg {
+			return nil, nil, errCborTruncated
+		}
+		if major == 2 {
+			return rest[:arg], rest[arg:], nil
+		}
+		return string(rest[:arg]), rest[arg:], nil
+
+	case 4:
+		if uint64(len(rest)) < arg {
+			return nil, nil, errCborTruncated
+		}
+		list := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			item, rest, err = decodeCborItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			list = append(list, item)
+		}
+		return list, rest, nil
+
+	case 5:
+		if uint64(len(rest)) < arg*2 {
+			return nil, nil, errCborTruncated
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			key, rest, err = decodeCborItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("cbor: unsupported map key")
+			}
+			value, rest, err = decodeCborItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key] = value
+		}
+		return m, rest, nil
+
+	default:
+		return nil, nil, errors.Errorf("cbor: unsupported major type %d", major)
+	}
+}
+
+func decodeCborArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		if len(data) < 1 {
+			return 0, nil, errCborTruncated
+		}
+		return uint64(data[0]), data[1:], nil
+	case info == 25:
+		if len(data) < 2 {
+			return 0, nil, errCborTruncated
+		}
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26:
+		if len(data) < 4 {
+			return 0, nil, errCborTruncated
+		}
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27:
+		if len(data) < 8 {
+			return 0, nil, errCborTruncated
+		}
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	default:
+		return 0, nil, errors.New("cbor: indefinite length is not supported")
+	}
+}
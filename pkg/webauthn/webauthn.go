@@ -0,0 +1,344 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+/**
+Package webauthn is a minimal WebAuthn relying party: registration with "none" attestation
+and assertion verification for ES256, RS256 and EdDSA credentials.
+ */
+
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"math/big"
+)
+
+const (
+	challengeSize = 32
+
+	typeCreate = "webauthn.create"
+	typeGet    = "webauthn.get"
+
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+var (
+	ErrInvalidClientData    = errors.New("invalid client data")
+	ErrInvalidAuthenticator = errors.New("invalid authenticator data")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrUnsupportedKey       = errors.New("unsupported credential public key")
+	ErrSignCount            = errors.New("signature counter did not increase")
+)
+
+/**
+Supported COSE algorithms in the order of preference, used in pubKeyCredParams.
+ */
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+
+	// require the UV flag in addition to UP
+	UserVerification bool
+}
+
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE_Key
+	AAGUID    []byte
+	SignCount uint32
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIdHash  []byte
+	flags     byte
+	signCount uint32
+	aaguid    []byte
+	credId    []byte
+	publicKey []byte
+}
+
+func NewChallenge() (string, error) {
+	b := make([]byte, challengeSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+/**
+Parses clientDataJSON and returns the challenge, the caller uses it to find the pending ceremony.
+ */
+func ParseChallenge(clientDataJSON []byte) (string, error) {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return "", ErrInvalidClientData
+	}
+	if cd.Challenge == "" {
+		return "", ErrInvalidClientData
+	}
+	return cd.Challenge, nil
+}
+
+/**
+Verifies the response of navigator.credentials.create(). Attestation statement is not verified,
+the options always request attestation "none".
+ */
+func (rp *RelyingParty) VerifyRegistration(challenge string, clientDataJSON, attestationObject []byte) (*Credential, error) {
+
+	if err := rp.verifyClientData(typeCreate, challenge, clientDataJSON); err != nil {
+		return nil, err
+	}
+
+	obj, _, err := decodeCbor(attestationObject)
+	if err != nil {
+		return nil, errors.Wrap(err, "attestation object")
+	}
+
+	m, ok := obj.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidAuthenticator
+	}
+
+	raw, ok := m["authData"].([]byte)
+	if !ok {
+		return nil, ErrInvalidAuthenticator
+	}
+
+	ad, err := rp.parseAuthenticatorData(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if ad.flags&flagAttestedCredData == 0 || len(ad.credId) == 0 {
+		return nil, ErrInvalidAuthenticator
+	}
+
+	if _, err := ParsePublicKey(ad.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        ad.credId,
+		PublicKey: ad.publicKey,
+		AAGUID:    ad.aaguid,
+		SignCount: ad.signCount,
+	}, nil
+}
+
+/**
+Verifies the response of navigator.credentials.get() and returns the new signature counter
+and the UV flag. The counter must increase unless both stored and received values are zero,
+otherwise the authenticator may be cloned and ErrSignCount is returned.
+ */
+func (rp *RelyingParty) VerifyAssertion(challenge string, cred *Credential, clientDataJSON, authData, signature []byte) (signCount uint32, userVerified bool, err error) {
+
+	if err = rp.verifyClientData(typeGet, challenge, clientDataJSON); err != nil {
+		return 0, false, err
+	}
+
+	ad, err := rp.parseAuthenticatorData(authData)
+	if err != nil {
+		return 0, false, err
+	}
+
+	publicKey, err := ParsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, false, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := make([]byte, 0, len(authData)+len(clientDataHash))
+	signed = append(signed, authData...)
+	signed = append(signed, clientDataHash[:]...)
+
+	if !verifySignature(publicKey, signed, signature) {
+		return 0, false, ErrInvalidSignature
+	}
+
+	userVerified = ad.flags&flagUserVerified != 0
+
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return ad.signCount, userVerified, ErrSignCount
+	}
+
+	return ad.signCount, userVerified, nil
+}
+
+func (rp *RelyingParty) verifyClientData(expectedType, challenge string, clientDataJSON []byte) error {
+
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return ErrInvalidClientData
+	}
+
+	if cd.Type != expectedType {
+		return errors.Wrapf(ErrInvalidClientData, "type '%s'", cd.Type)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return errors.Wrap(ErrInvalidClientData, "challenge mismatch")
+	}
+
+	for _, origin := range rp.Origins {
+		if cd.Origin == origin {
+			return nil
+		}
+	}
+
+	return errors.Wrapf(ErrInvalidClientData, "origin '%s'", cd.Origin)
+}
+
+func (rp *RelyingParty) parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+
+	if len(data) < 37 {
+		return nil, ErrInvalidAuthenticator
+	}
+
+	ad := &authenticatorData{
+		rpIdHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	rpIdHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.rpIdHash, rpIdHash[:]) {
+		return nil, errors.Wrap(ErrInvalidAuthenticator, "rp id mismatch")
+	}
+
+	if ad.flags&flagUserPresent == 0 {
+		return nil, errors.Wrap(ErrInvalidAuthenticator, "user not present")
+	}
+
+	if rp.UserVerification && ad.flags&flagUserVerified == 0 {
+		return nil, errors.Wrap(ErrInvalidAuthenticator, "user not verified")
+	}
+
+	if ad.flags&flagAttestedCredData != 0 {
+
+		rest := data[37:]
+		if len(rest) < 18 {
+			return nil, ErrInvalidAuthenticator
+		}
+
+		ad.aaguid = rest[:16]
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < n {
+			return nil, ErrInvalidAuthenticator
+		}
+		ad.credId = rest[:n]
+		rest = rest[n:]
+
+		_, tail, err := decodeCbor(rest)
+		if err != nil {
+			return nil, errors.Wrap(err, "credential public key")
+		}
+		ad.publicKey = rest[:len(rest)-len(tail)]
+	}
+
+	return ad, nil
+}
+
+/**
+Parses COSE_Key to the crypto public key.
+ */
+func ParsePublicKey(coseKey []byte) (crypto.PublicKey, error) {
+
+	obj, _, err := decodeCbor(coseKey)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := obj.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrUnsupportedKey
+		}
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, ErrUnsupportedKey
+		}
+		return key, nil
+
+	case kty == 3 && alg == AlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrUnsupportedKey
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKey
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+func verifySignature(publicKey crypto.PublicKey, signed, signature []byte) bool {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, signed, signature)
+	default:
+		return false
+	}
+}
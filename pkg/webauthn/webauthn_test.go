@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package webauthn_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/sprintframework/template/pkg/webauthn"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const (
+	testRpId   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+/**
+Software authenticator with a single ES256 credential.
+ */
+type testAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	credId    []byte
+	signCount uint32
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &testAuthenticator{
+		key:    key,
+		credId: []byte("credential-1"),
+	}
+}
+
+func (a *testAuthenticator) clientData(typ, challenge, origin string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": challenge,
+		"origin":    origin,
+	})
+	return data
+}
+
+func (a *testAuthenticator) authData(rpId string, attested bool) []byte {
+
+	rpIdHash := sha256.Sum256([]byte(rpId))
+	data := append([]byte{}, rpIdHash[:]...)
+
+	flags := byte(0x01 | 0x04)
+	if attested {
+		flags |= 0x40
+	}
+	data = append(data, flags)
+
+	var counter [4]byte
+	binary.BigEndian.PutUint32(counter[:], a.signCount)
+	data = append(data, counter[:]...)
+
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		var n [2]byte
+		binary.BigEndian.PutUint16(n[:], uint16(len(a.credId)))
+		data = append(data, n[:]...)
+		data = append(data, a.credId...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func (a *testAuthenticator) coseKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	// {1: 2, 3: -7, -1: 1, -2: x, -3: y}
+	key := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}
+	key = append(key, x...)
+	key = append(key, 0x22, 0x58, 0x20)
+	return append(key, y...)
+}
+
+func (a *testAuthenticator) create(t *testing.T, challenge string) (clientDataJSON, attestationObject []byte) {
+
+	authData := a.authData(testRpId, true)
+
+	// {"fmt": "none", "attStmt": {}, "authData": bytes}
+	obj := []byte{0xa3, 0x63, 'f', 'm', 't', 0x64, 'n', 'o', 'n', 'e'}
+	obj = append(obj, 0x67, 'a', 't', 't', 'S', 't', 'm', 't', 0xa0)
+	obj = append(obj, 0x68, 'a', 'u', 't', 'h', 'D', 'a', 't', 'a', 0x59)
+	var n [2]byte
+	binary.BigEndian.PutUint16(n[:], uint16(len(authData)))
+	obj = append(obj, n[:]...)
+	obj = append(obj, authData...)
+
+	return a.clientData("webauthn.create", challenge, testOrigin), obj
+}
+
+func (a *testAuthenticator) get(t *testing.T, challenge, origin string) (clientDataJSON, authData, signature []byte) {
+
+	a.signCount++
+
+	clientDataJSON = a.clientData("webauthn.get", challenge, origin)
+	authData = a.authData(testRpId, false)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	require.NoError(t, err)
+	return
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+
+	rp := &webauthn.RelyingParty{
+		ID:      testRpId,
+		Origins: []string{testOrigin},
+	}
+
+	auth := newTestAuthenticator(t)
+
+	challenge, err := webauthn.NewChallenge()
+	require.NoError(t, err)
+
+	clientDataJSON, attestationObject := auth.create(t, challenge)
+
+	parsed, err := webauthn.ParseChallenge(clientDataJSON)
+	require.NoError(t, err)
+	require.Equal(t, challenge, parsed)
+
+	_, err = rp.VerifyRegistration("other", clientDataJSON, attestationObject)
+	require.Error(t, err)
+
+	cred, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	require.NoError(t, err)
+	require.Equal(t, auth.credId, cred.ID)
+
+	challenge, err = webauthn.NewChallenge()
+	require.NoError(t, err)
+
+	clientDataJSON, authData, signature := auth.get(t, challenge, testOrigin)
+
+	// registration response can not be used as assertion
+	_, _, err = rp.VerifyAssertion(challenge, cred, clientDataJSON[:len(clientDataJSON)-1], authData, signature)
+	require.Error(t, err)
+
+	signCount, userVerified, err := rp.VerifyAssertion(challenge, cred, clientDataJSON, authData, signature)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), signCount)
+	require.True(t, userVerified)
+	cred.SignCount = signCount
+
+	// replayed assertion has the same counter
+	_, _, err = rp.VerifyAssertion(challenge, cred, clientDataJSON, authData, signature)
+	require.Equal(t, webauthn.ErrSignCount, err)
+
+	// wrong origin
+	clientDataJSON, authData, signature = auth.get(t, challenge, "https://evil.com")
+	_, _, err = rp.VerifyAssertion(challenge, cred, clientDataJSON, authData, signature)
+	require.Error(t, err)
+
+	// tampered signature
+	clientDataJSON, authData, signature = auth.get(t, challenge, testOrigin)
+	signature[len(signature)-1] ^= 0xff
+	_, _, err = rp.VerifyAssertion(challenge, cred, clientDataJSON, authData, signature)
+	require.Equal(t, webauthn.ErrInvalidSignature, err)
+
+}
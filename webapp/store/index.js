@@ -5,5 +5,9 @@ export const getters = {
 
   loggedInUser(state) {
     return state.auth.user
+  },
+
+  isImpersonating(state) {
+    return state.auth.loggedIn && !!state.auth.user.impersonator
   }
 }